@@ -0,0 +1,99 @@
+package file_integrity
+
+import (
+	"time"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultBackpressureMaxWait caps the wait between events when no
+	// max_wait is configured.
+	defaultBackpressureMaxWait = time.Second
+
+	// backpressureSmoothing is the number of sends over which the average
+	// send block time mostly adapts to a change.
+	backpressureSmoothing = 8
+)
+
+// BackpressureConfig makes the scanner slow down when the consumer of its
+// events falls behind, as measured by how long sends on the event channel
+// block.
+type BackpressureConfig struct {
+	Threshold time.Duration `config:"threshold"` // Average send block time above which the scanner waits between events. Zero disables the throttle.
+	MaxWait   time.Duration `config:"max_wait"`  // Cap on the wait between events. Defaults to one second.
+}
+
+func (c *BackpressureConfig) validate() error {
+	var errs multierror.Errors
+	if c.Threshold < 0 {
+		errs = append(errs, errors.Errorf("backpressure.threshold value (%v) must not be negative", c.Threshold))
+	}
+	if c.MaxWait < 0 {
+		errs = append(errs, errors.Errorf("backpressure.max_wait value (%v) must not be negative", c.MaxWait))
+	}
+	return errs.Err()
+}
+
+// backpressure is an adaptive throttle. While the average send block time is
+// above the threshold the wait between events doubles, starting at the
+// threshold, and otherwise it halves.
+type backpressure struct {
+	threshold time.Duration
+	maxWait   time.Duration
+	now       func() time.Time // Measures the send block time. Replaced by tests.
+
+	avg    time.Duration // Moving average of the send block time.
+	wait   time.Duration // Current wait between events.
+	waited time.Duration // Total time waited.
+}
+
+// newBackpressure returns the throttle or nil if it is disabled.
+func newBackpressure(c BackpressureConfig) *backpressure {
+	if c.Threshold <= 0 {
+		return nil
+	}
+	b := &backpressure{threshold: c.Threshold, maxWait: c.MaxWait, now: time.Now}
+	if b.maxWait <= 0 {
+		b.maxWait = defaultBackpressureMaxWait
+	}
+	return b
+}
+
+// observe records how long a send blocked and returns how long the scanner
+// waits before producing the next event.
+func (b *backpressure) observe(blocked time.Duration) time.Duration {
+	b.avg += (blocked - b.avg) / backpressureSmoothing
+	switch {
+	case b.avg <= b.threshold:
+		b.wait /= 2
+	case b.wait == 0:
+		b.wait = b.threshold
+	default:
+		b.wait *= 2
+	}
+	if b.wait > b.maxWait {
+		b.wait = b.maxWait
+	}
+	b.waited += b.wait
+	return b.wait
+}
+
+// backOff records how long the last send blocked and waits as long as
+// required by the backpressure throttle. It returns errDone if the scanner is
+// stopped while waiting.
+func (s *scanner) backOff(blocked time.Duration) error {
+	wait := s.backpressure.observe(blocked)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-s.done:
+		return errDone
+	}
+}
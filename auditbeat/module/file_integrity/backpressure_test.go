@@ -0,0 +1,108 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackpressure(t *testing.T) {
+	b := newBackpressure(BackpressureConfig{Threshold: time.Millisecond, MaxWait: 8 * time.Millisecond})
+
+	// The wait doubles while the average send block time exceeds the
+	// threshold and is capped.
+	var waits []time.Duration
+	for i := 0; i < 6; i++ {
+		waits = append(waits, b.observe(100*time.Millisecond))
+	}
+	assert.Equal(t, []time.Duration{
+		time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond,
+		8 * time.Millisecond, 8 * time.Millisecond, 8 * time.Millisecond,
+	}, waits)
+
+	// It halves once the consumer keeps up again.
+	for b.avg > b.threshold {
+		b.observe(0)
+	}
+	wait := b.wait
+	assert.Equal(t, wait/2, b.observe(0))
+
+	assert.Nil(t, newBackpressure(BackpressureConfig{}))
+	assert.Error(t, (&BackpressureConfig{Threshold: -1}).validate())
+}
+
+func TestScannerBackpressure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-backpressure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err = ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Backpressure = BackpressureConfig{Threshold: time.Millisecond, MaxWait: 4 * time.Millisecond}
+
+	// scan consumes the events of a scan. Sends rendezvous with the consumer
+	// and the clock of the throttle only advances when an event is received,
+	// by the time that the consumer pretends to have blocked the send, so the
+	// measured send block time does not depend on scheduling.
+	scan := func(blocked time.Duration) (*scanner, int) {
+		reader, err := NewFileSystemScanner(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := reader.(*scanner)
+		clock := make(chan time.Time)
+		s.backpressure.now = func() time.Time { return <-clock }
+		s.eventC = make(chan Event)
+
+		done := make(chan struct{})
+		defer close(done)
+		eventC, err := reader.Start(done)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var now time.Time
+		var count int
+		for {
+			select {
+			case clock <- now:
+			case _, ok := <-eventC:
+				if !ok {
+					return s, count
+				}
+				now = now.Add(blocked)
+				count++
+			}
+		}
+	}
+
+	// A consumer that keeps up does not slow down the scanner.
+	s, _ := scan(0)
+	assert.Zero(t, s.backpressure.waited)
+
+	// With a slow consumer the scanner waits between events instead of
+	// blocking on sends. The wait starts at the threshold, doubles up to
+	// max_wait, and then stays there.
+	s, count := scan(100 * time.Millisecond)
+	expected := time.Millisecond + 2*time.Millisecond + time.Duration(count-2)*4*time.Millisecond
+	assert.Equal(t, expected, s.backpressure.waited)
+	assert.Equal(t, config.Backpressure.MaxWait, s.backpressure.wait)
+}
@@ -0,0 +1,100 @@
+package file_integrity
+
+import "time"
+
+const (
+	defaultBatchSize          = 100
+	defaultBatchFlushInterval = time.Second
+)
+
+// BatchEventProducer produces batches of events.
+type BatchEventProducer interface {
+	EventProducer
+
+	// StartBatched starts the event producer like Start does but groups the
+	// events into batches. A batch is emitted when it reaches the configured
+	// BatchSize or when BatchFlushInterval has elapsed since its first event,
+	// whichever happens first. The final partial batch is emitted before the
+	// returned channel is closed.
+	StartBatched(done <-chan struct{}) (<-chan []Event, error)
+}
+
+// StartBatched starts the scanner and returns a channel of event batches.
+func (s *scanner) StartBatched(done <-chan struct{}) (<-chan []Event, error) {
+	eventC, err := s.Start(done)
+	if err != nil {
+		return nil, err
+	}
+
+	size := s.config.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	interval := s.config.BatchFlushInterval
+	if interval <= 0 {
+		interval = defaultBatchFlushInterval
+	}
+
+	return batchEvents(done, eventC, size, interval), nil
+}
+
+// batchEvents groups the events read from eventC into batches of at most size
+// events. A batch that is not full is flushed once interval has elapsed since
+// its first event was received. The returned channel is closed after eventC
+// is closed and the remaining events have been flushed.
+func batchEvents(done <-chan struct{}, eventC <-chan Event, size int, interval time.Duration) <-chan []Event {
+	batchC := make(chan []Event, 1)
+
+	go func() {
+		defer close(batchC)
+
+		var (
+			batch  []Event
+			timer  *time.Timer
+			flushC <-chan time.Time
+		)
+
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, flushC = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case batchC <- batch:
+				batch = nil
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		for {
+			select {
+			case event, ok := <-eventC:
+				if !ok {
+					flush()
+					return
+				}
+				if batch == nil {
+					batch = make([]Event, 0, size)
+					timer = time.NewTimer(interval)
+					flushC = timer.C
+				}
+				batch = append(batch, event)
+				if len(batch) >= size && !flush() {
+					return
+				}
+			case <-flushC:
+				timer, flushC = nil, nil
+				if !flush() {
+					return
+				}
+			}
+		}
+	}()
+
+	return batchC
+}
@@ -0,0 +1,91 @@
+package file_integrity
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchEvents(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		done := make(chan struct{})
+		defer close(done)
+
+		eventC := make(chan Event)
+		go func() {
+			defer close(eventC)
+			for i := 0; i < 250; i++ {
+				eventC <- Event{Path: strconv.Itoa(i)}
+			}
+		}()
+
+		var sizes []int
+		var paths []string
+		for batch := range batchEvents(done, eventC, 100, time.Hour) {
+			sizes = append(sizes, len(batch))
+			for _, e := range batch {
+				paths = append(paths, e.Path)
+			}
+		}
+
+		assert.Equal(t, []int{100, 100, 50}, sizes)
+		if assert.Len(t, paths, 250) {
+			for i, p := range paths {
+				assert.Equal(t, strconv.Itoa(i), p)
+			}
+		}
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		done := make(chan struct{})
+		defer close(done)
+
+		eventC := make(chan Event)
+		defer close(eventC)
+
+		batchC := batchEvents(done, eventC, 100, 50*time.Millisecond)
+		for i := 0; i < 3; i++ {
+			eventC <- Event{Path: strconv.Itoa(i)}
+		}
+
+		select {
+		case batch := <-batchC:
+			assert.Len(t, batch, 3)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for partial batch to be flushed")
+		}
+	})
+}
+
+func TestScannerStartBatched(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	config.BatchSize = 3
+
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	batchC, err := reader.(BatchEventProducer).StartBatched(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	for batch := range batchC {
+		assert.True(t, len(batch) > 0 && len(batch) <= 3, "unexpected batch size %d", len(batch))
+		count += len(batch)
+	}
+	assert.Equal(t, 7, count)
+}
@@ -0,0 +1,369 @@
+package file_integrity
+
+import (
+	"encoding/binary"
+	"hash"
+	"io"
+	"math/bits"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// This is an implementation of the BLAKE3 hash function in its default hash
+// mode with a 256-bit output as described in the BLAKE3 specification
+// (https://github.com/BLAKE3-team/BLAKE3-specs). BLAKE3 splits the input into
+// 1 KiB chunks that form the leaves of a binary tree. This allows independent
+// subtrees of a large file to be hashed in parallel while still producing the
+// same digest as sequential hashing.
+
+const (
+	blake3Size     = 32
+	blake3BlockLen = 64
+	blake3ChunkLen = 1024
+
+	// Domain separation flags.
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+
+	// blake3SegmentChunks is the number of chunks in each subtree that is
+	// hashed in parallel. It must be a power of two.
+	blake3SegmentChunks = 1024
+	blake3SegmentLen    = blake3SegmentChunks * blake3ChunkLen
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3MsgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] = s[a] + s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] = s[a] + s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Round(s, m *[16]uint32) {
+	// Mix the columns.
+	blake3G(s, 0, 4, 8, 12, m[0], m[1])
+	blake3G(s, 1, 5, 9, 13, m[2], m[3])
+	blake3G(s, 2, 6, 10, 14, m[4], m[5])
+	blake3G(s, 3, 7, 11, 15, m[6], m[7])
+	// Mix the diagonals.
+	blake3G(s, 0, 5, 10, 15, m[8], m[9])
+	blake3G(s, 1, 6, 11, 12, m[10], m[11])
+	blake3G(s, 2, 7, 8, 13, m[12], m[13])
+	blake3G(s, 3, 4, 9, 14, m[14], m[15])
+}
+
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for i := 0; i < 7; i++ {
+		blake3Round(&s, &m)
+		if i < 6 {
+			var permuted [16]uint32
+			for j, k := range blake3MsgPermutation {
+				permuted[j] = m[k]
+			}
+			m = permuted
+		}
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+// blake3Words converts up to 64 bytes to little-endian words. Missing bytes
+// are treated as zeros.
+func blake3Words(b []byte) [16]uint32 {
+	var buf [blake3BlockLen]byte
+	copy(buf[:], b)
+
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+	return words
+}
+
+// blake3Output is the input to the final compression of a chunk or parent
+// node. It can produce a chaining value or the root digest.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	var cv [8]uint32
+	out := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], out[:8])
+	return cv
+}
+
+func (o *blake3Output) rootDigest() []byte {
+	out := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	digest := make([]byte, blake3Size)
+	for i := 0; i < blake3Size/4; i++ {
+		binary.LittleEndian.PutUint32(digest[4*i:], out[i])
+	}
+	return digest
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{
+		cv:       blake3IV,
+		blockLen: blake3BlockLen,
+		flags:    blake3Parent,
+	}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+type blake3ChunkState struct {
+	cv               [8]uint32
+	counter          uint64
+	block            [blake3BlockLen]byte
+	blockLen         int
+	blocksCompressed int
+}
+
+func newBlake3ChunkState(counter uint64) blake3ChunkState {
+	return blake3ChunkState{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3ChunkState) len() int {
+	return blake3BlockLen*c.blocksCompressed + c.blockLen
+}
+
+func (c *blake3ChunkState) startFlag() uint32 {
+	if c.blocksCompressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3ChunkState) update(p []byte) {
+	for len(p) > 0 {
+		// Only compress a full block once more input arrives because the last
+		// block of the chunk must be compressed with the chunk end flag.
+		if c.blockLen == blake3BlockLen {
+			words := blake3Words(c.block[:])
+			out := blake3Compress(&c.cv, &words, c.counter, blake3BlockLen, c.startFlag())
+			copy(c.cv[:], out[:8])
+			c.blocksCompressed++
+			c.blockLen = 0
+		}
+
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *blake3ChunkState) output() blake3Output {
+	return blake3Output{
+		cv:       c.cv,
+		block:    blake3Words(c.block[:c.blockLen]),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// blake3Hasher is a hash.Hash computing the BLAKE3 digest incrementally.
+type blake3Hasher struct {
+	chunk blake3ChunkState
+	stack [][8]uint32 // Chaining values of completed subtrees.
+}
+
+// newBlake3 returns a new hash.Hash computing the 256-bit BLAKE3 digest.
+func newBlake3() hash.Hash {
+	h := &blake3Hasher{}
+	h.Reset()
+	return h
+}
+
+func (h *blake3Hasher) Reset() {
+	h.chunk = newBlake3ChunkState(0)
+	h.stack = h.stack[:0]
+}
+
+func (h *blake3Hasher) Size() int { return blake3Size }
+
+func (h *blake3Hasher) BlockSize() int { return blake3BlockLen }
+
+// pushChainingValue adds the chaining value of a completed subtree to the
+// stack. totalChunks is the number of chunks hashed so far (including the
+// subtree) in units of the subtree size. Subtrees are merged as long as the
+// new subtree completes a larger one.
+func (h *blake3Hasher) pushChainingValue(cv [8]uint32, total uint64) {
+	for total&1 == 0 {
+		top := h.stack[len(h.stack)-1]
+		h.stack = h.stack[:len(h.stack)-1]
+		o := blake3ParentOutput(top, cv)
+		cv = o.chainingValue()
+		total >>= 1
+	}
+	h.stack = append(h.stack, cv)
+}
+
+// pushSubtree adds the chaining value of an already hashed subtree of the
+// given number of chunks (a power of two). It must only be called on chunk
+// boundaries that are aligned to the subtree size.
+func (h *blake3Hasher) pushSubtree(cv [8]uint32, chunks uint64) {
+	total := h.chunk.counter + chunks
+	h.pushChainingValue(cv, total/chunks)
+	h.chunk = newBlake3ChunkState(total)
+}
+
+func (h *blake3Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if h.chunk.len() == blake3ChunkLen {
+			o := h.chunk.output()
+			total := h.chunk.counter + 1
+			h.pushChainingValue(o.chainingValue(), total)
+			h.chunk = newBlake3ChunkState(total)
+		}
+
+		take := blake3ChunkLen - h.chunk.len()
+		if take > len(p) {
+			take = len(p)
+		}
+		h.chunk.update(p[:take])
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (h *blake3Hasher) Sum(b []byte) []byte {
+	o := h.chunk.output()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		o = blake3ParentOutput(h.stack[i], o.chainingValue())
+	}
+	return append(b, o.rootDigest()...)
+}
+
+// blake3SubtreeChainingValue returns the (non-root) chaining value of the
+// subtree formed by the given number of chunks (a power of two) read from r
+// starting at chunk index firstChunk.
+func blake3SubtreeChainingValue(r io.ReaderAt, firstChunk, chunks uint64) ([8]uint32, error) {
+	section := io.NewSectionReader(r, int64(firstChunk*blake3ChunkLen), int64(chunks*blake3ChunkLen))
+	buf := make([]byte, 64*blake3ChunkLen)
+	h := &blake3Hasher{}
+
+	var i uint64
+	for i < chunks {
+		n, err := io.ReadFull(section, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return [8]uint32{}, err
+		}
+		if n%blake3ChunkLen != 0 {
+			return [8]uint32{}, io.ErrUnexpectedEOF
+		}
+
+		for off := 0; off < n; off += blake3ChunkLen {
+			c := newBlake3ChunkState(firstChunk + i)
+			c.update(buf[off : off+blake3ChunkLen])
+			o := c.output()
+			i++
+			h.pushChainingValue(o.chainingValue(), i)
+		}
+	}
+	return h.stack[0], nil
+}
+
+// blake3Parallel computes the BLAKE3 digest of the first size bytes of r by
+// hashing aligned subtrees on the given number of goroutines. The result is
+// identical to the sequential digest. If onPanic is not nil it is deferred by
+// each goroutine.
+func blake3Parallel(r io.ReaderAt, size int64, workers int, onPanic func()) (Digest, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// The last segment is always hashed sequentially because the final chunk
+	// of the input must not be merged before the root is computed.
+	var segments int64
+	if size > 0 {
+		segments = (size - 1) / blake3SegmentLen
+	}
+
+	cvs := make([][8]uint32, segments)
+	errs := make([]error, segments)
+	next := make(chan int64)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && int64(w) < segments; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if onPanic != nil {
+				defer onPanic()
+			}
+			for i := range next {
+				cvs[i], errs[i] = blake3SubtreeChainingValue(r,
+					uint64(i)*blake3SegmentChunks, blake3SegmentChunks)
+			}
+		}()
+	}
+	for i := int64(0); i < segments; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	h := newBlake3().(*blake3Hasher)
+	for i, cv := range cvs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		h.pushSubtree(cv, blake3SegmentChunks)
+	}
+
+	offset := segments * blake3SegmentLen
+	if _, err := io.Copy(h, io.NewSectionReader(r, offset, size-offset)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// hashFileBLAKE3Parallel computes the BLAKE3 digest of the named file using
+// the given number of goroutines. The file is opened with open.
+func hashFileBLAKE3Parallel(open opener, name string, workers int, onPanic func()) (Digest, error) {
+	f, err := open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file for hashing")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat file for hashing")
+	}
+
+	digest, err := blake3Parallel(f, info.Size(), workers, onPanic)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to calculate file hashes")
+	}
+	return digest, nil
+}
@@ -0,0 +1,99 @@
+package file_integrity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBLAKE3(t *testing.T) {
+	// Test vectors from the BLAKE3 reference implementation.
+	vectors := map[int]string{
+		0:    "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+		1:    "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213",
+		1024: "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7",
+		1025: "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444",
+	}
+
+	for n, expected := range vectors {
+		h := newBlake3()
+		h.Write(blake3VectorInput(n))
+		assert.Equal(t, expected, hex.EncodeToString(h.Sum(nil)), "input length %v", n)
+	}
+
+	// Writes of any size produce the same digest.
+	input := blake3VectorInput(10000)
+	h := newBlake3()
+	for i := 0; i < len(input); i += 7 {
+		end := i + 7
+		if end > len(input) {
+			end = len(input)
+		}
+		h.Write(input[i:end])
+	}
+	oneShot := newBlake3()
+	oneShot.Write(input)
+	assert.Equal(t, oneShot.Sum(nil), h.Sum(nil))
+}
+
+func TestBLAKE3Parallel(t *testing.T) {
+	sizes := []int{
+		0,
+		1,
+		blake3SegmentLen,
+		blake3SegmentLen + 1,
+		3*blake3SegmentLen + 100,
+		8 * blake3SegmentLen,
+		8*blake3SegmentLen + blake3ChunkLen,
+	}
+
+	for _, size := range sizes {
+		input := blake3VectorInput(size)
+
+		h := newBlake3()
+		h.Write(input)
+		sequential := Digest(h.Sum(nil))
+
+		for _, workers := range []int{1, 4} {
+			parallel, err := blake3Parallel(bytes.NewReader(input), int64(size), workers, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, sequential, parallel, "size=%v workers=%v", size, workers)
+		}
+	}
+}
+
+func TestScannerParallelHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	large := filepath.Join(dir, "large")
+	input := blake3VectorInput(5*blake3SegmentLen + 12345)
+	if err = ioutil.WriteFile(large, input, 0600); err != nil {
+		t.Fatal(err)
+	}
+	h := newBlake3()
+	h.Write(input)
+	expected := Digest(h.Sum(nil))
+
+	config := defaultConfig
+	config.Paths = []string{large}
+	config.HashTypes = []HashType{SHA1, BLAKE3_256}
+	config.ParallelHashMinSizeBytes = blake3SegmentLen
+
+	_, events := runScan(t, config)
+	if !assert.Len(t, events, 1) {
+		return
+	}
+	assert.Equal(t, expected, events[0].Hashes[BLAKE3_256])
+	assert.Len(t, events[0].Hashes[SHA1], 20)
+}
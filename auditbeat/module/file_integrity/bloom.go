@@ -0,0 +1,87 @@
+package file_integrity
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// bloomFilter is a space efficient probabilistic set. It can report false
+// positives but never false negatives.
+type bloomFilter struct {
+	bits []uint64 // Bit array.
+	m    uint64   // Number of bits.
+	k    uint64   // Number of hash functions.
+}
+
+// newBloomFilter returns a bloomFilter sized to hold n items with the given
+// false positive probability.
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Floor(m/float64(n)*math.Ln2+0.5))
+	return newBloomFilterSize(uint64(m), uint64(k))
+}
+
+// newBloomFilterSize returns a bloomFilter with m bits and k hash functions.
+func newBloomFilterSize(m, k uint64) *bloomFilter {
+	if m < 64 {
+		m = 64
+	}
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add adds data to the set.
+func (f *bloomFilter) Add(data []byte) {
+	h1, h2 := bloomHash(data)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain returns false if data is definitely not in the set and true if
+// it might be in the set.
+func (f *bloomFilter) MayContain(data []byte) bool {
+	h1, h2 := bloomHash(data)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// falsePositiveRate estimates the false positive probability of the filter
+// from the fraction of bits that are set.
+func (f *bloomFilter) falsePositiveRate() float64 {
+	var set int
+	for _, word := range f.bits {
+		set += bits.OnesCount64(word)
+	}
+	return math.Pow(float64(set)/float64(f.m), float64(f.k))
+}
+
+// bloomHash returns two independent hashes of data that are combined to
+// derive the k bit positions (Kirsch-Mitzenmacher double hashing).
+func bloomHash(data []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(data)
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
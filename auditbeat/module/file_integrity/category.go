@@ -0,0 +1,102 @@
+package file_integrity
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+)
+
+// Uncategorized is the category of files that match none of the classifier
+// rules.
+const Uncategorized = "uncategorized"
+
+// ClassifierConfig assigns each scanned file the category of the first rule
+// that it matches.
+type ClassifierConfig struct {
+	Rules []ClassifierRule `config:"rules"`
+}
+
+// ClassifierRule matches files by path, owner, and type. Empty conditions
+// match any file.
+type ClassifierRule struct {
+	Category string   `config:"category"`
+	Paths    []string `config:"paths"` // Globs. Globs without a separator match the base name. A trailing /** matches everything below a directory.
+	Owner    string   `config:"owner"` // Name of the owning user.
+	Type     string   `config:"type"`  // One of file, dir, or symlink.
+}
+
+// validate validates the classifier config.
+func (c *ClassifierConfig) validate() error {
+	var errs multierror.Errors
+	for i, r := range c.Rules {
+		if r.Category == "" {
+			errs = append(errs, errors.Errorf("classifier.rules[%d].category is required", i))
+		}
+		for _, p := range r.Paths {
+			if _, err := filepath.Match(strings.TrimSuffix(p, "/**"), ""); err != nil {
+				errs = append(errs, errors.Wrapf(err, "invalid classifier.rules[%d].paths value '%v'", i, p))
+			}
+		}
+		switch r.Type {
+		case "", FileType.String(), DirType.String(), SymlinkType.String():
+		default:
+			errs = append(errs, errors.Errorf("invalid classifier.rules[%d].type value '%v'", i, r.Type))
+		}
+	}
+	return errs.Err()
+}
+
+// category returns the category of the first rule that the event matches or
+// Uncategorized. It returns an empty string if no rules are configured.
+func (c *ClassifierConfig) category(e *Event) string {
+	if len(c.Rules) == 0 {
+		return ""
+	}
+	for _, r := range c.Rules {
+		if r.matches(e) {
+			return r.Category
+		}
+	}
+	return Uncategorized
+}
+
+func (r *ClassifierRule) matches(e *Event) bool {
+	if r.Owner != "" && (e.Info == nil || e.Info.Owner != r.Owner) {
+		return false
+	}
+	if r.Type != "" && (e.Info == nil || e.Info.Type.String() != r.Type) {
+		return false
+	}
+	if len(r.Paths) == 0 {
+		return true
+	}
+	for _, p := range r.Paths {
+		if matchGlob(p, e.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches path against the glob. Globs without a path separator
+// are matched against the base name, and a trailing /** matches any path
+// below the directory.
+func matchGlob(glob, path string) bool {
+	if dir := strings.TrimSuffix(glob, "/**"); dir != glob {
+		for p := filepath.Dir(path); ; p = filepath.Dir(p) {
+			if ok, _ := filepath.Match(dir, p); ok {
+				return true
+			}
+			if parent := filepath.Dir(p); parent == p {
+				return false
+			}
+		}
+	}
+	if !strings.ContainsRune(glob, '/') && !strings.ContainsRune(glob, filepath.Separator) {
+		path = filepath.Base(path)
+	}
+	ok, _ := filepath.Match(glob, path)
+	return ok
+}
@@ -0,0 +1,83 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerClassifier(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"x.log", filepath.Join("subdir", "app.log")} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("log"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	config.Classifier.Rules = []ClassifierRule{
+		{Category: "log", Paths: []string{"*.log"}},
+		{Category: "config", Paths: []string{filepath.Join(dir, "subdir") + "/**"}, Type: "file"},
+		{Category: "link", Type: "symlink"},
+		{Category: "user_data", Paths: []string{filepath.Join(dir, "a")}, Owner: u.Username},
+		{Category: "temp", Paths: []string{filepath.Join(dir, "b")}, Owner: "no-such-user"},
+	}
+	if err = config.Classifier.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, events := runScan(t, config)
+	categories := map[string]string{}
+	for _, event := range events {
+		rel, err := filepath.Rel(dir, event.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		categories[rel] = event.Category
+	}
+
+	assert.Equal(t, map[string]string{
+		".":              Uncategorized,
+		"a":              "user_data",
+		"b":              Uncategorized,
+		"link_to_b":      "link",
+		"link_to_subdir": "link",
+		"subdir":         Uncategorized,
+		"subdir/app.log": "log",
+		"subdir/c":       "config",
+		"x.log":          "log",
+	}, categories)
+}
+
+func TestClassifierConfigValidate(t *testing.T) {
+	c := ClassifierConfig{Rules: []ClassifierRule{{Category: "log", Paths: []string{"*.log"}, Type: "file"}}}
+	assert.NoError(t, c.validate())
+
+	c = ClassifierConfig{Rules: []ClassifierRule{
+		{Paths: []string{"*.log"}},
+		{Category: "bad_glob", Paths: []string{"[a"}},
+		{Category: "bad_type", Type: "socket"},
+	}}
+	assert.Error(t, c.validate())
+
+	// Without rules no category is assigned.
+	assert.Equal(t, "", (&ClassifierConfig{}).category(&Event{Path: "/x.log"}))
+}
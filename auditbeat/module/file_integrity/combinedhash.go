@@ -0,0 +1,71 @@
+package file_integrity
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// combinedHasher computes a single digest over the contents of a file
+// followed by the canonical serialization of its metadata. The digest changes
+// when either the contents or the type, permissions, ownership, or size of the
+// file change. The contents are written to it by the pass over the file that
+// computes the other hashes.
+type combinedHasher struct {
+	hash.Hash
+	info *Metadata
+}
+
+// newCombinedHasher returns a combinedHasher of the given hash type for the
+// file described by info.
+func newCombinedHasher(hashType HashType, info *Metadata) (*combinedHasher, error) {
+	hashes, err := newHashes([]HashType{hashType})
+	if err != nil {
+		return nil, err
+	}
+	return &combinedHasher{Hash: hashes[0], info: info}, nil
+}
+
+// digest returns the combined hash of the contents written so far and the
+// metadata. It must only be called once all contents were written.
+func (h *combinedHasher) digest() Digest {
+	h.Write(canonicalMetadata(h.info))
+	return h.Sum(nil)
+}
+
+// canonicalMetadata serializes the metadata that is covered by the combined
+// hash. The fixed size fields are followed by the SID and its length so that
+// the serialization can be unambiguously parsed from the end of the hashed
+// data.
+func canonicalMetadata(info *Metadata) []byte {
+	mode := uint32(info.Mode.Perm())
+	if info.SetUID {
+		mode |= 04000
+	}
+	if info.SetGID {
+		mode |= 02000
+	}
+
+	buf := make([]byte, 21, 21+len(info.SID)+4)
+	buf[0] = byte(info.Type)
+	binary.BigEndian.PutUint32(buf[1:], mode)
+	binary.BigEndian.PutUint32(buf[5:], info.UID)
+	binary.BigEndian.PutUint32(buf[9:], info.GID)
+	binary.BigEndian.PutUint64(buf[13:], info.Size)
+	buf = append(buf, info.SID...)
+
+	var sidLen [4]byte
+	binary.BigEndian.PutUint32(sidLen[:], uint32(len(info.SID)))
+	return append(buf, sidLen[:]...)
+}
+
+// combineBatchContent computes the combined hash of a file whose contents were
+// buffered for batch hashing.
+func (s *scanner) combineBatchContent(event *Event) {
+	h, err := newCombinedHasher(s.config.CombinedHash, event.Info)
+	if err != nil {
+		event.errors = append(event.errors, err)
+		return
+	}
+	h.Write(event.batchContent)
+	event.CombinedHash = h.digest()
+}
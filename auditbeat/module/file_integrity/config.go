@@ -1,9 +1,12 @@
 package file_integrity
 
 import (
+	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/joeshaw/multierror"
@@ -23,6 +26,7 @@ func (t *HashType) Unpack(v string) error {
 
 var validHashes = []HashType{
 	BLAKE2B_256, BLAKE2B_384, BLAKE2B_512,
+	BLAKE3_256,
 	MD5,
 	SHA1,
 	SHA224, SHA256, SHA384, SHA512, SHA512_224, SHA512_256,
@@ -34,6 +38,7 @@ const (
 	BLAKE2B_256 HashType = "blake2b_256"
 	BLAKE2B_384 HashType = "blake2b_384"
 	BLAKE2B_512 HashType = "blake2b_512"
+	BLAKE3_256  HashType = "blake3_256"
 	MD5         HashType = "md5"
 	SHA1        HashType = "sha1"
 	SHA224      HashType = "sha224"
@@ -48,6 +53,39 @@ const (
 	SHA512_256  HashType = "sha512_256"
 )
 
+// PermissionMask is a set of POSIX permission bits (including the setuid,
+// setgid, and sticky bits) in their traditional octal representation.
+type PermissionMask uint32
+
+// Unpack unpacks an octal string (e.g. "4000") to a PermissionMask for config
+// parsing.
+func (m *PermissionMask) Unpack(v string) error {
+	mask, err := strconv.ParseUint(v, 8, 32)
+	if err != nil {
+		return errors.Wrapf(err, "invalid permission mask '%v'", v)
+	}
+	if mask&^07777 != 0 {
+		return errors.Errorf("invalid permission mask '%v'", v)
+	}
+	*m = PermissionMask(mask)
+	return nil
+}
+
+// Matches returns true if any of the bits of the mask are set in mode.
+func (m PermissionMask) Matches(mode os.FileMode) bool {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits&uint32(m) != 0
+}
+
 // Config contains the configuration parameters for the file integrity
 // metricset.
 type Config struct {
@@ -60,6 +98,231 @@ type Config struct {
 	ScanRateBytesPerSec uint64          `config:",ignore"`
 	Recursive           bool            `config:"recursive"` // Recursive enables recursive monitoring of directories.
 	ExcludeFiles        []match.Matcher `config:"exclude_files"`
+	DirRollup           bool            `config:"dir_rollup"` // DirRollup enables a summary event per scanned directory.
+	EmitSkips           bool            `config:"emit_skips"` // EmitSkips enables an event for each path skipped by the scanner.
+
+	// RootFilters configures include_files, exclude_files, and the precedence
+	// between them for individual paths.
+	RootFilters RootFilters `config:"root_filters"`
+
+	// RequirePermissions limits the scanner to reporting files that have at
+	// least one of the permission bits set. Directories are still traversed.
+	RequirePermissions PermissionMask `config:"require_permissions"`
+
+	// IncludeModeString adds the ls -l style rendering of the file mode (e.g.
+	// -rwsr-xr-x) to each event generated by the scanner.
+	IncludeModeString bool `config:"include_mode_string"`
+
+	// IncludeParentDir adds the parent directory of the path to each event
+	// generated by the scanner.
+	IncludeParentDir bool `config:"include_parent_dir"`
+
+	// SquashfsImages is a list of squashfs images whose contents are scanned
+	// without mounting them.
+	SquashfsImages []string `config:"squashfs_images"`
+
+	// TrustMtime lets the scanner reuse the persisted hashes of a file whose
+	// inode, size, mtime, and ctime are unchanged instead of reading it again.
+	// Files with an mtime further in the future than FutureMtimeTolerance are
+	// flagged and always hashed.
+	TrustMtime           bool          `config:"trust_mtime"`
+	FutureMtimeTolerance time.Duration `config:"future_mtime_tolerance"`
+
+	// WarmCacheOnly makes the scanner hash files and write their state to the
+	// state store without emitting any events. It is used to populate the
+	// cache relied upon by TrustMtime before the first real scan.
+	WarmCacheOnly bool `config:"warm_cache_only"`
+
+	// IncludeReadThroughput adds the throughput achieved while reading and
+	// hashing the file to events generated by the scanner.
+	IncludeReadThroughput bool `config:"include_read_throughput"`
+
+	// ExcludeAtimeOlderThan makes the scanner skip files that have not been
+	// accessed within the given duration. It requires the filesystem to
+	// maintain access times.
+	ExcludeAtimeOlderThan time.Duration `config:"exclude_atime_older_than"`
+
+	// VanishedFiles is the policy for files that disappear between being
+	// enumerated and being read by the scanner. With the retry policy the
+	// file is checked VanishedRetries times, waiting VanishedRetryDelay
+	// before each check, before a vanished event is reported.
+	VanishedFiles      string        `config:"vanished_files"`
+	VanishedRetries    int           `config:"vanished_retries"`
+	VanishedRetryDelay time.Duration `config:"vanished_retry_delay"`
+
+	// Sampling limits the scan of large directories to a sample of their
+	// files.
+	Sampling SamplingConfig `config:"sampling"`
+
+	// CombinedHash is the hash algorithm used to compute a single digest over
+	// the contents and the metadata of each file. Empty disables it.
+	CombinedHash HashType `config:"combined_hash"`
+
+	// DeletionManifest publishes a single event listing the files deleted
+	// since the previous scan when a scan completes.
+	DeletionManifest DeletionManifestConfig `config:"deletion_manifest"`
+
+	// Classifier assigns a category to each file found by the scanner.
+	Classifier ClassifierConfig `config:"classifier"`
+
+	// SuppressHashes lists the digests of known noise files that are not
+	// published.
+	SuppressHashes SuppressHashes `config:"suppress_hashes"`
+
+	// RedactFields lists the event fields that are removed or hashed before
+	// events are published.
+	RedactFields RedactFields `config:"redact_fields"`
+
+	// IncludeProvenance adds the scanner version and build, the host, and the
+	// hash algorithms used to the summary logged when a scan completes.
+	IncludeProvenance bool `config:"include_provenance"`
+
+	// MerkleRoot builds a Merkle tree over the hashes of the scanned files
+	// when a scan completes, persists its root, and compares it to the root
+	// of the previous scan.
+	MerkleRoot bool `config:"merkle_root"`
+
+	// PublishScanSummary publishes the summary of each completed scan as an
+	// event in addition to logging it.
+	PublishScanSummary bool `config:"publish_scan_summary"`
+
+	// SummaryTopN is the number of entries in each of the top lists (the
+	// largest files and the files that took longest to hash) that are
+	// included in the scan summary. Zero disables the lists.
+	SummaryTopN int `config:"summary_top_n"`
+
+	// EventFormat selects the layout of the published events. It is either
+	// EventFormatDefault or EventFormatOsquery.
+	EventFormat string `config:"event_format"`
+
+	// EventRing retains the last events generated by the scanner in memory so
+	// they can be dumped to disk when the scanner crashes or is stopped.
+	EventRing EventRingConfig `config:"event_ring"`
+
+	// MaxSymlinkTargetLength limits the length of the symlink targets
+	// reported by the scanner. Longer targets are truncated. 0 means no limit.
+	MaxSymlinkTargetLength int `config:"max_symlink_target_length"`
+
+	// SignalControl makes the scanner pause on SIGUSR1 and resume on SIGUSR2.
+	// It can only be enabled for one scanner at a time.
+	SignalControl bool `config:"signal_control"`
+
+	// DescendAutofs allows the scanner to descend into autofs mountpoints.
+	// By default they are treated as a boundary because accessing their
+	// contents triggers a mount.
+	DescendAutofs bool `config:"descend_autofs"`
+
+	// HashExecutablesOnly restricts hashing to executable files. Files are
+	// executable if any execute bit is set or if their contents start with the
+	// magic number of an executable format. Only metadata is reported for
+	// other files.
+	HashExecutablesOnly bool `config:"hash_executables_only"`
+
+	// ExpensiveHashes controls whether CPU heavy hash functions are computed
+	// in the same pass as the other hashes and caps how many of them run at
+	// the same time.
+	ExpensiveHashes ExpensiveHashConfig `config:"expensive_hashes"`
+
+	// Backpressure slows down the scanner when sends of events to the
+	// consumer block for too long.
+	Backpressure BackpressureConfig `config:"backpressure"`
+
+	// TraversalOrder is the order in which the scanner walks directories. It
+	// is either TraversalDepthFirst or TraversalBreadthFirst. Breadth-first
+	// traversal reports shallow files first.
+	TraversalOrder string `config:"traversal_order"`
+
+	// OpenMode selects how files are opened to read their contents. It is
+	// either OpenModeDefault or OpenModeBackup.
+	OpenMode string `config:"open_mode"`
+
+	// HashPseudoFilesystems allows the scanner to read and hash files that
+	// reside on pseudo filesystems like procfs and sysfs.
+	HashPseudoFilesystems bool `config:"hash_pseudo_filesystems"`
+
+	// Files of at least ParallelHashMinSize are hashed using multiple
+	// goroutines for hash functions that support it (BLAKE3).
+	ParallelHashMinSize      string `config:"parallel_hash_min_size"`
+	ParallelHashMinSizeBytes uint64 `config:",ignore"`
+
+	// SelfTest makes the scanner verify at startup that each of the configured
+	// hash types produces the expected digests of known inputs. The scanner
+	// refuses to start if any of them does not.
+	SelfTest bool `config:"self_test"`
+
+	// DoubleRead makes the scanner hash files a second time and compare the
+	// results to detect storage returning inconsistent data. Only files of
+	// at most DoubleReadMaxSize are re-read (0 means no limit).
+	DoubleRead             bool   `config:"double_read"`
+	DoubleReadMaxSize      string `config:"double_read_max_size"`
+	DoubleReadMaxSizeBytes uint64 `config:",ignore"`
+
+	// RestatAfterHash makes the scanner stat files again right after hashing
+	// them to detect files that changed while they were read.
+	RestatAfterHash bool `config:"restat_after_hash"`
+
+	// FileReadTimeout and MinReadThroughput limit the time spent hashing a
+	// file to FileReadTimeout plus the time needed to read it at
+	// MinReadThroughput (bytes per second). Hashing of slower files is
+	// aborted. The deadline is checked between reads, so a read that blocks
+	// (e.g. on a hung network filesystem) is not interrupted. Zero values mean
+	// no limit.
+	FileReadTimeout        time.Duration `config:"file_read_timeout"`
+	MinReadThroughput      string        `config:"min_read_throughput"`
+	MinReadThroughputBytes uint64        `config:",ignore"`
+
+	// ResumableHashing persists the hash state of files whose hashing is
+	// interrupted by the read timeout or after ResumableHashMaxSize bytes so
+	// that the next scan resumes it. It requires a State that implements
+	// PartialHashStore and hashes that can marshal their state.
+	ResumableHashing          bool   `config:"resumable_hashing"`
+	ResumableHashMaxSize      string `config:"resumable_hash_max_size"`
+	ResumableHashMaxSizeBytes uint64 `config:",ignore"`
+
+	// PrivilegedReads makes the scanner retry reading files that it is not
+	// permitted to read with CAP_DAC_READ_SEARCH if the process is permitted
+	// to use it (Linux only).
+	PrivilegedReads bool `config:"privileged_reads"`
+
+	// MaxInMemory limits the amount of file content that the scanner holds in
+	// memory at once, e.g. for batch hashing. Files that do not fit are
+	// hashed without buffering them.
+	MaxInMemory      string `config:"max_in_memory"`
+	MaxInMemoryBytes uint64 `config:",ignore"`
+
+	// Hasher, if set, computes the hashes of files of at most
+	// HashBatchMaxFileSize in batches of up to HashBatchSize consecutive
+	// files. It is not used when DoubleRead is enabled.
+	Hasher               Hasher `config:",ignore"`
+	HashBatchSize        int    `config:",ignore"`
+	HashBatchMaxFileSize uint64 `config:",ignore"`
+
+	// BatchSize and BatchFlushInterval control how events are grouped when
+	// using the scanner's StartBatched method.
+	BatchSize          int           `config:",ignore"`
+	BatchFlushInterval time.Duration `config:",ignore"`
+
+	// State, if set, provides the scanner with the last persisted state of
+	// the files. It is set by the metricset.
+	State StateStore `config:",ignore"`
+
+	// DigestPostProcessor, if set, is called for each event of the scanner
+	// after the hashes of the file are computed. It can derive additional
+	// identifiers from the hashes and add them to Event.Derived.
+	DigestPostProcessor func(*Event) `config:",ignore"`
+
+	// Sinks, if set, receive the events of the scanner that match their
+	// filters in addition to the event channel.
+	Sinks []SinkConfig `config:",ignore"`
+
+	// ReputationLookup, if set, is consulted by the scanner after hashing a
+	// file to classify it (e.g. as known-good).
+	ReputationLookup ReputationLookup `config:",ignore"`
+	Quarantine       QuarantineConfig `config:"quarantine"`
+
+	// KnownGood classifies files whose hashes are in a local bloom filter as
+	// known-good without consulting the ReputationLookup.
+	KnownGood KnownGoodConfig `config:"known_good"`
 }
 
 // Validate validates the config data and return an error explaining all the
@@ -89,6 +352,21 @@ nextHash:
 		errs = append(errs, errors.Errorf("invalid hash_types value '%v'", ht))
 	}
 
+	if err = c.RootFilters.validate(c.Paths); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err = c.SuppressHashes.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.CombinedHash != "" {
+		c.CombinedHash = HashType(strings.ToLower(string(c.CombinedHash)))
+		if !c.CombinedHash.valid() {
+			errs = append(errs, errors.Errorf("invalid combined_hash value '%v'", c.CombinedHash))
+		}
+	}
+
 	c.MaxFileSizeBytes, err = humanize.ParseBytes(c.MaxFileSize)
 	if err != nil {
 		errs = append(errs, errors.Wrap(err, "invalid max_file_size value"))
@@ -96,13 +374,143 @@ nextHash:
 		errs = append(errs, errors.Errorf("max_file_size value (%v) must be positive", c.MaxFileSize))
 	}
 
+	if c.ParallelHashMinSize != "" {
+		c.ParallelHashMinSizeBytes, err = humanize.ParseBytes(c.ParallelHashMinSize)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid parallel_hash_min_size value"))
+		}
+	}
+
+	if c.DoubleReadMaxSize != "" {
+		c.DoubleReadMaxSizeBytes, err = humanize.ParseBytes(c.DoubleReadMaxSize)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid double_read_max_size value"))
+		}
+	}
+
+	if c.MaxInMemory != "" {
+		c.MaxInMemoryBytes, err = humanize.ParseBytes(c.MaxInMemory)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid max_in_memory value"))
+		}
+	}
+
+	if c.ResumableHashMaxSize != "" {
+		c.ResumableHashMaxSizeBytes, err = humanize.ParseBytes(c.ResumableHashMaxSize)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid resumable_hash_max_size value"))
+		}
+	}
+
+	if c.ResumableHashing {
+		hashTypes := c.HashTypes
+		if c.CombinedHash != "" {
+			hashTypes = append(hashTypes[:len(hashTypes):len(hashTypes)], c.CombinedHash)
+		}
+		if types := unresumableHashTypes(hashTypes); len(types) > 0 {
+			errs = append(errs, errors.Errorf("resumable_hashing cannot be used "+
+				"with hash types whose state cannot be saved %v (saving the state "+
+				"of SHA and MD5 hashes requires Go 1.10 or newer)", types))
+		}
+	}
+
+	if c.MinReadThroughput != "" {
+		c.MinReadThroughputBytes, err = humanize.ParseBytes(c.MinReadThroughput)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid min_read_throughput value"))
+		}
+	}
+	if c.FileReadTimeout < 0 {
+		errs = append(errs, errors.Errorf("file_read_timeout value (%v) must not be negative", c.FileReadTimeout))
+	}
+
 	c.ScanRateBytesPerSec, err = humanize.ParseBytes(c.ScanRatePerSec)
 	if err != nil {
 		errs = append(errs, errors.Wrap(err, "invalid scan_rate_per_sec value"))
 	}
+
+	switch c.VanishedFiles {
+	case VanishedSkip, VanishedEmit, VanishedRetry:
+	default:
+		errs = append(errs, errors.Errorf("invalid vanished_files value '%v'", c.VanishedFiles))
+	}
+	if c.VanishedRetries < 0 {
+		errs = append(errs, errors.Errorf("vanished_retries value (%v) must not be negative", c.VanishedRetries))
+	}
+
+	if err = c.Sampling.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.MerkleRoot && len(c.HashTypes) == 0 {
+		errs = append(errs, errors.New("merkle_root requires at least one hash_types value"))
+	}
+
+	if c.SummaryTopN < 0 {
+		errs = append(errs, errors.Errorf("summary_top_n value (%v) must not be negative", c.SummaryTopN))
+	}
+
+	switch c.EventFormat {
+	case "", EventFormatDefault, EventFormatOsquery:
+	default:
+		errs = append(errs, errors.Errorf("invalid event_format value '%v'", c.EventFormat))
+	}
+
+	if err = c.ExpensiveHashes.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err = c.Backpressure.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.TraversalOrder {
+	case "", TraversalDepthFirst:
+	case TraversalBreadthFirst:
+		if c.DirRollup {
+			errs = append(errs, errors.New("dir_rollup cannot be used with breadth-first traversal_order"))
+		}
+	default:
+		errs = append(errs, errors.Errorf("invalid traversal_order value '%v'", c.TraversalOrder))
+	}
+
+	switch c.OpenMode {
+	case "", OpenModeDefault, OpenModeBackup:
+	default:
+		errs = append(errs, errors.Errorf("invalid open_mode value '%v'", c.OpenMode))
+	}
+
+	if err = c.Classifier.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err = c.RedactFields.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err = c.EventRing.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err = c.Quarantine.validate(c.Paths); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err = c.KnownGood.validate(); err != nil {
+		errs = append(errs, err)
+	}
 	return errs.Err()
 }
 
+func (t HashType) valid() bool {
+	for _, validHash := range validHashes {
+		if t == validHash {
+			return true
+		}
+	}
+	return false
+}
+
 // deduplicate deduplicates the given sorted string slice. The returned slice
 // reuses the same backing array as in (so don't use in after calling this).
 func deduplicate(in []string) []string {
@@ -118,20 +526,41 @@ func deduplicate(in []string) []string {
 	return out
 }
 
-// IsExcludedPath checks if a path matches the exclude_files regular expressions.
+// IsExcludedPath checks if a path matches the exclude_files regular expressions
+// or is filtered out by the root_filters of its path.
 func (c *Config) IsExcludedPath(path string) bool {
-	for _, matcher := range c.ExcludeFiles {
-		if matcher.MatchString(path) {
-			return true
-		}
+	return c.excludeRule(path) != ""
+}
+
+// excludeRule returns a description of the exclude_files or root_filters
+// rule that excludes path. It returns an empty string if path is not excluded.
+func (c *Config) excludeRule(path string) string {
+	rule, _ := c.filterPath(path)
+	return rule
+}
+
+// isDormant returns true if info describes a regular file that has not been
+// accessed within ExcludeAtimeOlderThan.
+func (c *Config) isDormant(info os.FileInfo) bool {
+	if c.ExcludeAtimeOlderThan <= 0 || !info.Mode().IsRegular() {
+		return false
 	}
-	return false
+	atime, ok := accessTime(info)
+	return ok && atime.Before(time.Now().Add(-c.ExcludeAtimeOlderThan))
 }
 
 var defaultConfig = Config{
-	HashTypes:        []HashType{SHA1},
-	MaxFileSize:      "100 MiB",
-	MaxFileSizeBytes: 100 * 1024 * 1024,
-	ScanAtStart:      true,
-	ScanRatePerSec:   "50 MiB",
+	HashTypes:          []HashType{SHA1},
+	MaxFileSize:        "100 MiB",
+	MaxFileSizeBytes:   100 * 1024 * 1024,
+	MaxInMemory:        "64 MiB",
+	MaxInMemoryBytes:   64 * 1024 * 1024,
+	EventFormat:        EventFormatDefault,
+	OpenMode:           OpenModeDefault,
+	TraversalOrder:     TraversalDepthFirst,
+	VanishedFiles:      VanishedSkip,
+	VanishedRetries:    3,
+	VanishedRetryDelay: 100 * time.Millisecond,
+	ScanAtStart:        true,
+	ScanRatePerSec:     "50 MiB",
 }
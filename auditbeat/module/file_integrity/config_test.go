@@ -149,3 +149,62 @@ func TestConfigRemoveDuplicates(t *testing.T) {
 
 	assert.Len(t, c.Paths, 1)
 }
+
+func TestConfigRequirePermissions(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"paths":               []string{"/usr/bin"},
+		"require_permissions": "6002",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig
+	if err := config.Unpack(&c); err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 06002, c.RequirePermissions)
+
+	assert.True(t, c.RequirePermissions.Matches(0755|os.ModeSetuid))
+	assert.True(t, c.RequirePermissions.Matches(0755|os.ModeSetgid))
+	assert.True(t, c.RequirePermissions.Matches(0777))
+	assert.False(t, c.RequirePermissions.Matches(0755))
+	assert.False(t, c.RequirePermissions.Matches(0755|os.ModeSticky))
+
+	for _, invalid := range []string{"9", "17777", "rwx"} {
+		var m PermissionMask
+		assert.Error(t, m.Unpack(invalid), invalid)
+	}
+}
+
+func TestConfigResumableHashing(t *testing.T) {
+	unpack := func(options map[string]interface{}) error {
+		options["paths"] = []string{"/usr/bin"}
+		options["resumable_hashing"] = true
+		config, err := common.NewConfigFrom(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := defaultConfig
+		return config.Unpack(&c)
+	}
+
+	assert.NoError(t, unpack(map[string]interface{}{
+		"hash_types": []string{"sha1", "sha256"},
+	}))
+
+	// SHA-3 hashes cannot save their state.
+	err := unpack(map[string]interface{}{
+		"hash_types": []string{"sha1", "sha3_256"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "resumable_hashing")
+	}
+	err = unpack(map[string]interface{}{
+		"hash_types":    []string{"sha1"},
+		"combined_hash": "sha3_256",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "resumable_hashing")
+	}
+}
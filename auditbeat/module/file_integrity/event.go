@@ -25,6 +25,10 @@ import (
 	"github.com/elastic/beats/metricbeat/mb"
 )
 
+// openForHashing opens a file for reading its contents. It is a variable so
+// that tests can inject readers.
+var openForHashing = file.ReadOpen
+
 // Source identifies the source of an event (i.e. what triggered it).
 type Source uint8
 
@@ -51,7 +55,7 @@ var sourceNames = map[Source]string{
 	SourceFSNotify: "fsnotify",
 }
 
-// Type identifies the file type (e.g. dir, file, symlink).
+// Type identifies the file type (e.g. dir, file, symlink, char_device).
 type Type uint8
 
 func (t Type) String() string {
@@ -70,12 +74,16 @@ const (
 	FileType
 	DirType
 	SymlinkType
+	CharDeviceType
+	BlockDeviceType
 )
 
 var typeNames = map[Type]string{
-	FileType:    "file",
-	DirType:     "dir",
-	SymlinkType: "symlink",
+	FileType:        "file",
+	DirType:         "dir",
+	SymlinkType:     "symlink",
+	CharDeviceType:  "char_device",
+	BlockDeviceType: "block_device",
 }
 
 // Digest is a output of a hash function.
@@ -93,15 +101,65 @@ func (d Digest) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
 type Event struct {
 	Timestamp  time.Time           `json:"timestamp"`             // Time of event.
 	Path       string              `json:"path"`                  // The path associated with the event.
+	ParentDir  string              `json:"parent_dir,omitempty"`  // Directory containing Path (scanner only).
+	OldPath    string              `json:"old_path,omitempty"`    // Path the file was moved from (snapshot diffs only).
 	TargetPath string              `json:"target_path,omitempty"` // Target path for symlinks.
 	Info       *Metadata           `json:"info"`                  // File metadata (if the file exists).
 	Source     Source              `json:"source"`                // Source of the event.
 	Action     Action              `json:"action"`                // Action (like created, updated).
 	Hashes     map[HashType]Digest `json:"hash,omitempty"`        // File hashes.
+	ModeString string              `json:"mode_string,omitempty"` // ls -l style mode (scanner only).
+	Rollup     *DirRollup          `json:"rollup,omitempty"`      // Summary of a directory's children (scanner only).
+	Reputation Reputation          `json:"reputation,omitempty"`  // Classification of the file's hash.
+
+	QuarantinePath string `json:"quarantine_path,omitempty"` // Location the file was moved to.
+
+	CombinedHash Digest `json:"combined_hash,omitempty"` // Hash of the contents and metadata (scanner only).
+
+	// Derived holds the fields added by the DigestPostProcessor. Keys are
+	// dotted field names like "hash.short_id" (scanner only).
+	Derived common.MapStr `json:"derived,omitempty"`
+
+	Category string `json:"category,omitempty"` // Category assigned by the classifier (scanner only).
+
+	FutureMTime  bool `json:"future_mtime,omitempty"`   // The mtime is in the future (scanner only).
+	UnstableRead bool `json:"unstable_read,omitempty"`  // Re-reading the file produced different hashes (scanner only).
+	ReadTimedOut bool `json:"read_timed_out,omitempty"` // Hashing was aborted because the file was read too slowly (scanner only).
+
+	HashIncomplete bool `json:"hash_incomplete,omitempty"` // Hashing will be resumed by the next scan (scanner only).
+
+	ReadPermissionDenied bool `json:"read_permission_denied,omitempty"` // The file exists but could not be read (scanner only).
+
+	// ScanWindow holds the mtime and size of the file before and after it was
+	// hashed (scanner only).
+	ScanWindow        *ScanWindow `json:"scan_window,omitempty"`
+	ChangedDuringScan bool        `json:"changed_during_scan,omitempty"` // The mtime or size changed while the file was hashed (scanner only).
+
+	Suppressed bool `json:"suppressed,omitempty"` // A hash of the file is in SuppressHashes (scanner only).
+
+	ReadThroughputMBps float64 `json:"read_throughput_mbps,omitempty"` // Rate at which the file was hashed (scanner only).
+
+	Vanished bool `json:"vanished,omitempty"` // The file disappeared during the scan.
+
+	Dangling            bool `json:"dangling,omitempty"`              // The symlink's target does not exist.
+	TargetPathTruncated bool `json:"target_path_truncated,omitempty"` // TargetPath was truncated (scanner only).
+
+	// DeviceMajor and DeviceMinor are the device numbers of char and block
+	// device nodes.
+	DeviceMajor uint32 `json:"device_major,omitempty"`
+	DeviceMinor uint32 `json:"device_minor,omitempty"`
+
+	// Skip events are emitted by the scanner for excluded paths when
+	// EmitSkips is enabled.
+	Skipped     bool   `json:"skipped,omitempty"`      // The path was not scanned.
+	MatchedRule string `json:"matched_rule,omitempty"` // Rule that caused the skip.
 
 	// Metadata
 	rtt    time.Duration // Time taken to collect the info.
 	errors []error       // Errors that occurred while collecting the info.
+
+	batchContent  []byte        // File contents waiting to be hashed by a Hasher (scanner only).
+	batchReadTime time.Duration // Time taken to read batchContent.
 }
 
 // Metadata contains file metadata.
@@ -161,6 +219,11 @@ func NewEventFromFileInfo(
 		return event
 	}
 
+	// Device nodes are not hashed but their device numbers are reported.
+	if info.Mode()&os.ModeDevice != 0 {
+		event.DeviceMajor, event.DeviceMinor = deviceNumbers(info)
+	}
+
 	switch event.Info.Type {
 	case FileType:
 		if event.Info.Size <= maxFileSize {
@@ -172,7 +235,15 @@ func NewEventFromFileInfo(
 			}
 		}
 	case SymlinkType:
-		event.TargetPath, _ = filepath.EvalSymlinks(event.Path)
+		var err error
+		event.TargetPath, err = filepath.EvalSymlinks(event.Path)
+		if os.IsNotExist(err) {
+			// Report the unresolved target of dangling symlinks.
+			if target, err := os.Readlink(event.Path); err == nil {
+				event.TargetPath = target
+				event.Dangling = true
+			}
+		}
 	}
 
 	return event
@@ -211,6 +282,23 @@ func buildMetricbeatEvent(e *Event, existedBefore bool) mb.Event {
 	if e.TargetPath != "" {
 		file["target_path"] = e.TargetPath
 	}
+	if e.OldPath != "" {
+		file["old_path"] = e.OldPath
+	}
+	if e.Dangling {
+		file["dangling"] = true
+	}
+	if e.TargetPathTruncated {
+		file["target_path_truncated"] = true
+	}
+
+	if e.ParentDir != "" {
+		file["parent_dir"] = e.ParentDir
+	}
+
+	if e.ModeString != "" {
+		file["mode_string"] = e.ModeString
+	}
 
 	if e.Info != nil {
 		info := e.Info
@@ -226,6 +314,11 @@ func buildMetricbeatEvent(e *Event, existedBefore bool) mb.Event {
 			file["type"] = info.Type.String()
 		}
 
+		if info.Type == CharDeviceType || info.Type == BlockDeviceType {
+			file["device_major"] = e.DeviceMajor
+			file["device_minor"] = e.DeviceMinor
+		}
+
 		if runtime.GOOS == "windows" {
 			if info.SID != "" {
 				file["uid"] = info.SID
@@ -248,11 +341,62 @@ func buildMetricbeatEvent(e *Event, existedBefore bool) mb.Event {
 		if info.SetGID {
 			file["setgid"] = true
 		}
+		if e.FutureMTime {
+			file["future_mtime"] = true
+		}
+		if e.UnstableRead {
+			file["unstable_read"] = true
+		}
+		if e.ReadTimedOut {
+			file["read_timed_out"] = true
+		}
+		if e.HashIncomplete {
+			file["hash_incomplete"] = true
+		}
+		if e.ReadPermissionDenied {
+			file["read_permission_denied"] = true
+		}
+		if e.ScanWindow != nil {
+			file["scan_window"] = common.MapStr{
+				"pre_mtime":  e.ScanWindow.PreMTime,
+				"post_mtime": e.ScanWindow.PostMTime,
+				"pre_size":   e.ScanWindow.PreSize,
+				"post_size":  e.ScanWindow.PostSize,
+			}
+		}
+		if e.ChangedDuringScan {
+			file["changed_during_scan"] = true
+		}
+		if e.ReadThroughputMBps > 0 {
+			file["read_throughput_mbps"] = e.ReadThroughputMBps
+		}
 		if len(info.Origin) > 0 {
 			file["origin"] = info.Origin
 		}
 	}
 
+	if e.Reputation != NoReputation {
+		file["reputation"] = e.Reputation.String()
+	}
+	if e.Reputation == KnownGood {
+		file["known_good"] = true
+	}
+	if e.QuarantinePath != "" {
+		file["quarantine_path"] = e.QuarantinePath
+	}
+	if e.Category != "" {
+		file["category"] = e.Category
+	}
+
+	if e.Skipped {
+		file["skipped"] = true
+		file["matched_rule"] = e.MatchedRule
+	}
+
+	if e.Vanished {
+		file["vanished"] = true
+	}
+
 	if len(e.Hashes) > 0 {
 		hashes := make(common.MapStr, len(e.Hashes))
 		for hashType, digest := range e.Hashes {
@@ -260,6 +404,21 @@ func buildMetricbeatEvent(e *Event, existedBefore bool) mb.Event {
 		}
 		out.MetricSetFields.Put("hash", hashes)
 	}
+	if len(e.CombinedHash) > 0 {
+		out.MetricSetFields.Put("hash.combined", e.CombinedHash)
+	}
+
+	if e.Rollup != nil {
+		rollup := common.MapStr{
+			"child_count":           e.Rollup.ChildCount,
+			"total_bytes":           e.Rollup.TotalBytes,
+			"permission_violations": e.Rollup.PermissionViolations,
+		}
+		if !e.Rollup.NewestMTime.IsZero() {
+			rollup["newest_mtime"] = e.Rollup.NewestMTime
+		}
+		out.MetricSetFields.Put("rollup", rollup)
+	}
 
 	if e.Action > 0 {
 		actions := e.Action.InOrder(existedBefore, e.Info != nil).StringArray()
@@ -269,6 +428,52 @@ func buildMetricbeatEvent(e *Event, existedBefore bool) mb.Event {
 	return out
 }
 
+// lsModeString renders the mode like ls -l does (e.g. drwxr-xr-x). Unlike
+// os.FileMode.String() the setuid, setgid, and sticky bits replace the
+// execute bit of the corresponding class (s, S, t, T).
+func lsModeString(m os.FileMode) string {
+	buf := []byte("----------")
+
+	switch {
+	case m&os.ModeDir != 0:
+		buf[0] = 'd'
+	case m&os.ModeSymlink != 0:
+		buf[0] = 'l'
+	case m&os.ModeCharDevice != 0:
+		buf[0] = 'c'
+	case m&os.ModeDevice != 0:
+		buf[0] = 'b'
+	case m&os.ModeNamedPipe != 0:
+		buf[0] = 'p'
+	case m&os.ModeSocket != 0:
+		buf[0] = 's'
+	}
+
+	const rwx = "rwxrwxrwx"
+	perm := m.Perm()
+	for i := 0; i < 9; i++ {
+		if perm&(1<<uint(8-i)) != 0 {
+			buf[i+1] = rwx[i]
+		}
+	}
+
+	special := func(i int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if buf[i] == 'x' {
+			buf[i] = c
+		} else {
+			buf[i] = c - 'a' + 'A'
+		}
+	}
+	special(3, m&os.ModeSetuid != 0, 's')
+	special(6, m&os.ModeSetgid != 0, 's')
+	special(9, m&os.ModeSticky != 0, 't')
+
+	return string(buf)
+}
+
 // diffEvents returns true if the file info differs between the old event and
 // the new event. Changes to the timestamp and action are ignored. If old
 // contains a superset of new's hashes then false is returned.
@@ -309,6 +514,7 @@ func diffEvents(old, new *Event) (Action, bool) {
 	}
 
 	if old.TargetPath != new.TargetPath ||
+		old.DeviceMajor != new.DeviceMajor || old.DeviceMinor != new.DeviceMinor ||
 		(old.Info == nil && new.Info != nil) ||
 		(old.Info != nil && new.Info == nil) {
 		result |= AttributesModified
@@ -337,10 +543,76 @@ func diffEvents(old, new *Event) (Action, bool) {
 }
 
 func hashFile(name string, hashType ...HashType) (map[HashType]Digest, error) {
+	return hashFileUntil(openForHashing, name, time.Time{}, hashType...)
+}
+
+// hashFileUntil is like hashFile but it opens the file with open and aborts
+// with errReadTimeout once the deadline has passed. A zero deadline means no
+// limit.
+func hashFileUntil(open opener, name string, deadline time.Time, hashType ...HashType) (map[HashType]Digest, error) {
+	return hashFileTee(open, name, deadline, nil, hashType...)
+}
+
+// hashFileTee is like hashFileUntil but it also writes the contents of the
+// file to w unless w is nil.
+func hashFileTee(open opener, name string, deadline time.Time, w io.Writer, hashType ...HashType) (map[HashType]Digest, error) {
+	if len(hashType) == 0 && w == nil {
+		return nil, nil
+	}
+
+	hashes, err := newHashes(hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file for hashing")
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !deadline.IsZero() {
+		r = &deadlineReader{r: f, deadline: deadline}
+	}
+	if w != nil {
+		r = io.TeeReader(r, w)
+	}
+	return sumHashes(r, hashType, hashes)
+}
+
+// errReadTimeout is returned when a file could not be read before its
+// deadline.
+var errReadTimeout = errors.New("read_timeout: file was read slower than the minimum throughput")
+
+// deadlineReader fails reads with errReadTimeout once the deadline has passed.
+// It cannot interrupt a read that is blocked in the underlying reader.
+type deadlineReader struct {
+	r        io.Reader
+	deadline time.Time
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if time.Now().After(r.deadline) {
+		return 0, errReadTimeout
+	}
+	return r.r.Read(p)
+}
+
+// hashReader computes the given hashes over the contents of r.
+func hashReader(r io.Reader, hashType ...HashType) (map[HashType]Digest, error) {
 	if len(hashType) == 0 {
 		return nil, nil
 	}
 
+	hashes, err := newHashes(hashType)
+	if err != nil {
+		return nil, err
+	}
+	return sumHashes(r, hashType, hashes)
+}
+
+func newHashes(hashType []HashType) ([]hash.Hash, error) {
 	var hashes []hash.Hash
 	for _, name := range hashType {
 		switch name {
@@ -353,6 +625,8 @@ func hashFile(name string, hashType ...HashType) (map[HashType]Digest, error) {
 		case BLAKE2B_512:
 			h, _ := blake2b.New512(nil)
 			hashes = append(hashes, h)
+		case BLAKE3_256:
+			hashes = append(hashes, newBlake3())
 		case MD5:
 			hashes = append(hashes, md5.New())
 		case SHA1:
@@ -381,15 +655,12 @@ func hashFile(name string, hashType ...HashType) (map[HashType]Digest, error) {
 			return nil, errors.Errorf("unknown hash type '%v'", name)
 		}
 	}
+	return hashes, nil
+}
 
-	f, err := file.ReadOpen(name)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to open file for hashing")
-	}
-	defer f.Close()
-
+func sumHashes(r io.Reader, hashType []HashType, hashes []hash.Hash) (map[HashType]Digest, error) {
 	hashWriter := multiWriter(hashes)
-	if _, err := io.Copy(hashWriter, f); err != nil {
+	if _, err := io.Copy(hashWriter, r); err != nil {
 		return nil, errors.Wrap(err, "failed to calculate file hashes")
 	}
 
@@ -160,6 +160,7 @@ func TestHashFile(t *testing.T) {
 			BLAKE2B_256: mustDecodeHex("0f0cc1f0ea4ef962d6a150ae0b77bc320b57ed24e1609b933fa2274484f59145"),
 			BLAKE2B_384: mustDecodeHex("b819d90f648da6effff2393acb1884d2638642b3524c329832c073c9364149fcdedb522914ef9c2c92f007a42366139a"),
 			BLAKE2B_512: mustDecodeHex("fc13029e8a5ce67ad5a70f0cc659a4b30df9d791b125835e434606c6127ee37ebbc8b216389682ddfa84380789db09f2535d2a9837454414ea3ff00ec0801150"),
+			BLAKE3_256:  mustDecodeHex("023aa505aebebfedf8f10495ee8614efede69fdbd56fce6168ccca11bf799db8"),
 			MD5:         mustDecodeHex("c897d1410af8f2c74fba11b1db511e9e"),
 			SHA1:        mustDecodeHex("f951b101989b2c3b7471710b4e78fc4dbdfa0ca6"),
 			SHA224:      mustDecodeHex("d301812e62eec9b1e68c0b861e62f374e0d77e8365f5ddd6cccc8693"),
@@ -370,3 +371,28 @@ func assertHasKey(t testing.TB, m common.MapStr, key string) {
 		t.Errorf("key %v not found: %v", key, err)
 	}
 }
+
+func TestLsModeString(t *testing.T) {
+	cases := map[os.FileMode]string{
+		0644:                                     "-rw-r--r--",
+		0755:                                     "-rwxr-xr-x",
+		0:                                        "----------",
+		os.ModeDir | 0755:                        "drwxr-xr-x",
+		os.ModeDir | os.ModeSticky | 0777:        "drwxrwxrwt",
+		os.ModeDir | os.ModeSticky | 0770:        "drwxrwx--T",
+		os.ModeSymlink | 0777:                    "lrwxrwxrwx",
+		os.ModeSetuid | 0755:                     "-rwsr-xr-x",
+		os.ModeSetuid | 0644:                     "-rwSr--r--",
+		os.ModeSetgid | 0755:                     "-rwxr-sr-x",
+		os.ModeSetgid | 0745:                     "-rwxr-Sr-x",
+		os.ModeSetuid | os.ModeSetgid | 0750:     "-rwsr-s---",
+		os.ModeDevice | 0660:                     "brw-rw----",
+		os.ModeDevice | os.ModeCharDevice | 0666: "crw-rw-rw-",
+		os.ModeNamedPipe | 0600:                  "prw-------",
+		os.ModeSocket | 0755:                     "srwxr-xr-x",
+	}
+
+	for mode, expected := range cases {
+		assert.Equal(t, expected, lsModeString(mode), "mode %v", mode)
+	}
+}
@@ -0,0 +1,49 @@
+package file_integrity
+
+import (
+	"bytes"
+	"io"
+)
+
+// executableMagics are the leading bytes of executable file formats: ELF,
+// PE (MZ), Mach-O (32/64-bit in both byte orders, and universal binaries), and
+// scripts with an interpreter line.
+var executableMagics = [][]byte{
+	[]byte("\x7fELF"),
+	[]byte("MZ"),
+	{0xfe, 0xed, 0xfa, 0xce},
+	{0xce, 0xfa, 0xed, 0xfe},
+	{0xfe, 0xed, 0xfa, 0xcf},
+	{0xcf, 0xfa, 0xed, 0xfe},
+	{0xca, 0xfe, 0xba, 0xbe},
+	[]byte("#!"),
+}
+
+// isExecutable returns true if any execute bit of the file is set or if its
+// contents start with the magic number of an executable format. The file is
+// opened with open.
+func isExecutable(open opener, path string, info *Metadata) bool {
+	if info.Mode&0111 != 0 {
+		return true
+	}
+
+	f, err := open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return hasExecutableMagic(f)
+}
+
+// hasExecutableMagic returns true if the data read from r starts with the
+// magic number of an executable format.
+func hasExecutableMagic(r io.Reader) bool {
+	buf := make([]byte, 4)
+	n, _ := io.ReadFull(r, buf)
+	for _, magic := range executableMagics {
+		if bytes.HasPrefix(buf[:n], magic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerHashExecutablesOnly(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]struct {
+		content []byte
+		mode    os.FileMode
+	}{
+		"tool":   {[]byte("plain text"), 0700},
+		"elf":    {[]byte("\x7fELF\x02\x01\x01"), 0600},
+		"script": {[]byte("#!/bin/sh\necho hi\n"), 0600},
+		"tiny":   {[]byte("M"), 0600},
+	}
+	for name, f := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), f.content, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := defaultConfig
+	config.HashExecutablesOnly = true
+	events := scanEvents(t, config, dir)
+
+	hashed := map[string]bool{}
+	for path, e := range events {
+		if e.Info != nil && e.Info.Type == FileType {
+			hashed[filepath.Base(path)] = len(e.Hashes) > 0
+		}
+	}
+	assert.Equal(t, map[string]bool{
+		"a":      false,
+		"b":      false,
+		"tool":   runtime.GOOS != "windows", // Execute bits are not supported.
+		"elf":    true,
+		"script": true,
+		"tiny":   false,
+	}, hashed)
+}
@@ -0,0 +1,176 @@
+package file_integrity
+
+import (
+	"sync"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+)
+
+// Strategies for computing expensive hashes.
+const (
+	// ExpensiveHashInline computes expensive hashes in the same pass over the
+	// file as all other hashes.
+	ExpensiveHashInline = "inline"
+
+	// ExpensiveHashParallel computes each expensive hash in its own pass over
+	// the file, concurrently with the pass computing the cheap hashes.
+	ExpensiveHashParallel = "parallel"
+)
+
+// defaultExpensiveHashTypes are the hash types that are considered expensive
+// unless configured otherwise.
+var defaultExpensiveHashTypes = []HashType{BLAKE3_256, SHA3_224, SHA3_256, SHA3_384, SHA3_512}
+
+// ExpensiveHashConfig controls how CPU heavy hash functions are run.
+type ExpensiveHashConfig struct {
+	Types         []HashType `config:"types"`          // Hash types considered expensive. Defaults to BLAKE3 and SHA3.
+	Strategy      string     `config:"strategy"`       // ExpensiveHashInline or ExpensiveHashParallel.
+	MaxConcurrent int        `config:"max_concurrent"` // Cap on the expensive hash operations run at the same time. Zero means no cap.
+}
+
+func (c *ExpensiveHashConfig) validate() error {
+	var errs multierror.Errors
+	for _, t := range c.Types {
+		if !t.valid() {
+			errs = append(errs, errors.Errorf("invalid expensive_hashes.types value '%v'", t))
+		}
+	}
+	switch c.Strategy {
+	case "", ExpensiveHashInline, ExpensiveHashParallel:
+	default:
+		errs = append(errs, errors.Errorf("invalid expensive_hashes.strategy value '%v'", c.Strategy))
+	}
+	if c.MaxConcurrent < 0 {
+		errs = append(errs, errors.Errorf("expensive_hashes.max_concurrent value (%v) must not be negative", c.MaxConcurrent))
+	}
+	return errs.Err()
+}
+
+// isExpensive returns true if the hash type is considered expensive.
+func (c *ExpensiveHashConfig) isExpensive(hashType HashType) bool {
+	types := c.Types
+	if len(types) == 0 {
+		types = defaultExpensiveHashTypes
+	}
+	for _, t := range types {
+		if t == hashType {
+			return true
+		}
+	}
+	return false
+}
+
+// split separates the expensive hash types from the cheap ones.
+func (c *ExpensiveHashConfig) split(hashTypes []HashType) (cheap, expensive []HashType) {
+	for _, t := range hashTypes {
+		if c.isExpensive(t) {
+			expensive = append(expensive, t)
+		} else {
+			cheap = append(cheap, t)
+		}
+	}
+	return cheap, expensive
+}
+
+// hashLimiter caps the number of expensive hash operations that run at the
+// same time. It is safe for concurrent use.
+type hashLimiter struct {
+	slots chan struct{} // Nil if the number of operations is not capped.
+
+	mu     sync.Mutex
+	active int
+	peak   int // Largest number of operations that ran at the same time.
+}
+
+func newHashLimiter(max int) *hashLimiter {
+	l := &hashLimiter{}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire blocks until another operation may start.
+func (l *hashLimiter) acquire() {
+	if l.slots != nil {
+		l.slots <- struct{}{}
+	}
+	l.mu.Lock()
+	l.active++
+	if l.active > l.peak {
+		l.peak = l.active
+	}
+	l.mu.Unlock()
+}
+
+// release marks an operation started by acquire as completed.
+func (l *hashLimiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// Peak returns the largest number of operations that ran at the same time.
+func (l *hashLimiter) Peak() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.peak
+}
+
+// limitExpensive blocks until an expensive hash operation may start if any of
+// the hash types is expensive. The returned function must be called once the
+// operation completes.
+func (s *scanner) limitExpensive(hashTypes []HashType) func() {
+	for _, t := range hashTypes {
+		if s.config.ExpensiveHashes.isExpensive(t) {
+			s.expensive.acquire()
+			return s.expensive.release
+		}
+	}
+	return func() {}
+}
+
+// hashJob computes some of the hashes of a file.
+type hashJob func() (map[HashType]Digest, error)
+
+// runHashJobs runs the jobs concurrently and merges their hashes. The first
+// error that occurs is returned.
+func (s *scanner) runHashJobs(jobs []hashJob) (map[HashType]Digest, error) {
+	if len(jobs) == 1 {
+		return jobs[0]()
+	}
+
+	type result struct {
+		hashes map[HashType]Digest
+		err    error
+	}
+	results := make([]result, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job hashJob) {
+			defer wg.Done()
+			defer s.dumpRingOnPanic()
+			results[i].hashes, results[i].err = job()
+		}(i, job)
+	}
+	wg.Wait()
+
+	var merged map[HashType]Digest
+	for _, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+		for hashType, digest := range r.hashes {
+			if merged == nil {
+				merged = map[HashType]Digest{}
+			}
+			merged[hashType] = digest
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,104 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common/file"
+)
+
+func TestScannerExpensiveHashesMaxConcurrent(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Slow down reads so that the passes over a file overlap.
+	openForHashing = func(name string) (*os.File, error) {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			defer w.Close()
+			time.Sleep(20 * time.Millisecond)
+			w.Write(content)
+		}()
+		return r, nil
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+
+	config := defaultConfig
+	config.HashTypes = []HashType{MD5, SHA1, SHA256, SHA384, SHA512, SHA3_256}
+	config.ExpensiveHashes = ExpensiveHashConfig{
+		Types:         []HashType{SHA256, SHA384, SHA512, SHA3_256},
+		Strategy:      ExpensiveHashParallel,
+		MaxConcurrent: 2,
+	}
+	config.Paths = []string{dir}
+
+	s, events := runScan(t, config)
+	var e Event
+	for _, event := range events {
+		if event.Path == filepath.Join(dir, "a") {
+			e = event
+		}
+	}
+
+	assert.Equal(t, 2, s.expensive.Peak(), "expected the cap to be reached but not exceeded")
+
+	openForHashing = file.ReadOpen
+	expected, err := hashFile(e.Path, config.HashTypes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, e.Hashes)
+}
+
+func TestHashLimiter(t *testing.T) {
+	l := newHashLimiter(3)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.acquire()
+			defer l.release()
+			time.Sleep(time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, l.Peak() <= 3, "peak %v exceeds the cap", l.Peak())
+	assert.Equal(t, 0, l.active)
+}
+
+func TestExpensiveHashConfig(t *testing.T) {
+	var c ExpensiveHashConfig
+	assert.True(t, c.isExpensive(BLAKE3_256))
+	assert.False(t, c.isExpensive(SHA1))
+
+	c.Types = []HashType{SHA512}
+	cheap, expensive := c.split([]HashType{SHA1, SHA512, BLAKE3_256})
+	assert.Equal(t, []HashType{SHA1, BLAKE3_256}, cheap)
+	assert.Equal(t, []HashType{SHA512}, expensive)
+
+	assert.NoError(t, c.validate())
+	assert.Error(t, (&ExpensiveHashConfig{Types: []HashType{"crc"}}).validate())
+	assert.Error(t, (&ExpensiveHashConfig{Strategy: "deferred"}).validate())
+	assert.Error(t, (&ExpensiveHashConfig{MaxConcurrent: -1}).validate())
+}
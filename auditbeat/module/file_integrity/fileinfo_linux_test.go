@@ -0,0 +1,77 @@
+// +build linux
+
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestScannerDeviceNumbers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	null, loop := filepath.Join(dir, "null"), filepath.Join(dir, "loop7")
+	if err = unix.Mknod(null, unix.S_IFCHR|0600, int(unix.Mkdev(1, 3))); err != nil {
+		t.Skip("creating device nodes is not permitted:", err)
+	}
+	if err = unix.Mknod(loop, unix.S_IFBLK|0600, int(unix.Mkdev(7, 300))); err != nil {
+		t.Fatal(err)
+	}
+
+	events := scanEvents(t, defaultConfig, dir)
+
+	e := events[null]
+	if assert.NotNil(t, e.Info) {
+		assert.Equal(t, CharDeviceType, e.Info.Type)
+	}
+	assert.EqualValues(t, 1, e.DeviceMajor)
+	assert.EqualValues(t, 3, e.DeviceMinor)
+	assert.Empty(t, e.Hashes)
+
+	e = events[loop]
+	if assert.NotNil(t, e.Info) {
+		assert.Equal(t, BlockDeviceType, e.Info.Type)
+	}
+	assert.EqualValues(t, 7, e.DeviceMajor)
+	assert.EqualValues(t, 300, e.DeviceMinor)
+
+	file, err := buildMetricbeatEvent(&e, false).MetricSetFields.GetValue("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := file.(common.MapStr)
+	assert.Equal(t, "block_device", fields["type"])
+	assert.EqualValues(t, 7, fields["device_major"])
+	assert.EqualValues(t, 300, fields["device_minor"])
+
+	// The device numbers are persisted and changing them modifies the
+	// attributes.
+	builder, release := fbGetBuilder()
+	defer release()
+	stored := fbDecodeEvent(e.Path, fbEncodeEvent(builder, &e))
+	assert.EqualValues(t, 7, stored.DeviceMajor)
+	assert.EqualValues(t, 300, stored.DeviceMinor)
+	assert.Equal(t, BlockDeviceType, stored.Info.Type)
+
+	action, changed := diffEvents(stored, &e)
+	assert.False(t, changed, "unexpected action %v", action)
+	e.DeviceMinor = 301
+	action, _ = diffEvents(stored, &e)
+	assert.EqualValues(t, AttributesModified, action)
+}
@@ -7,9 +7,11 @@ import (
 	"os/user"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/joeshaw/multierror"
 	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 )
 
 // NewMetadata returns a new Metadata object. If an error is returned it is
@@ -39,6 +41,10 @@ func NewMetadata(path string, info os.FileInfo) (*Metadata, error) {
 		fileInfo.Type = DirType
 	case info.Mode()&os.ModeSymlink > 0:
 		fileInfo.Type = SymlinkType
+	case info.Mode()&os.ModeCharDevice != 0:
+		fileInfo.Type = CharDeviceType
+	case info.Mode()&os.ModeDevice != 0:
+		fileInfo.Type = BlockDeviceType
 	}
 
 	// Lookup UID and GID
@@ -61,3 +67,24 @@ func NewMetadata(path string, info os.FileInfo) (*Metadata, error) {
 	}
 	return fileInfo, errs.Err()
 }
+
+// accessTime returns the last access time of the file. The second return
+// value is false if it could not be determined.
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	atime, _, _ := fileTimes(stat)
+	return atime, true
+}
+
+// deviceNumbers returns the major and minor device numbers of a device node.
+func deviceNumbers(info os.FileInfo) (major, minor uint32) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	rdev := uint64(stat.Rdev)
+	return unix.Major(rdev), unix.Minor(rdev)
+}
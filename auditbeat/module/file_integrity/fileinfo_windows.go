@@ -54,6 +54,22 @@ func NewMetadata(path string, info os.FileInfo) (*Metadata, error) {
 	return fileInfo, err
 }
 
+// accessTime returns the last access time of the file. The second return
+// value is false if it could not be determined.
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, attrs.LastAccessTime.Nanoseconds()).UTC(), true
+}
+
+// deviceNumbers returns zero device numbers because there are no device nodes
+// on Windows.
+func deviceNumbers(info os.FileInfo) (major, minor uint32) {
+	return 0, 0
+}
+
 // fileOwner returns the SID and name (domain\user) of the file's owner.
 func fileOwner(path string) (sid, owner string, err error) {
 	f, err := file.ReadOpen(path)
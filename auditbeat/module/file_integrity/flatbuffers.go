@@ -71,6 +71,8 @@ func fbWriteHash(b *flatbuffers.Builder, hashes map[HashType]Digest) flatbuffers
 			schema.HashAddBlake2b384(b, offset)
 		case BLAKE2B_512:
 			schema.HashAddBlake2b512(b, offset)
+		case BLAKE3_256:
+			schema.HashAddBlake3256(b, offset)
 		case MD5:
 			schema.HashAddMd5(b, offset)
 		case SHA1:
@@ -139,6 +141,10 @@ func fbWriteMetadata(b *flatbuffers.Builder, m *Metadata) flatbuffers.UOffsetT {
 		schema.MetadataAddType(b, schema.TypeDir)
 	case SymlinkType:
 		schema.MetadataAddType(b, schema.TypeSymlink)
+	case CharDeviceType:
+		schema.MetadataAddType(b, schema.TypeCharDevice)
+	case BlockDeviceType:
+		schema.MetadataAddType(b, schema.TypeBlockDevice)
 	}
 	return schema.MetadataEnd(b)
 }
@@ -156,6 +162,11 @@ func fbWriteEvent(b *flatbuffers.Builder, e *Event) flatbuffers.UOffsetT {
 		targetPathOffset = b.CreateString(e.TargetPath)
 	}
 
+	var combinedHashOffset flatbuffers.UOffsetT
+	if len(e.CombinedHash) > 0 {
+		combinedHashOffset = b.CreateByteVector(e.CombinedHash)
+	}
+
 	schema.EventStart(b)
 	schema.EventAddTimestampNs(b, e.Timestamp.UnixNano())
 
@@ -184,6 +195,11 @@ func fbWriteEvent(b *flatbuffers.Builder, e *Event) flatbuffers.UOffsetT {
 	if hashesOffset > 0 {
 		schema.EventAddHashes(b, hashesOffset)
 	}
+	schema.EventAddDeviceMajor(b, e.DeviceMajor)
+	schema.EventAddDeviceMinor(b, e.DeviceMinor)
+	if combinedHashOffset > 0 {
+		schema.EventAddCombinedHash(b, combinedHashOffset)
+	}
 
 	return schema.EventEnd(b)
 }
@@ -215,6 +231,14 @@ func fbDecodeEvent(path string, buf []byte) *Event {
 
 	rtn.Info = fbDecodeMetadata(e)
 	rtn.Hashes = fbDecodeHash(e)
+	rtn.DeviceMajor = e.DeviceMajor()
+	rtn.DeviceMinor = e.DeviceMinor()
+	if length := e.CombinedHashLength(); length > 0 {
+		rtn.CombinedHash = make(Digest, length)
+		for i := range rtn.CombinedHash {
+			rtn.CombinedHash[i] = byte(e.CombinedHash(i))
+		}
+	}
 
 	return rtn
 }
@@ -245,6 +269,10 @@ func fbDecodeMetadata(e *schema.Event) *Metadata {
 		rtn.Type = DirType
 	case schema.TypeSymlink:
 		rtn.Type = SymlinkType
+	case schema.TypeCharDevice:
+		rtn.Type = CharDeviceType
+	case schema.TypeBlockDevice:
+		rtn.Type = BlockDeviceType
 	default:
 		rtn.Type = UnknownType
 	}
@@ -273,6 +301,9 @@ func fbDecodeHash(e *schema.Event) map[HashType]Digest {
 		case BLAKE2B_512:
 			length = hash.Blake2b512Length()
 			producer = hash.Blake2b512
+		case BLAKE3_256:
+			length = hash.Blake3256Length()
+			producer = hash.Blake3256
 		case MD5:
 			length = hash.Md5Length()
 			producer = hash.Md5
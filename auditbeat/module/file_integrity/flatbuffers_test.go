@@ -9,6 +9,7 @@ import (
 
 func TestFBEncodeDecode(t *testing.T) {
 	e := testEvent()
+	e.CombinedHash = Digest{0xab, 0xcd, 0x01, 0x23}
 
 	builder, release := fbGetBuilder()
 	defer release()
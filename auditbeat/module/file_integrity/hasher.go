@@ -0,0 +1,190 @@
+package file_integrity
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHashBatchSize        = 64
+	defaultHashBatchMaxFileSize = 1 << 20
+)
+
+// ErrHasherUnavailable is returned by a Hasher when its accelerator is not
+// present. The scanner then computes the digests on the CPU.
+var ErrHasherUnavailable = errors.New("hasher is unavailable")
+
+// Hasher is a backend that computes the digests of many buffers at once, for
+// example by offloading them to a GPU.
+type Hasher interface {
+	// Supports returns true if the backend can compute digests of the given
+	// hash type.
+	Supports(hashType HashType) bool
+
+	// SumBatch returns the digest of each buffer in bufs.
+	SumBatch(hashType HashType, bufs [][]byte) ([]Digest, error)
+}
+
+// cpuHasher is the Hasher used when a backend is unavailable.
+type cpuHasher struct{}
+
+func (cpuHasher) Supports(hashType HashType) bool {
+	_, err := newHashes([]HashType{hashType})
+	return err == nil
+}
+
+func (cpuHasher) SumBatch(hashType HashType, bufs [][]byte) ([]Digest, error) {
+	digests := make([]Digest, len(bufs))
+	for i, buf := range bufs {
+		hashes, err := newHashes([]HashType{hashType})
+		if err != nil {
+			return nil, err
+		}
+		hashes[0].Write(buf)
+		digests[i] = hashes[0].Sum(nil)
+	}
+	return digests, nil
+}
+
+// pendingHash is a file whose contents are waiting to be hashed by the
+// configured Hasher.
+type pendingHash struct {
+	event   Event
+	content []byte
+}
+
+// isBatchHashable returns true if the contents of the file described by the
+// event are hashed in a batch by the configured Hasher.
+func (s *scanner) isBatchHashable(event *Event) bool {
+	if s.config.Hasher == nil || s.config.DoubleRead || event.Info.Size > s.config.HashBatchMaxFileSize ||
+		!s.fitsInMemory(event.Info.Size) {
+		return false
+	}
+	for _, hashType := range s.config.HashTypes {
+		if !s.config.Hasher.Supports(hashType) {
+			return false
+		}
+	}
+	return len(s.config.HashTypes) > 0
+}
+
+// readForBatch reads the contents of the file into event.batchContent so it
+// can be queued for batch hashing. It returns false if the file has grown
+// beyond MaxInMemoryBytes since it was stat'ed, in which case it must be
+// hashed without buffering it.
+func (s *scanner) readForBatch(event *Event) bool {
+	start := time.Now()
+	f, err := s.openFile(event.Path)
+	if err != nil {
+		event.errors = append(event.errors, errors.Wrap(err, "failed to open file for hashing"))
+		return true
+	}
+	defer f.Close()
+
+	content, err := readAllLimited(f, s.config.MaxInMemoryBytes)
+	if err == errExceedsMemoryLimit {
+		s.log.Debugw("File exceeds max_in_memory, hashing it without buffering",
+			"file_path", event.Path)
+		return false
+	}
+	if err != nil {
+		event.errors = append(event.errors, errors.Wrap(err, "failed to read file for hashing"))
+		return true
+	}
+	if content == nil {
+		// A nil content means that the file is not queued.
+		content = []byte{}
+	}
+	event.batchContent = content
+	event.batchReadTime = time.Since(start)
+	return true
+}
+
+// queueHash adds the event to the batch of files waiting to be hashed. The
+// batch is hashed and sent when it is full.
+func (s *scanner) queueHash(event Event, content []byte) error {
+	// Hash the queued files first if the batch would not fit in memory.
+	if len(s.pendingHashes) > 0 && !s.fitsInMemory(s.pendingBytes+uint64(len(content))) {
+		if err := s.flushHashes(); err != nil {
+			return err
+		}
+	}
+
+	s.pendingHashes = append(s.pendingHashes, pendingHash{event, content})
+	s.pendingBytes += uint64(len(content))
+	if len(s.pendingHashes) < s.config.HashBatchSize {
+		return nil
+	}
+	return s.flushHashes()
+}
+
+// flushHashes hashes the queued files using the configured Hasher and sends
+// their events in the order they were queued. The steps that newScanEvent
+// performs after hashing a file are done here for the batch hashed files.
+// The time taken to hash the batch is attributed to its files in proportion
+// to their sizes.
+func (s *scanner) flushHashes() error {
+	batch := s.pendingHashes
+	if len(batch) == 0 {
+		return nil
+	}
+	totalBytes := s.pendingBytes
+	s.pendingHashes = nil
+	s.pendingBytes = 0
+
+	bufs := make([][]byte, len(batch))
+	for i, p := range batch {
+		bufs[i] = p.content
+	}
+
+	start := time.Now()
+	for _, hashType := range s.config.HashTypes {
+		digests, err := s.config.Hasher.SumBatch(hashType, bufs)
+		if err == ErrHasherUnavailable {
+			s.log.Debugw("Hasher is unavailable, falling back to CPU", "hash_type", hashType)
+			digests, err = cpuHasher{}.SumBatch(hashType, bufs)
+		}
+		if err == nil && len(digests) != len(bufs) {
+			err = errors.Errorf("hasher returned %d digests for %d buffers", len(digests), len(bufs))
+		}
+
+		for i := range batch {
+			event := &batch[i].event
+			if err != nil {
+				event.errors = append(event.errors, errors.Wrap(err, "failed to calculate file hashes"))
+				continue
+			}
+			if event.Hashes == nil {
+				event.Hashes = make(map[HashType]Digest, len(s.config.HashTypes))
+			}
+			event.Hashes[hashType] = digests[i]
+		}
+	}
+
+	hashTime := time.Since(start)
+
+	for i := range batch {
+		event := &batch[i].event
+		if len(event.errors) > 0 {
+			event.Hashes = nil
+		} else {
+			took := event.batchReadTime
+			if totalBytes > 0 {
+				took += time.Duration(float64(hashTime) * float64(len(batch[i].content)) / float64(totalBytes))
+			}
+			s.recordHashTime(event, took)
+		}
+		if s.config.RestatAfterHash {
+			s.restat(event)
+		}
+		s.classify(event)
+		if err := s.emit(*event); err != nil {
+			return err
+		}
+		if len(event.Hashes) > 0 {
+			s.throttle(event.Info.Size)
+		}
+	}
+	return nil
+}
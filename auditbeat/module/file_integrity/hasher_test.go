@@ -0,0 +1,176 @@
+package file_integrity
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBatchHasher struct {
+	sync.Mutex
+	unavailable bool
+	batches     []int // Size of each batch.
+}
+
+func (h *fakeBatchHasher) Supports(hashType HashType) bool {
+	return hashType == SHA256
+}
+
+func (h *fakeBatchHasher) SumBatch(hashType HashType, bufs [][]byte) ([]Digest, error) {
+	h.Lock()
+	defer h.Unlock()
+	h.batches = append(h.batches, len(bufs))
+	if h.unavailable {
+		return nil, ErrHasherUnavailable
+	}
+	return cpuHasher{}.SumBatch(hashType, bufs)
+}
+
+func TestScannerHasher(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	scan := func(c Config) []Event {
+		_, events := runScan(t, c)
+		for _, event := range events {
+			assert.Empty(t, event.errors, event.Path)
+		}
+		return events
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	config.HashTypes = []HashType{SHA256}
+	config.CombinedHash = SHA256
+	expected := scan(config)
+
+	paths := func(events []Event) []string {
+		var paths []string
+		for _, e := range events {
+			paths = append(paths, e.Path)
+		}
+		return paths
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		hasher := &fakeBatchHasher{}
+		c := config
+		c.Hasher = hasher
+		c.HashBatchSize = 2
+
+		events := scan(c)
+		assert.Equal(t, paths(expected), paths(events), "event order must not change")
+		for i := range expected {
+			assert.Equal(t, expected[i].Hashes, events[i].Hashes, events[i].Path)
+			assert.Equal(t, expected[i].CombinedHash, events[i].CombinedHash, events[i].Path)
+		}
+		assert.Equal(t, []int{2, 1}, hasher.batches)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		hasher := &fakeBatchHasher{unavailable: true}
+		c := config
+		c.Hasher = hasher
+
+		events := scan(c)
+		for i := range expected {
+			assert.Equal(t, expected[i].Hashes, events[i].Hashes, events[i].Path)
+		}
+		assert.NotEmpty(t, hasher.batches)
+	})
+
+	t.Run("max_in_memory", func(t *testing.T) {
+		// Two of the 6 byte files do not fit in memory at once.
+		hasher := &fakeBatchHasher{}
+		c := config
+		c.Hasher = hasher
+		c.HashBatchSize = 2
+		c.MaxInMemoryBytes = 10
+
+		events := scan(c)
+		for i := range expected {
+			assert.Equal(t, expected[i].Hashes, events[i].Hashes, events[i].Path)
+		}
+		assert.Equal(t, []int{1, 1, 1}, hasher.batches)
+	})
+
+	t.Run("exceeds max_in_memory", func(t *testing.T) {
+		// Files larger than the limit are hashed without buffering them.
+		hasher := &fakeBatchHasher{}
+		c := config
+		c.Hasher = hasher
+		c.MaxInMemoryBytes = 5
+
+		events := scan(c)
+		for i := range expected {
+			assert.Equal(t, expected[i].Hashes, events[i].Hashes, events[i].Path)
+		}
+		assert.Empty(t, hasher.batches)
+	})
+
+	t.Run("post-hash steps", func(t *testing.T) {
+		// The steps that follow the hashing of a file are applied to batch
+		// hashed files too.
+		hasher := &fakeBatchHasher{}
+		c := config
+		c.Hasher = hasher
+		c.HashBatchSize = 2
+		c.RestatAfterHash = true
+		c.IncludeReadThroughput = true
+		c.SummaryTopN = 10
+
+		s, events := runScan(t, c)
+		var files []string
+		for _, e := range events {
+			if e.Info.Type != FileType {
+				continue
+			}
+			files = append(files, e.Path)
+			if assert.NotNil(t, e.ScanWindow, e.Path) {
+				assert.False(t, e.ChangedDuringScan, e.Path)
+			}
+			assert.True(t, e.ReadThroughputMBps > 0, e.Path)
+		}
+		assert.Equal(t, []int{2, 1}, hasher.batches)
+
+		var slowest []string
+		for _, entry := range s.slowest.Entries() {
+			slowest = append(slowest, entry.Path)
+		}
+		assert.ElementsMatch(t, files, slowest)
+	})
+
+	t.Run("unsupported hash type", func(t *testing.T) {
+		hasher := &fakeBatchHasher{}
+		c := config
+		c.Hasher = hasher
+		c.HashTypes = []HashType{SHA1, SHA256}
+
+		for _, e := range scan(c) {
+			if filepath.Base(e.Path) == "a" {
+				assert.Len(t, e.Hashes, 2)
+			}
+		}
+		assert.Empty(t, hasher.batches)
+	})
+}
+
+func TestReadAllLimited(t *testing.T) {
+	data, err := readAllLimited(strings.NewReader("0123456789"), 10)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "0123456789", string(data))
+	}
+
+	_, err = readAllLimited(strings.NewReader("0123456789"), 9)
+	assert.Equal(t, errExceedsMemoryLimit, err)
+
+	data, err = readAllLimited(strings.NewReader("0123456789"), 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "0123456789", string(data))
+	}
+}
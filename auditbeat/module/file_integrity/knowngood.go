@@ -0,0 +1,194 @@
+package file_integrity
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+)
+
+// Handling of known-good filters whose estimated false positive rate exceeds
+// the configured maximum.
+const (
+	FalsePositiveReject = "reject" // The scanner fails to start.
+	FalsePositiveWarn   = "warn"   // The filter is used and a warning is logged.
+	FalsePositiveIgnore = "ignore" // The filter is not used and a warning is logged.
+)
+
+const (
+	defaultKnownGoodMaxFalsePositiveRate = 0.001
+
+	knownGoodMagic   = "FIMBLOOM"
+	knownGoodVersion = 1
+
+	// Limits of the filter parameters so that a corrupted file cannot make
+	// the scanner allocate excessive amounts of memory. 512 MiB of bits hold
+	// hundreds of millions of hashes at the default false positive rate.
+	knownGoodMaxBits   = 1 << 32
+	knownGoodMaxHashes = 64
+)
+
+// KnownGoodConfig configures an offline lookup that classifies files whose
+// hash is in a local bloom filter of known-good hashes as KnownGood.
+type KnownGoodConfig struct {
+	Path                  string  `config:"path"`                    // Filter file written by WriteKnownGoodFilter. Empty disables the lookup.
+	MaxFalsePositiveRate  float64 `config:"max_false_positive_rate"` // Highest acceptable false positive rate estimated from the filter.
+	FalsePositiveHandling string  `config:"false_positive_handling"` // FalsePositiveReject (default), FalsePositiveWarn, or FalsePositiveIgnore.
+}
+
+func (c *KnownGoodConfig) validate() error {
+	var errs multierror.Errors
+	if c.MaxFalsePositiveRate < 0 || c.MaxFalsePositiveRate >= 1 {
+		errs = append(errs, errors.Errorf("known_good.max_false_positive_rate value (%v) must be in [0, 1)", c.MaxFalsePositiveRate))
+	}
+	switch c.FalsePositiveHandling {
+	case "", FalsePositiveReject, FalsePositiveWarn, FalsePositiveIgnore:
+	default:
+		errs = append(errs, errors.Errorf("invalid known_good.false_positive_handling value '%v'", c.FalsePositiveHandling))
+	}
+	return errs.Err()
+}
+
+// WriteKnownGoodFilter writes a bloom filter of the hashType digests of
+// known-good files for use as known_good.path. The filter is sized for the
+// given false positive rate.
+func WriteKnownGoodFilter(w io.Writer, hashType HashType, digests []Digest, falsePositiveRate float64) error {
+	bloom := newBloomFilter(len(digests), falsePositiveRate)
+	for _, digest := range digests {
+		bloom.Add(digest)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(knownGoodMagic)
+	bw.WriteByte(knownGoodVersion)
+	bw.WriteByte(byte(len(hashType)))
+	bw.WriteString(string(hashType))
+	binary.Write(bw, binary.BigEndian, bloom.m)
+	binary.Write(bw, binary.BigEndian, bloom.k)
+	if err := binary.Write(bw, binary.BigEndian, bloom.bits); err != nil {
+		return errors.Wrap(err, "failed to write known-good filter")
+	}
+	return errors.Wrap(bw.Flush(), "failed to write known-good filter")
+}
+
+// readKnownGoodFilter reads a filter of size bytes written by
+// WriteKnownGoodFilter.
+func readKnownGoodFilter(r io.Reader, size int64) (HashType, *bloomFilter, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(knownGoodMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return "", nil, errors.Wrap(err, "failed to read header")
+	}
+	if string(header[:len(knownGoodMagic)]) != knownGoodMagic {
+		return "", nil, errors.New("not a known-good filter file")
+	}
+	if version := header[len(knownGoodMagic)]; version != knownGoodVersion {
+		return "", nil, errors.Errorf("unsupported known-good filter version %d", version)
+	}
+
+	hashType := make([]byte, header[len(header)-1])
+	var m, k uint64
+	if _, err := io.ReadFull(br, hashType); err != nil {
+		return "", nil, errors.Wrap(err, "failed to read hash type")
+	}
+	if err := binary.Read(br, binary.BigEndian, &m); err != nil {
+		return "", nil, errors.Wrap(err, "failed to read filter size")
+	}
+	if err := binary.Read(br, binary.BigEndian, &k); err != nil {
+		return "", nil, errors.Wrap(err, "failed to read filter size")
+	}
+	if m < 64 || m > knownGoodMaxBits || k < 1 || k > knownGoodMaxHashes {
+		return "", nil, errors.Errorf("invalid filter size (m=%d, k=%d)", m, k)
+	}
+	remaining := size - int64(len(header)+len(hashType)+16)
+	if words := int64(m+63) / 64; words*8 != remaining {
+		return "", nil, errors.Errorf("filter size (m=%d) does not match the file size (%d bytes)", m, size)
+	}
+
+	// Decode the bits in chunks rather than with binary.Read, which would
+	// allocate a second buffer of the size of the filter.
+	bloom := newBloomFilterSize(m, k)
+	buf := make([]byte, 8<<10)
+	for bits := bloom.bits; len(bits) > 0; {
+		n := len(bits)
+		if n > len(buf)/8 {
+			n = len(buf) / 8
+		}
+		if _, err := io.ReadFull(br, buf[:n*8]); err != nil {
+			return "", nil, errors.Wrap(err, "failed to read filter")
+		}
+		for i := range bits[:n] {
+			bits[i] = binary.BigEndian.Uint64(buf[i*8:])
+		}
+		bits = bits[n:]
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return "", nil, errors.New("unexpected data after filter")
+	}
+	return HashType(hashType), bloom, nil
+}
+
+// loadKnownGood loads the known_good filter and makes it the first stage of
+// the ReputationLookup. Files that are not in the filter are looked up by the
+// configured ReputationLookup, if any. Its answers are not added to the
+// filter so that the false positive rate is not raised. Files in the filter
+// are not looked up, so a false positive hides the answer of the
+// ReputationLookup for that file.
+func (s *scanner) loadKnownGood() error {
+	c := s.config.KnownGood
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open known_good filter")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat known_good filter")
+	}
+	hashType, bloom, err := readKnownGoodFilter(f, info.Size())
+	if err != nil {
+		return errors.Wrapf(err, "failed to load known_good filter %v", c.Path)
+	}
+
+	var configured bool
+	for _, t := range s.config.HashTypes {
+		if t == hashType {
+			configured = true
+			break
+		}
+	}
+	if !configured {
+		return errors.Errorf("known_good filter %v contains %v hashes which are not in hash_types", c.Path, hashType)
+	}
+
+	max := c.MaxFalsePositiveRate
+	if max == 0 {
+		max = defaultKnownGoodMaxFalsePositiveRate
+	}
+	if rate := bloom.falsePositiveRate(); rate > max {
+		switch c.FalsePositiveHandling {
+		case FalsePositiveWarn:
+			s.log.Warnw("Using known_good filter despite a false positive rate above the maximum",
+				"file_path", c.Path, "false_positive_rate", rate, "max_false_positive_rate", max)
+		case FalsePositiveIgnore:
+			s.log.Warnw("Ignoring known_good filter because its false positive rate is above the maximum",
+				"file_path", c.Path, "false_positive_rate", rate, "max_false_positive_rate", max)
+			return nil
+		default:
+			return errors.Errorf("false positive rate of known_good filter %v (%v) is above the maximum (%v)",
+				c.Path, rate, max)
+		}
+	}
+
+	s.config.ReputationLookup = &allowlistLookup{
+		hashType: hashType,
+		remote:   s.config.ReputationLookup,
+		frozen:   true,
+		bloom:    bloom,
+	}
+	return nil
+}
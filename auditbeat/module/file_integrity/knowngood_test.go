@@ -0,0 +1,163 @@
+package file_integrity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKnownGoodFile writes a known-good filter of the SHA1 digests of the
+// given contents to a temporary file and returns its path.
+func writeKnownGoodFile(t *testing.T, falsePositiveRate float64, contents ...string) string {
+	digests := make([]Digest, 0, len(contents))
+	for _, c := range contents {
+		digests = append(digests, sha1Digest(c))
+	}
+
+	f, err := ioutil.TempFile("", "known-good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = WriteKnownGoodFilter(f, SHA1, digests, falsePositiveRate); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestScannerKnownGood(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "unknown"), []byte("not known-good"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := writeKnownGoodFile(t, 1e-6, "file a", "file b")
+	defer os.Remove(filter)
+
+	config := defaultConfig
+	config.KnownGood.Path = filter
+	events := scanEvents(t, config, dir)
+
+	reputations := map[string]Reputation{}
+	for path, e := range events {
+		reputations[filepath.Base(path)] = e.Reputation
+	}
+	assert.Equal(t, KnownGood, reputations["a"])
+	assert.Equal(t, KnownGood, reputations["b"])
+	assert.Equal(t, UnknownReputation, reputations["unknown"])
+
+	e := events[filepath.Join(dir, "a")]
+	fields := buildMetricbeatEvent(&e, false).MetricSetFields
+	reputation, err := fields.GetValue("file.reputation")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "known_good", reputation)
+	}
+	knownGood, err := fields.GetValue("file.known_good")
+	if assert.NoError(t, err) {
+		assert.Equal(t, true, knownGood)
+	}
+	e = events[filepath.Join(dir, "unknown")]
+	_, err = buildMetricbeatEvent(&e, false).MetricSetFields.GetValue("file.known_good")
+	assert.Error(t, err, "files that are not known-good are not annotated")
+
+	// Files outside the filter are looked up by the configured lookup.
+	config.ReputationLookup = &fakeReputationLookup{reputation: UnknownReputation}
+	events = scanEvents(t, config, dir)
+	assert.Equal(t, KnownGood, events[filepath.Join(dir, "a")].Reputation)
+	assert.Equal(t, UnknownReputation, events[filepath.Join(dir, "unknown")].Reputation)
+
+	// Files in the filter are confirmed by the configured lookup so a
+	// known_bad answer is not hidden by a false positive.
+	config.ReputationLookup = &fakeReputationLookup{reputation: KnownBad}
+	events = scanEvents(t, config, dir)
+	assert.Equal(t, KnownBad, events[filepath.Join(dir, "a")].Reputation)
+	assert.Equal(t, KnownBad, events[filepath.Join(dir, "unknown")].Reputation)
+}
+
+func TestKnownGoodFalsePositiveHandling(t *testing.T) {
+	// A filter that is far too small for its contents.
+	contents := make([]string, 1000)
+	for i := range contents {
+		contents[i] = strconv.Itoa(i)
+	}
+	filter := writeKnownGoodFile(t, 0.5, contents...)
+	defer os.Remove(filter)
+
+	newScanner := func(handling string) (*scanner, error) {
+		config := defaultConfig
+		config.Paths = []string{os.TempDir()}
+		config.KnownGood = KnownGoodConfig{Path: filter, FalsePositiveHandling: handling}
+		reader, err := NewFileSystemScanner(config)
+		if err != nil {
+			return nil, err
+		}
+		return reader.(*scanner), nil
+	}
+
+	_, err := newScanner("")
+	assert.Error(t, err, "filters above the maximum false positive rate are rejected by default")
+
+	s, err := newScanner(FalsePositiveWarn)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, s.config.ReputationLookup)
+	}
+
+	s, err = newScanner(FalsePositiveIgnore)
+	if assert.NoError(t, err) {
+		assert.Nil(t, s.config.ReputationLookup)
+	}
+}
+
+func TestReadKnownGoodFilter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteKnownGoodFilter(&buf, SHA256, []Digest{sha1Digest("x")}, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	hashType, bloom, err := readKnownGoodFilter(bytes.NewReader(data), int64(len(data)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, SHA256, hashType)
+		assert.True(t, bloom.MayContain(sha1Digest("x")))
+	}
+
+	_, _, err = readKnownGoodFilter(bytes.NewReader(data[:len(data)-1]), int64(len(data)-1))
+	assert.Error(t, err, "truncated filter")
+	_, _, err = readKnownGoodFilter(bytes.NewReader(data[:len(data)-1]), int64(len(data)))
+	assert.Error(t, err, "truncated file")
+	_, _, err = readKnownGoodFilter(bytes.NewReader(append(data[:len(data):len(data)], 0)), int64(len(data)+1))
+	assert.Error(t, err, "trailing data")
+	_, _, err = readKnownGoodFilter(bytes.NewReader([]byte("not a filter")), 12)
+	assert.Error(t, err, "bad magic")
+
+	// A filter size that is within the limits but larger than the file is
+	// rejected before the filter is allocated.
+	huge := append([]byte(nil), data...)
+	binary.BigEndian.PutUint64(huge[len(knownGoodMagic)+2+len(SHA256):], knownGoodMaxBits)
+	_, _, err = readKnownGoodFilter(bytes.NewReader(huge), int64(len(huge)))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not match the file size")
+	}
+
+	// The hash type of the filter must be configured.
+	f, err := ioutil.TempFile("", "known-good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(data)
+	f.Close()
+
+	config := defaultConfig
+	config.KnownGood.Path = f.Name()
+	_, err = NewFileSystemScanner(config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package file_integrity
+
+import (
+	"sort"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// DeletionManifestConfig configures a single event that lists all the files
+// deleted since the previous scan. It is published when a scan completes.
+type DeletionManifestConfig struct {
+	Enabled       bool `config:"enabled"`
+	ReplaceEvents bool `config:"replace_events"` // Don't publish an event per deleted file.
+}
+
+// buildDeletionManifest builds the deletion manifest event of the scan that
+// started at scanStart.
+func buildDeletionManifest(deleted []string, scanStart time.Time) mb.Event {
+	if deleted == nil {
+		deleted = []string{}
+	}
+	sort.Strings(deleted)
+
+	return mb.Event{
+		Timestamp: time.Now().UTC(),
+		MetricSetFields: common.MapStr{
+			"deletion_manifest": common.MapStr{
+				"paths":      deleted,
+				"count":      len(deleted),
+				"scan_start": scanStart,
+			},
+		},
+	}
+}
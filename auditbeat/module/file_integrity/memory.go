@@ -0,0 +1,41 @@
+package file_integrity
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// errExceedsMemoryLimit is returned when buffering content would exceed
+// MaxInMemoryBytes.
+var errExceedsMemoryLimit = errors.New("content exceeds max_in_memory")
+
+// fitsInMemory returns true if size bytes of file content may be held in
+// memory at once. Every feature that buffers file contents must check it and
+// fall back to streaming the file when it returns false.
+func (s *scanner) fitsInMemory(size uint64) bool {
+	max := s.config.MaxInMemoryBytes
+	return max == 0 || size <= max
+}
+
+// readAllLimited reads r until EOF. It returns errExceedsMemoryLimit without
+// buffering the rest of r if it has more than max bytes. A max of 0 means no
+// limit.
+func readAllLimited(r io.Reader, max uint64) ([]byte, error) {
+	if max == 0 {
+		var buf bytes.Buffer
+		_, err := buf.ReadFrom(r)
+		return buf.Bytes(), err
+	}
+
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) > max {
+		return nil, errExceedsMemoryLimit
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,259 @@
+package file_integrity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/auditbeat/datastore"
+)
+
+// merkleRootKey is the key of the persisted root in the merkle bucket.
+const merkleRootKey = "root"
+
+// MerkleTree is a Merkle tree over the hashes of a set of files. It is built
+// like the trees of RFC 6962 (Certificate Transparency) with the leaves sorted
+// by path. Leaves commit to both the path and the digest of the file so an
+// inclusion proof shows that a specific file had a specific hash when the root
+// was computed.
+type MerkleTree struct {
+	paths  []string
+	leaves []Digest // Leaf hashes in path order.
+	root   Digest
+}
+
+// MerkleProof proves that the file at Path with the given Digest is included
+// in the tree with the root the proof is verified against.
+type MerkleProof struct {
+	Path     string   `json:"path"`
+	Digest   Digest   `json:"digest"`    // Hash of the file contents.
+	Index    uint64   `json:"index"`     // Position of the leaf in the tree.
+	TreeSize uint64   `json:"tree_size"` // Number of leaves in the tree.
+	Siblings []Digest `json:"siblings"`  // Audit path from the leaf to the root.
+}
+
+// NewMerkleTree builds a Merkle tree over the given file digests that are
+// keyed by path.
+func NewMerkleTree(files map[string]Digest) *MerkleTree {
+	t := &MerkleTree{paths: make([]string, 0, len(files))}
+	for path := range files {
+		t.paths = append(t.paths, path)
+	}
+	sort.Strings(t.paths)
+
+	t.leaves = make([]Digest, len(t.paths))
+	for i, path := range t.paths {
+		t.leaves[i] = merkleLeafHash(path, files[path])
+	}
+	t.root = merkleTreeHash(t.leaves)
+	return t
+}
+
+// Root returns the root hash of the tree.
+func (t *MerkleTree) Root() Digest {
+	return t.root
+}
+
+// Proof returns an inclusion proof for the file at path.
+func (t *MerkleTree) Proof(path string, digest Digest) (*MerkleProof, error) {
+	i := sort.SearchStrings(t.paths, path)
+	if i == len(t.paths) || t.paths[i] != path {
+		return nil, errors.Errorf("path %v is not in the merkle tree", path)
+	}
+	if !bytes.Equal(t.leaves[i], merkleLeafHash(path, digest)) {
+		return nil, errors.Errorf("digest of %v does not match the merkle tree", path)
+	}
+
+	return &MerkleProof{
+		Path:     path,
+		Digest:   digest,
+		Index:    uint64(i),
+		TreeSize: uint64(len(t.leaves)),
+		Siblings: merkleAuditPath(uint64(i), t.leaves),
+	}, nil
+}
+
+// VerifyMerkleProof returns true if the proof shows that its file is included
+// in the tree with the given root.
+func VerifyMerkleProof(root Digest, proof *MerkleProof) bool {
+	if proof == nil || proof.Index >= proof.TreeSize {
+		return false
+	}
+
+	fn, sn := proof.Index, proof.TreeSize-1
+	r := merkleLeafHash(proof.Path, proof.Digest)
+	for _, sibling := range proof.Siblings {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(sibling, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+func merkleLeafHash(path string, digest Digest) Digest {
+	h := sha256.New()
+	h.Write([]byte{0})
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(path)))
+	h.Write(n[:])
+	h.Write([]byte(path))
+	h.Write(digest)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left, right Digest) Digest {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleSplit returns the largest power of two smaller than n (n > 1).
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleTreeHash returns the root hash of the tree over the leaf hashes.
+func merkleTreeHash(leaves []Digest) Digest {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNodeHash(merkleTreeHash(leaves[:k]), merkleTreeHash(leaves[k:]))
+}
+
+// merkleAuditPath returns the hashes needed to compute the root from the leaf
+// at index m.
+func merkleAuditPath(m uint64, leaves []Digest) []Digest {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if m < uint64(k) {
+		return append(merkleAuditPath(m, leaves[:k]), merkleTreeHash(leaves[k:]))
+	}
+	return append(merkleAuditPath(m-uint64(k), leaves[k:]), merkleTreeHash(leaves[:k]))
+}
+
+// merkleRoot is the persisted root of the Merkle tree built by a scan.
+type merkleRoot struct {
+	Root      []byte    `json:"root"`
+	LeafCount int       `json:"leaf_count"`
+	ScanStart time.Time `json:"scan_start"`
+}
+
+// merkleSummary compares the root of the tree built by a scan to the root of
+// the previous scan. It is included in the scan summary.
+type merkleSummary struct {
+	Root         Digest
+	PreviousRoot Digest // Nil if no previous root was persisted.
+	LeafCount    int
+}
+
+// changed returns true if the root differs from the root of the previous scan.
+func (m *merkleSummary) changed() bool {
+	return m.PreviousRoot != nil && !bytes.Equal(m.Root, m.PreviousRoot)
+}
+
+func loadMerkleRoot(b datastore.Bucket) (*merkleRoot, error) {
+	var root *merkleRoot
+	err := b.Load(merkleRootKey, func(blob []byte) error {
+		root = &merkleRoot{}
+		return json.Unmarshal(blob, root)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load merkle root")
+	}
+	return root, nil
+}
+
+func storeMerkleRoot(b datastore.Bucket, root *merkleRoot) error {
+	data, err := json.Marshal(root)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode merkle root")
+	}
+	if err = b.Store(merkleRootKey, data); err != nil {
+		return errors.Wrap(err, "failed to store merkle root")
+	}
+	return nil
+}
+
+// addMerkleLeaf adds the regular file described by the scan event to the
+// leaves of the Merkle tree. Files without a digest of the first configured
+// hash type, for example because they could not be read, are left out.
+func (ms *MetricSet) addMerkleLeaf(event *Event) {
+	if event.Source != SourceScan || event.Info == nil || event.Info.Type != FileType {
+		return
+	}
+	if digest := event.Hashes[ms.config.HashTypes[0]]; len(digest) > 0 {
+		ms.merkleFiles[event.Path] = digest
+	}
+}
+
+// updateMerkleRoot builds the Merkle tree of the completed scan, compares its
+// root to the one persisted by the previous scan, and persists the new root.
+func (ms *MetricSet) updateMerkleRoot() *merkleSummary {
+	ms.merkleTree = NewMerkleTree(ms.merkleFiles)
+	summary := &merkleSummary{Root: ms.merkleTree.Root(), LeafCount: len(ms.merkleFiles)}
+
+	previous, err := loadMerkleRoot(ms.merkle)
+	if err != nil {
+		ms.log.Warnw("Failed to load the merkle root of the previous scan", "error", err)
+	} else if previous != nil {
+		summary.PreviousRoot = previous.Root
+	}
+
+	err = storeMerkleRoot(ms.merkle, &merkleRoot{
+		Root:      summary.Root,
+		LeafCount: summary.LeafCount,
+		ScanStart: ms.scanStart,
+	})
+	if err != nil {
+		ms.log.Errorw("Failed to persist the merkle root", "error", err)
+	}
+
+	if summary.changed() {
+		ms.log.Infow("Merkle root of the scanned files changed",
+			"merkle_root", summary.Root, "previous_merkle_root", summary.PreviousRoot,
+			"leaf_count", summary.LeafCount)
+	} else {
+		ms.log.Debugw("Computed merkle root of the scanned files",
+			"merkle_root", summary.Root, "leaf_count", summary.LeafCount)
+	}
+	return summary
+}
+
+// MerkleProof returns an inclusion proof for the file at path in the Merkle
+// tree of the last completed scan. The proof can be verified with
+// VerifyMerkleProof against the root persisted by that scan.
+func (ms *MetricSet) MerkleProof(path string) (*MerkleProof, error) {
+	if ms.merkleTree == nil {
+		return nil, errors.New("no scan with merkle_root enabled has completed")
+	}
+	return ms.merkleTree.Proof(path, ms.merkleFiles[path])
+}
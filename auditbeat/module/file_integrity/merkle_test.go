@@ -0,0 +1,65 @@
+package file_integrity
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		files := map[string]Digest{}
+		for i := 0; i < size; i++ {
+			sum := sha1.Sum([]byte(fmt.Sprintf("file %d", i)))
+			files[fmt.Sprintf("/etc/file-%02d", i)] = sum[:]
+		}
+		tree := NewMerkleTree(files)
+		root := tree.Root()
+
+		for path, digest := range files {
+			proof, err := tree.Proof(path, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, VerifyMerkleProof(root, proof), "size=%d path=%v", size, path)
+		}
+	}
+}
+
+func TestMerkleProofTampered(t *testing.T) {
+	files := map[string]Digest{
+		"/bin/ls": sha1Digest("ls"),
+		"/bin/sh": sha1Digest("sh"),
+		"/bin/su": sha1Digest("su"),
+	}
+	tree := NewMerkleTree(files)
+	root := tree.Root()
+
+	proof, err := tree.Proof("/bin/sh", files["/bin/sh"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, VerifyMerkleProof(root, proof))
+
+	tampered := *proof
+	tampered.Digest = sha1Digest("evil")
+	assert.False(t, VerifyMerkleProof(root, &tampered), "tampered digest")
+
+	tampered = *proof
+	tampered.Path = "/bin/ls"
+	assert.False(t, VerifyMerkleProof(root, &tampered), "tampered path")
+
+	tampered = *proof
+	tampered.Index = 0
+	assert.False(t, VerifyMerkleProof(root, &tampered), "tampered index")
+
+	files["/bin/sh"] = sha1Digest("evil")
+	assert.False(t, VerifyMerkleProof(NewMerkleTree(files).Root(), proof), "different root")
+
+	_, err = tree.Proof("/bin/sh", sha1Digest("evil"))
+	assert.Error(t, err)
+	_, err = tree.Proof("/bin/bash", sha1Digest("bash"))
+	assert.Error(t, err)
+}
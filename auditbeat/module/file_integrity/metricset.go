@@ -2,6 +2,7 @@ package file_integrity
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"time"
 
@@ -19,6 +20,13 @@ const (
 	metricsetName = "file"
 	bucketName    = "file.v1"
 
+	// partialHashBucketName holds the state of files whose hashing will be
+	// resumed by the next scan.
+	partialHashBucketName = "file.partial_hash.v1"
+
+	// merkleBucketName holds the Merkle root of the last scan.
+	merkleBucketName = "file.merkle.v1"
+
 	// Use old namespace for data until we do some field renaming for GA.
 	namespace = "."
 )
@@ -52,9 +60,16 @@ type MetricSet struct {
 
 	// Runtime params that are initialized on Run().
 	bucket       datastore.BoltBucket
+	partial      datastore.Bucket // Only open when ResumableHashing is enabled.
+	merkle       datastore.Bucket // Only open when MerkleRoot is enabled.
 	scanStart    time.Time
 	scanChan     <-chan Event
 	fsnotifyChan <-chan Event
+
+	// merkleFiles holds the digests of the files found by the scan and
+	// merkleTree the tree built over them once the scan completes.
+	merkleFiles map[string]Digest
+	merkleTree  *MerkleTree
 }
 
 // New returns a new file.MetricSet.
@@ -76,13 +91,6 @@ func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
 		log:           logp.NewLogger(moduleName),
 	}
 
-	if config.ScanAtStart {
-		ms.scanner, err = NewFileSystemScanner(config)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to initialize file scanner")
-		}
-	}
-
 	ms.log.Debugf("Initialized the file event reader. Running as euid=%v", os.Geteuid())
 
 	return ms, nil
@@ -108,8 +116,18 @@ func (ms *MetricSet) Run(reporter mb.PushReporterV2) {
 			if !ok {
 				ms.scanChan = nil
 				// When the scan completes purge datastore keys that no longer
-				// exist on disk based on being older than scanStart.
-				ms.purgeDeleted(reporter)
+				// exist on disk based on being older than scanStart. A warm
+				// cache pass leaves them so that the next scan reports them.
+				if !ms.config.WarmCacheOnly {
+					ms.purgeDeleted(reporter)
+				}
+				var merkle *merkleSummary
+				if ms.merkle != nil {
+					merkle = ms.updateMerkleRoot()
+				}
+				if ms.config.PublishScanSummary {
+					ms.publishScanSummary(reporter, merkle)
+				}
 				continue
 			}
 
@@ -122,6 +140,12 @@ func (ms *MetricSet) Run(reporter mb.PushReporterV2) {
 
 // Close cleans up the MetricSet when it finishes.
 func (ms *MetricSet) Close() error {
+	if ms.partial != nil {
+		ms.partial.Close()
+	}
+	if ms.merkle != nil {
+		ms.merkle.Close()
+	}
 	if ms.bucket != nil {
 		return ms.bucket.Close()
 	}
@@ -138,6 +162,27 @@ func (ms *MetricSet) init(reporter mb.PushReporterV2) bool {
 	}
 	ms.bucket = bucket.(datastore.BoltBucket)
 
+	if ms.config.ResumableHashing {
+		ms.partial, err = datastore.OpenBucket(partialHashBucketName)
+		if err != nil {
+			err = errors.Wrap(err, "failed to open persistent datastore")
+			reporter.Error(err)
+			ms.log.Errorw("Failed to initialize", "error", err)
+			return false
+		}
+	}
+
+	if ms.config.MerkleRoot && ms.config.ScanAtStart {
+		ms.merkle, err = datastore.OpenBucket(merkleBucketName)
+		if err != nil {
+			err = errors.Wrap(err, "failed to open persistent datastore")
+			reporter.Error(err)
+			ms.log.Errorw("Failed to initialize", "error", err)
+			return false
+		}
+		ms.merkleFiles = map[string]Digest{}
+	}
+
 	ms.fsnotifyChan, err = ms.reader.Start(reporter.Done())
 	if err != nil {
 		err = errors.Wrap(err, "failed to start fsnotify event producer")
@@ -146,6 +191,20 @@ func (ms *MetricSet) init(reporter mb.PushReporterV2) bool {
 		return false
 	}
 
+	if ms.config.ScanAtStart {
+		// The scanner is created after opening the datastore so that it
+		// can access the persisted state.
+		config := ms.config
+		config.State = bucketStateStore{ms.bucket, ms.partial}
+		ms.scanner, err = NewFileSystemScanner(config)
+		if err != nil {
+			err = errors.Wrap(err, "failed to initialize file scanner")
+			reporter.Error(err)
+			ms.log.Errorw("Failed to initialize", "error", err)
+			return false
+		}
+	}
+
 	ms.scanStart = time.Now().UTC()
 	if ms.scanner != nil {
 		ms.scanChan, err = ms.scanner.Start(reporter.Done())
@@ -169,10 +228,25 @@ func (ms *MetricSet) reportEvent(reporter mb.PushReporterV2, event *Event) bool
 			"action", event.Action, "errors", event.errors)
 	}
 
+	// Rollups describe a directory's children rather than the directory
+	// itself, skip events describe paths that were not scanned, and vanished
+	// events describe files that disappeared during the scan so they are
+	// neither diffed nor persisted. The deletion of a vanished file is
+	// reported when the scan completes.
+	if event.Rollup != nil || event.Skipped || event.Vanished {
+		return reporter.Event(ms.buildEvent(event, false))
+	}
+
+	if ms.merkleFiles != nil {
+		ms.addMerkleLeaf(event)
+	}
+
+	// The state of suppressed files is persisted so that they are not
+	// reported as deleted, but their changes are not published.
 	changed, lastEvent := ms.hasFileChangedSinceLastEvent(event)
-	if changed {
+	if changed && !event.Suppressed {
 		// Publish event if it changed.
-		if ok := reporter.Event(buildMetricbeatEvent(event, lastEvent != nil)); !ok {
+		if ok := reporter.Event(ms.buildEvent(event, lastEvent != nil)); !ok {
 			return false
 		}
 	}
@@ -211,8 +285,30 @@ func (ms *MetricSet) hasFileChangedSinceLastEvent(event *Event) (changed bool, l
 	return changed, lastEvent
 }
 
+// buildEvent converts the event to the configured event format and redacts
+// the configured fields.
+func (ms *MetricSet) buildEvent(e *Event, existedBefore bool) mb.Event {
+	var out mb.Event
+	if ms.config.EventFormat == EventFormatOsquery {
+		out = buildOsqueryEvent(e, existedBefore, osqueryCategory(ms.config.Paths, e.Path))
+	} else {
+		out = buildMetricbeatEvent(e, existedBefore)
+	}
+	for field, value := range e.Derived {
+		out.MetricSetFields.Put(field, value)
+	}
+	ms.config.RedactFields.apply(out.MetricSetFields)
+	return out
+}
+
 func (ms *MetricSet) purgeDeleted(reporter mb.PushReporterV2) {
-	for _, prefix := range ms.config.Paths {
+	manifest := ms.config.DeletionManifest
+	var deletedPaths []string
+	prefixes := ms.config.Paths
+	for _, image := range ms.config.SquashfsImages {
+		prefixes = append(prefixes[:len(prefixes):len(prefixes)], image+squashfsPathSeparator)
+	}
+	for _, prefix := range prefixes {
 		deleted, err := ms.purgeOlder(ms.scanStart, prefix)
 		if err != nil {
 			ms.log.Errorw("Failure while purging older records", "error", err)
@@ -220,12 +316,72 @@ func (ms *MetricSet) purgeDeleted(reporter mb.PushReporterV2) {
 		}
 
 		for _, e := range deleted {
-			// Don't persist!
-			if !ms.config.IsExcludedPath(e.Path) {
-				reporter.Event(buildMetricbeatEvent(e, true))
+			// Partial hashes of deleted and excluded files are never resumed.
+			if ms.partial != nil {
+				if err = ms.partial.Delete(e.Path); err != nil {
+					ms.log.Debugw("Failed to delete partial hash", "file_path", e.Path, "error", err)
+				}
 			}
+			if ms.config.IsExcludedPath(e.Path) {
+				continue
+			}
+			if manifest.Enabled {
+				deletedPaths = append(deletedPaths, e.Path)
+				if manifest.ReplaceEvents {
+					continue
+				}
+			}
+			// Don't persist!
+			reporter.Event(ms.buildEvent(e, true))
 		}
 	}
+
+	if manifest.Enabled {
+		event := buildDeletionManifest(deletedPaths, ms.scanStart)
+		ms.config.RedactFields.apply(event.MetricSetFields)
+		reporter.Event(event)
+	}
+}
+
+// publishScanSummary publishes the summary of the completed scan. The
+// comparison of the Merkle root is included unless it is nil.
+func (ms *MetricSet) publishScanSummary(reporter mb.PushReporterV2, merkle *merkleSummary) {
+	s, ok := ms.scanner.(*scanner)
+	if !ok || s.summary == nil {
+		return
+	}
+	event := buildScanSummaryEvent(s.summary, merkle)
+	ms.config.RedactFields.apply(event.MetricSetFields)
+	reporter.Event(event)
+}
+
+// notSampled returns true if the stored event describes a regular file that
+// is not part of the sample, meaning it was not scanned and its absence from
+// the last scan does not indicate that it was deleted.
+func (ms *MetricSet) notSampled(path string, data []byte) bool {
+	if ms.config.Sampling.selected(path) {
+		return false
+	}
+	stored := fbDecodeEvent(path, data)
+	return stored.Info != nil && stored.Info.Type == FileType
+}
+
+// notReported returns true if path still exists but the scanner does not
+// report it because its permissions do not match RequirePermissions or it is
+// dormant, meaning its absence from the last scan does not indicate that it
+// was deleted.
+func (ms *MetricSet) notReported(path string) bool {
+	if ms.config.RequirePermissions == 0 && ms.config.ExcludeAtimeOlderThan <= 0 {
+		return false
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	if ms.config.RequirePermissions != 0 && !ms.config.RequirePermissions.Matches(info.Mode()) {
+		return true
+	}
+	return ms.config.isDormant(info)
 }
 
 // Datastore utility functions.
@@ -253,6 +409,11 @@ func (ms *MetricSet) purgeOlder(t time.Time, prefix string) ([]*Event, error) {
 			totalKeys++
 
 			if fbIsEventTimestampBefore(v, t) {
+				// Keep the state of files outside of the sample and of
+				// files that the scanner does not report.
+				if ms.notSampled(string(path), v) || ms.notReported(string(path)) {
+					continue
+				}
 				if err := c.Delete(); err != nil {
 					return err
 				}
@@ -290,6 +451,59 @@ func store(b datastore.Bucket, e *Event) error {
 	return nil
 }
 
+// bucketStateStore is a StateStore and PartialHashStore backed by the
+// metricset's datastore.
+type bucketStateStore struct {
+	bucket  datastore.Bucket
+	partial datastore.Bucket // Nil when ResumableHashing is disabled.
+}
+
+func (s bucketStateStore) Load(path string) (*Event, error) {
+	return load(s.bucket, path)
+}
+
+func (s bucketStateStore) Store(event *Event) error {
+	return store(s.bucket, event)
+}
+
+func (s bucketStateStore) LoadPartialHash(path string) (*PartialHash, error) {
+	if s.partial == nil {
+		return nil, nil
+	}
+
+	var p *PartialHash
+	err := s.partial.Load(path, func(blob []byte) error {
+		p = &PartialHash{}
+		return json.Unmarshal(blob, p)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load partial hash for %v", path)
+	}
+	return p, nil
+}
+
+func (s bucketStateStore) StorePartialHash(path string, p *PartialHash) error {
+	if s.partial == nil {
+		return errors.New("partial hash store is not open")
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode partial hash for %v", path)
+	}
+	if err = s.partial.Store(path, data); err != nil {
+		return errors.Wrapf(err, "failed to store partial hash for %v", path)
+	}
+	return nil
+}
+
+func (s bucketStateStore) DeletePartialHash(path string) error {
+	if s.partial == nil {
+		return nil
+	}
+	return s.partial.Delete(path)
+}
+
 // load loads an Event from the datastore. It return a nil Event if the key was
 // not found. It returns an error if there was a failure reading from the
 // datastore or decoding the data.
@@ -1,9 +1,14 @@
 package file_integrity
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -11,7 +16,9 @@ import (
 
 	"github.com/elastic/beats/auditbeat/core"
 	"github.com/elastic/beats/auditbeat/datastore"
+	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/paths"
+	"github.com/elastic/beats/metricbeat/mb"
 	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
 )
 
@@ -105,6 +112,493 @@ func TestDetectDeletedFiles(t *testing.T) {
 	}
 }
 
+func TestPurgeDeletedPartialHashes(t *testing.T) {
+	defer setup(t)()
+
+	bucket, err := datastore.OpenBucket(bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	partial, err := datastore.OpenBucket(partialHashBucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer partial.Close()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file whose hashing was interrupted before it was deleted.
+	ghost := filepath.Join(dir, "ghost.file")
+	if err = store(bucket, &Event{Timestamp: time.Now().UTC(), Path: ghost, Action: Created}); err != nil {
+		t.Fatal(err)
+	}
+	state := bucketStateStore{bucket, partial}
+	if err = state.StorePartialHash(ghost, &PartialHash{Offset: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := getConfig(dir)
+	config["resumable_hashing"] = true
+	ms := mbtest.NewPushMetricSetV2(t, config)
+	events := mbtest.RunPushMetricSetV2(10*time.Second, 2, ms)
+	for _, e := range events {
+		if e.Error != nil {
+			t.Fatalf("received error: %+v", e.Error)
+		}
+	}
+	assert.Len(t, events, 2)
+
+	p, err := state.LoadPartialHash(ghost)
+	if assert.NoError(t, err) {
+		assert.Nil(t, p, "partial hash of the deleted file was not purged")
+	}
+}
+
+func TestDeletionManifest(t *testing.T) {
+	defer setup(t)()
+
+	bucket, err := datastore.OpenBucket(bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Persist the state of files that have been removed since, including one
+	// that is now excluded.
+	var removed []string
+	for _, name := range []string{"c.file", "a.file", "b.file", "d.swp"} {
+		e := &Event{
+			Timestamp: time.Now().UTC(),
+			Path:      filepath.Join(dir, name),
+			Action:    Created,
+		}
+		if err = store(bucket, e); err != nil {
+			t.Fatal(err)
+		}
+		if name != "d.swp" {
+			removed = append(removed, e.Path)
+		}
+	}
+
+	config := getConfig(dir)
+	config["deletion_manifest"] = map[string]interface{}{
+		"enabled":        true,
+		"replace_events": true,
+	}
+	ms := mbtest.NewPushMetricSetV2(t, config)
+	events := mbtest.RunPushMetricSetV2(10*time.Second, 2, ms)
+	for _, e := range events {
+		if e.Error != nil {
+			t.Fatalf("received error: %+v", e.Error)
+		}
+	}
+
+	if !assert.Len(t, events, 2) {
+		return
+	}
+	path, err := events[0].MetricSetFields.GetValue("file.path")
+	if assert.NoError(t, err) {
+		assert.Equal(t, dir, path)
+	}
+
+	manifest := events[1].MetricSetFields
+	paths, err := manifest.GetValue("deletion_manifest.paths")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{removed[1], removed[2], removed[0]}, paths)
+	}
+	count, err := manifest.GetValue("deletion_manifest.count")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, count)
+	}
+}
+
+func TestPublishScanSummary(t *testing.T) {
+	defer setup(t)()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.file"), []byte("file a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	config := getConfig(dir)
+	config["paths"] = []string{dir, missing}
+	config["publish_scan_summary"] = true
+	config["include_provenance"] = true
+	config["summary_top_n"] = 1
+	events := runMetricSet(t, config, 3)
+	if !assert.Len(t, events, 3) {
+		return
+	}
+
+	summary, err := events[2].MetricSetFields.GetValue("scan_summary")
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields := summary.(common.MapStr)
+	assert.Equal(t, false, fields["complete"])
+	assert.Equal(t, []rootStatus{
+		{Path: dir, Status: RootComplete},
+		{Path: missing, Status: RootPartialError},
+	}, fields["root_status"])
+	assert.EqualValues(t, 2, fields["file_count"], "the directory and the file")
+	assert.Equal(t, []topNEntry{{Path: filepath.Join(dir, "a.file"), Value: 6}}, fields["largest_files"])
+	if p, ok := fields["provenance"].(provenance); assert.True(t, ok) {
+		assert.Equal(t, []HashType{SHA1}, p.HashTypes)
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	defer setup(t)()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.file", "b.file"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := getConfig(dir)
+	config["merkle_root"] = true
+	config["publish_scan_summary"] = true
+
+	// scan runs the metricset and returns it and the merkle fields of its
+	// scan summary, which is the last event.
+	scan := func(waitEvents int) (*MetricSet, common.MapStr) {
+		ms := mbtest.NewPushMetricSetV2(t, config)
+		events := mbtest.RunPushMetricSetV2(10*time.Second, waitEvents, ms)
+		if !assert.Len(t, events, waitEvents) {
+			t.FailNow()
+		}
+		merkle, err := events[waitEvents-1].MetricSetFields.GetValue("scan_summary.merkle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ms.(*MetricSet), merkle.(common.MapStr)
+	}
+
+	_, first := scan(4)
+	assert.Equal(t, 2, first["leaf_count"])
+	assert.Equal(t, false, first["changed"])
+	assert.NotContains(t, first, "previous_root")
+	root := first["root"].(Digest)
+
+	// Only the summary is published when nothing changed.
+	_, unchanged := scan(1)
+	assert.Equal(t, root, unchanged["root"])
+	assert.Equal(t, root, unchanged["previous_root"])
+	assert.Equal(t, false, unchanged["changed"])
+
+	changedFile := filepath.Join(dir, "a.file")
+	if err = ioutil.WriteFile(changedFile, []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ms, changed := scan(2)
+	assert.Equal(t, root, changed["previous_root"])
+	assert.NotEqual(t, root, changed["root"])
+	assert.Equal(t, true, changed["changed"])
+
+	// The proof of the changed file verifies against the persisted root but
+	// not against the root of the first scan.
+	bucket, err := datastore.OpenBucket(merkleBucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	stored, err := loadMerkleRoot(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, changed["root"], stored.Root)
+
+	proof, err := ms.MerkleProof(changedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha1.Sum([]byte("changed"))
+	assert.Equal(t, Digest(digest[:]), proof.Digest)
+	assert.True(t, VerifyMerkleProof(stored.Root, proof))
+	assert.False(t, VerifyMerkleProof(root, proof))
+
+	_, err = ms.MerkleProof(filepath.Join(dir, "missing.file"))
+	assert.Error(t, err)
+}
+
+func TestSamplingDoesNotDetectDeletions(t *testing.T) {
+	defer setup(t)()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files outside of the sample and sampled files that are deleted, whose
+	// names sort after the others.
+	sampling := SamplingConfig{Seed: 1, Directories: []SampleDirectory{{Path: dir, Rate: 0.5}}}
+	var unsampled, deleted []string
+	for i := 0; len(unsampled) < 5; i++ {
+		if path := filepath.Join(dir, fmt.Sprintf("file-%02d", i)); !sampling.selected(path) {
+			unsampled = append(unsampled, path)
+		}
+	}
+	for i := 0; len(deleted) < 2; i++ {
+		if path := filepath.Join(dir, fmt.Sprintf("zz-%02d", i)); sampling.selected(path) {
+			deleted = append(deleted, path)
+		}
+	}
+	files := append(append([]string(nil), unsampled...), deleted...)
+	for _, path := range files {
+		if err = ioutil.WriteFile(path, []byte(path), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Store the state of all files.
+	assert.Len(t, runMetricSet(t, getConfig(dir), len(files)+1), len(files)+1)
+
+	// Each sampled scan reports the deletion of a sampled file but not the
+	// files outside of the sample, which it does not scan.
+	config := getConfig(dir)
+	config["sampling"] = map[string]interface{}{
+		"seed":        sampling.Seed,
+		"directories": []map[string]interface{}{{"path": dir, "rate": 0.5}},
+	}
+	for _, path := range deleted {
+		if err = os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+
+		events := runMetricSet(t, config, 1)
+		if !assert.Len(t, events, 1) {
+			continue
+		}
+		fields := events[0].MetricSetFields
+		p, err := fields.GetValue("file.path")
+		if assert.NoError(t, err) {
+			assert.Equal(t, path, p, "unexpected event for a file outside of the sample")
+		}
+		action, err := fields.GetValue("event.action")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"deleted"}, action)
+		}
+	}
+}
+
+func TestRequirePermissionsDoesNotDetectDeletions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("require_permissions is not supported on Windows")
+	}
+	defer setup(t)()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setuid := filepath.Join(dir, "setuid")
+	files := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), setuid}
+	for _, path := range files {
+		if err = ioutil.WriteFile(path, []byte(path), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Chmod(setuid, 0600|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+
+	// Store the state of all files.
+	assert.Len(t, runMetricSet(t, getConfig(dir), len(files)+1), len(files)+1)
+
+	// The scan reports the deletion of the setuid file but not the files
+	// whose permissions do not match, which it does not report.
+	if err = os.Remove(setuid); err != nil {
+		t.Fatal(err)
+	}
+	config := getConfig(dir)
+	config["require_permissions"] = "4000"
+	events := runMetricSet(t, config, 1)
+	if assert.Len(t, events, 1) {
+		fields := events[0].MetricSetFields
+		p, err := fields.GetValue("file.path")
+		if assert.NoError(t, err) {
+			assert.Equal(t, setuid, p, "unexpected event for a file that does not match")
+		}
+		action, err := fields.GetValue("event.action")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"deleted"}, action)
+		}
+	}
+
+	// The state of the other files was kept.
+	assert.Empty(t, runMetricSet(t, getConfig(dir), 1))
+}
+
+func TestDormantFilesDoNotDetectDeletions(t *testing.T) {
+	defer setup(t)()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dormant, deleted := filepath.Join(dir, "dormant"), filepath.Join(dir, "deleted")
+	files := []string{dormant, deleted}
+	for _, path := range files {
+		if err = ioutil.WriteFile(path, []byte(path), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Store the state of all files.
+	assert.Len(t, runMetricSet(t, getConfig(dir), len(files)+1), len(files)+1)
+
+	// The scan reports the deletion of the active file but not the dormant
+	// file, which it skips.
+	now := time.Now()
+	if err = os.Chtimes(dormant, now.Add(-time.Hour), now); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+	config := getConfig(dir)
+	config["exclude_atime_older_than"] = "1m"
+	events := runMetricSet(t, config, 1)
+	if assert.Len(t, events, 1) {
+		fields := events[0].MetricSetFields
+		p, err := fields.GetValue("file.path")
+		if assert.NoError(t, err) {
+			assert.Equal(t, deleted, p, "unexpected event for a dormant file")
+		}
+		action, err := fields.GetValue("event.action")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"deleted"}, action)
+		}
+	}
+
+	// The state of the dormant file was kept.
+	bucket, err := datastore.OpenBucket(bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+	stored, err := load(bucket, dormant)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, stored)
+	}
+}
+
+func TestSuppressHashes(t *testing.T) {
+	defer setup(t)()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"a.conf": "placeholder", "b.conf": "hello"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum := sha256.Sum256([]byte("placeholder"))
+	config := getConfig(dir)
+	config["hash_types"] = []string{"sha256"}
+	config["suppress_hashes"] = []string{hex.EncodeToString(sum[:])}
+	ms := mbtest.NewPushMetricSetV2(t, config)
+	events := mbtest.RunPushMetricSetV2(10*time.Second, 2, ms)
+	for _, e := range events {
+		if e.Error != nil {
+			t.Fatalf("received error: %+v", e.Error)
+		}
+	}
+
+	var paths []string
+	for _, e := range events {
+		path, err := e.MetricSetFields.GetValue("file.path")
+		if assert.NoError(t, err) {
+			paths = append(paths, path.(string))
+		}
+	}
+	assert.Equal(t, []string{dir, filepath.Join(dir, "b.conf")}, paths)
+
+	// The state of the suppressed file is persisted.
+	bucket, err := datastore.OpenBucket(bucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+
+	e, err := load(bucket, filepath.Join(dir, "a.conf"))
+	if assert.NoError(t, err) {
+		assert.NotNil(t, e)
+	}
+}
+
 func TestExcludedFiles(t *testing.T) {
 	defer setup(t)()
 
@@ -169,6 +663,19 @@ func setup(t testing.TB) func() {
 	return func() { os.RemoveAll(paths.Paths.Data) }
 }
 
+// runMetricSet runs the metricset once and returns its events. It fails the
+// test if any of the events is an error.
+func runMetricSet(t *testing.T, config map[string]interface{}, waitEvents int) []mb.Event {
+	ms := mbtest.NewPushMetricSetV2(t, config)
+	events := mbtest.RunPushMetricSetV2(10*time.Second, waitEvents, ms)
+	for _, e := range events {
+		if e.Error != nil {
+			t.Fatalf("received error: %+v", e.Error)
+		}
+	}
+	return events
+}
+
 func getConfig(path string) map[string]interface{} {
 	return map[string]interface{}{
 		"module":        "file_integrity",
@@ -0,0 +1,27 @@
+package file_integrity
+
+import "os"
+
+// Modes in which the scanner opens files to read their contents.
+const (
+	// OpenModeDefault opens files like the rest of Beats does.
+	OpenModeDefault = "default"
+
+	// OpenModeBackup opens files for backup on Windows. Only the access rights
+	// needed to read the data are requested and the SeBackupPrivilege is used
+	// if the process holds it, so that ACLs do not prevent reading the files.
+	// This minimizes the interference with antivirus software. It is the same
+	// as OpenModeDefault on other platforms.
+	OpenModeBackup = "backup"
+)
+
+// opener opens a file for reading its contents.
+type opener func(name string) (*os.File, error)
+
+// openFile opens the file for hashing in the configured OpenMode.
+func (s *scanner) openFile(name string) (*os.File, error) {
+	if s.config.OpenMode == OpenModeBackup {
+		return openForBackup(name)
+	}
+	return openForHashing(name)
+}
@@ -0,0 +1,79 @@
+// +build windows
+
+package file_integrity
+
+import (
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/elastic/gosigar/sys/windows"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const (
+	fileReadData       = 0x0001
+	fileReadAttributes = 0x0080
+	synchronize        = 0x00100000
+
+	fileFlagSequentialScan = 0x08000000
+
+	seBackupPrivilege = "SeBackupPrivilege"
+)
+
+var enableBackupPrivilegeOnce sync.Once
+
+// enableBackupPrivilege enables the SeBackupPrivilege if it is present in the
+// process's token. With it files opened with FILE_FLAG_BACKUP_SEMANTICS can be
+// read regardless of their ACLs.
+func enableBackupPrivilege() error {
+	self, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+
+	var token syscall.Token
+	err = syscall.OpenProcessToken(self, syscall.TOKEN_QUERY|syscall.TOKEN_ADJUST_PRIVILEGES, &token)
+	if err != nil {
+		return err
+	}
+	defer token.Close()
+
+	if err = windows.EnableTokenPrivileges(token, seBackupPrivilege); err != nil {
+		return errors.Wrap(err, "EnableTokenPrivileges failed")
+	}
+	return nil
+}
+
+// openForBackup opens the file with FILE_FLAG_BACKUP_SEMANTICS requesting only
+// the rights to read its data and attributes. All share modes are allowed so
+// that files that other processes have open can be read.
+func openForBackup(name string) (*os.File, error) {
+	enableBackupPrivilegeOnce.Do(func() {
+		if err := enableBackupPrivilege(); err != nil {
+			logp.NewLogger(moduleName).Debugw("Failed to enable SeBackupPrivilege", "error", err)
+		}
+	})
+
+	if len(name) == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ERROR_FILE_NOT_FOUND}
+	}
+	pathp, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	handle, err := syscall.CreateFile(pathp,
+		fileReadData|fileReadAttributes|synchronize,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil,
+		syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_BACKUP_SEMANTICS|fileFlagSequentialScan,
+		0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return os.NewFile(uintptr(handle), name), nil
+}
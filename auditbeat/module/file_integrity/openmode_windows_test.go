@@ -0,0 +1,81 @@
+// +build windows
+
+package file_integrity
+
+import (
+	"crypto/sha1"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerOpenModeBackup(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the file open for writing and only allow others to read it, the
+	// way databases and logs are commonly held open.
+	locked := filepath.Join(dir, "a")
+	pathp, err := syscall.UTF16PtrFromString(locked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, err := syscall.CreateFile(pathp, syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.CloseHandle(handle)
+
+	// Both modes share files with all other handles.
+	sum := sha1.Sum([]byte("file a"))
+	for _, mode := range []string{OpenModeDefault, OpenModeBackup} {
+		config := defaultConfig
+		config.OpenMode = mode
+		e := scanEvents(t, config, dir)[locked]
+
+		assert.Empty(t, e.errors, mode)
+		assert.Equal(t, Digest(sum[:]), e.Hashes[SHA1], mode)
+	}
+}
+
+func TestScannerOpenModeBackupACL(t *testing.T) {
+	if err := enableBackupPrivilege(); err != nil {
+		t.Skip("SeBackupPrivilege is not available:", err)
+	}
+
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deny everyone, including the owner, reading the data of the file.
+	denied := filepath.Join(dir, "a")
+	if out, err := exec.Command("icacls", denied, "/deny", "*S-1-1-0:(RD)").CombinedOutput(); err != nil {
+		t.Fatalf("icacls failed: %v: %s", err, out)
+	}
+	defer exec.Command("icacls", denied, "/reset").Run()
+
+	config := defaultConfig
+	config.OpenMode = OpenModeDefault
+	e := scanEvents(t, config, dir)[denied]
+	assert.NotEmpty(t, e.errors, "default mode is subject to the ACL")
+	assert.Empty(t, e.Hashes)
+
+	config.OpenMode = OpenModeBackup
+	e = scanEvents(t, config, dir)[denied]
+	sum := sha1.Sum([]byte("file a"))
+	assert.Equal(t, Digest(sum[:]), e.Hashes[SHA1], "backup mode bypasses the ACL")
+}
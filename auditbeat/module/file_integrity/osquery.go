@@ -0,0 +1,104 @@
+package file_integrity
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// Event formats.
+const (
+	EventFormatDefault = "default" // Fields described in fields.yml.
+	EventFormatOsquery = "osquery" // Columns of osquery's file_events table.
+)
+
+var osqueryActionNames = map[Action]string{
+	AttributesModified: "ATTRIBUTES_MODIFIED",
+	Created:            "CREATED",
+	Deleted:            "DELETED",
+	Updated:            "UPDATED",
+	Moved:              "MOVED_FROM",
+	ConfigChange:       "UPDATED",
+}
+
+// buildOsqueryEvent builds an event whose file_events field has the column
+// layout of osquery's file_events table. The category is the configured path
+// that the file was found under.
+func buildOsqueryEvent(e *Event, existedBefore bool, category string) mb.Event {
+	row := common.MapStr{
+		"target_path":    e.Path,
+		"category":       category,
+		"action":         "",
+		"transaction_id": 0,
+		"md5":            e.Hashes[MD5].String(),
+		"sha1":           e.Hashes[SHA1].String(),
+		"sha256":         e.Hashes[SHA256].String(),
+		"hashed":         osqueryHashed(e),
+		"time":           e.Timestamp.Unix(),
+	}
+
+	if e.Action > 0 {
+		// A file_events row has a single action so the last one, which
+		// describes the current state of the file, is reported.
+		actions := e.Action.InOrder(existedBefore, e.Info != nil)
+		if len(actions) > 0 {
+			row["action"] = osqueryActionNames[actions[len(actions)-1]]
+		}
+	}
+
+	if info := e.Info; info != nil {
+		mode := uint32(info.Mode.Perm())
+		if info.SetUID {
+			mode |= 04000
+		}
+		if info.SetGID {
+			mode |= 02000
+		}
+		row["inode"] = info.Inode
+		row["uid"] = info.UID
+		row["gid"] = info.GID
+		row["mode"] = fmt.Sprintf("%04o", mode)
+		row["size"] = info.Size
+		row["mtime"] = info.MTime.Unix()
+		row["ctime"] = info.CTime.Unix()
+	}
+
+	return mb.Event{
+		Timestamp: e.Timestamp,
+		Took:      e.rtt,
+		MetricSetFields: common.MapStr{
+			"file_events": row,
+		},
+	}
+}
+
+// osqueryHashed returns 1 if the file was hashed, -1 if hashing failed, and 0
+// if the file was not hashed.
+func osqueryHashed(e *Event) int {
+	switch {
+	case len(e.Hashes) > 0:
+		return 1
+	case e.Info != nil && e.Info.Type == FileType && len(e.errors) > 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// osqueryCategory returns the longest of the configured paths that contains
+// path.
+func osqueryCategory(paths []string, path string) string {
+	var category string
+	for _, root := range paths {
+		if len(root) <= len(category) {
+			continue
+		}
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			category = root
+		}
+	}
+	return category
+}
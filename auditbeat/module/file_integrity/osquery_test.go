@@ -0,0 +1,77 @@
+package file_integrity
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestBuildOsqueryEvent(t *testing.T) {
+	mtime := time.Unix(1500000000, 0).UTC()
+	sha1, _ := hex.DecodeString("44f2e1d0cc0d0e0a9a9fb2f9fa1e73fb1e1e2cce")
+	e := &Event{
+		Timestamp: time.Unix(1500000100, 0).UTC(),
+		Path:      "/etc/passwd",
+		Action:    Updated,
+		Info: &Metadata{
+			Inode:  42,
+			UID:    0,
+			GID:    10,
+			Size:   1024,
+			MTime:  mtime,
+			CTime:  mtime.Add(time.Second),
+			Type:   FileType,
+			Mode:   0644,
+			SetUID: true,
+		},
+		Hashes: map[HashType]Digest{SHA1: sha1},
+	}
+
+	fields := buildOsqueryEvent(e, true, "/etc").MetricSetFields
+	row, err := fields.GetValue("file_events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, common.MapStr{
+		"target_path":    "/etc/passwd",
+		"category":       "/etc",
+		"action":         "UPDATED",
+		"transaction_id": 0,
+		"inode":          uint64(42),
+		"uid":            uint32(0),
+		"gid":            uint32(10),
+		"mode":           "4644",
+		"size":           uint64(1024),
+		"mtime":          int64(1500000000),
+		"ctime":          int64(1500000001),
+		"md5":            "",
+		"sha1":           "44f2e1d0cc0d0e0a9a9fb2f9fa1e73fb1e1e2cce",
+		"sha256":         "",
+		"hashed":         1,
+		"time":           int64(1500000100),
+	}, row)
+
+	// Deleted files have no metadata and are not hashed.
+	e = &Event{Timestamp: e.Timestamp, Path: "/etc/shadow", Action: Deleted}
+	row, err = buildOsqueryEvent(e, true, "/etc").MetricSetFields.GetValue("file_events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "DELETED", row.(common.MapStr)["action"])
+	assert.Equal(t, 0, row.(common.MapStr)["hashed"])
+	assert.NotContains(t, row, "inode")
+}
+
+func TestOsqueryCategory(t *testing.T) {
+	paths := []string{"/", "/etc", "/etc/ssh", "/usr"}
+	assert.Equal(t, "/etc/ssh", osqueryCategory(paths, "/etc/ssh/sshd_config"))
+	assert.Equal(t, "/etc", osqueryCategory(paths, "/etc/passwd"))
+	assert.Equal(t, "/etc", osqueryCategory(paths, "/etc"))
+	assert.Equal(t, "/", osqueryCategory(paths, "/etcetera"))
+	assert.Equal(t, "", osqueryCategory([]string{"/usr"}, "/opt/x"))
+}
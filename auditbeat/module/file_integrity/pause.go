@@ -0,0 +1,81 @@
+package file_integrity
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Pausable is implemented by event producers whose work can be paused.
+type Pausable interface {
+	// Pause suspends the producer until Resume is called. It has no effect
+	// if the producer is already paused.
+	Pause()
+
+	// Resume continues a paused producer. It has no effect if the producer
+	// is not paused.
+	Resume()
+}
+
+// signalControlEnabled is set while a scanner handles the pause and resume
+// signals. Only one scanner per process can handle them.
+var signalControlEnabled int32
+
+var errSignalControlInUse = errors.New("signal control is already enabled by another scanner")
+
+// Pause suspends the scan before the next path is processed.
+func (s *scanner) Pause() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.resumeC == nil {
+		s.log.Info("File system scanner is pausing")
+		s.resumeC = make(chan struct{})
+	}
+}
+
+// Resume continues a paused scan.
+func (s *scanner) Resume() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.resumeC != nil {
+		s.log.Info("File system scanner is resuming")
+		close(s.resumeC)
+		s.resumeC = nil
+	}
+}
+
+// waitIfPaused blocks while the scanner is paused. It returns errDone if the
+// scanner is stopped while paused.
+func (s *scanner) waitIfPaused() error {
+	s.pauseMu.Lock()
+	resumeC := s.resumeC
+	s.pauseMu.Unlock()
+	if resumeC == nil {
+		return nil
+	}
+
+	select {
+	case <-resumeC:
+		return nil
+	case <-s.done:
+		return errDone
+	}
+}
+
+// enableSignalControl makes the scanner pause on SIGUSR1 and resume on
+// SIGUSR2. The returned function stops handling the signals.
+func (s *scanner) enableSignalControl() (func(), error) {
+	if !atomic.CompareAndSwapInt32(&signalControlEnabled, 0, 1) {
+		return nil, errSignalControlInUse
+	}
+
+	stop, err := notifyPauseSignals(s)
+	if err != nil {
+		atomic.StoreInt32(&signalControlEnabled, 0)
+		return nil, err
+	}
+	return func() {
+		stop()
+		atomic.StoreInt32(&signalControlEnabled, 0)
+	}, nil
+}
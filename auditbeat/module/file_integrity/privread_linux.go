@@ -0,0 +1,98 @@
+// +build linux
+
+package file_integrity
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// capDacReadSearch is CAP_DAC_READ_SEARCH (from linux/capability.h). It
+	// bypasses file read permission checks.
+	capDacReadSearch = 2
+
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32 // 0 is the calling thread.
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+func capget(hdr *capHeader, data *[2]capData) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET,
+		uintptr(unsafe.Pointer(hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func capset(hdr *capHeader, data *[2]capData) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET,
+		uintptr(unsafe.Pointer(hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// withReadCapability runs f on an OS thread that has CAP_DAC_READ_SEARCH in
+// its effective capability set. Capabilities are per thread so the capability
+// is only raised while f runs and only for the thread running f. Files must be
+// opened by f itself. It returns errNoReadCapability if the process is not
+// permitted to raise the capability.
+func withReadCapability(f func()) error {
+	errC := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		hdr := capHeader{version: linuxCapabilityVersion3}
+		var data [2]capData
+		if err := capget(&hdr, &data); err != nil {
+			runtime.UnlockOSThread()
+			errC <- err
+			return
+		}
+
+		bit := uint32(1) << capDacReadSearch
+		if data[0].permitted&bit == 0 {
+			runtime.UnlockOSThread()
+			errC <- errNoReadCapability
+			return
+		}
+
+		raised := data[0].effective&bit == 0
+		if raised {
+			data[0].effective |= bit
+			if err := capset(&hdr, &data); err != nil {
+				runtime.UnlockOSThread()
+				errC <- err
+				return
+			}
+		}
+
+		f()
+
+		if raised {
+			data[0].effective &^= bit
+			if err := capset(&hdr, &data); err != nil {
+				// Keep the thread locked so that no other goroutine runs with
+				// the capability. The thread exits with the goroutine.
+				errC <- nil
+				return
+			}
+		}
+		runtime.UnlockOSThread()
+		errC <- nil
+	}()
+	return <-errC
+}
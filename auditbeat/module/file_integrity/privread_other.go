@@ -0,0 +1,9 @@
+// +build !linux
+
+package file_integrity
+
+// withReadCapability is not supported on this platform and always returns
+// errNoReadCapability without running f.
+func withReadCapability(f func()) error {
+	return errNoReadCapability
+}
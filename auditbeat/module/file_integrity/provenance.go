@@ -0,0 +1,49 @@
+package file_integrity
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/elastic/beats/libbeat/version"
+)
+
+// Sources of the provenance information. They are variables so that tests can
+// replace them.
+var (
+	beatVersion   = version.GetDefaultVersion
+	beatCommit    = version.Commit
+	beatBuildTime = version.BuildTime
+	hostname      = os.Hostname
+)
+
+// provenance describes the scanner build, the host, and the hash algorithms
+// of a scan so that a recorded baseline is self-describing.
+type provenance struct {
+	Version      string     `json:"version"`
+	Commit       string     `json:"commit"`
+	BuildTime    time.Time  `json:"build_time"`
+	Hostname     string     `json:"hostname"`
+	OS           string     `json:"os"`
+	Arch         string     `json:"arch"`
+	HashTypes    []HashType `json:"hash_types"`
+	CombinedHash HashType   `json:"combined_hash,omitempty"`
+}
+
+// provenance returns the provenance of the scan.
+func (s *scanner) provenance() provenance {
+	host, err := hostname()
+	if err != nil {
+		s.log.Debugw("Failed to get hostname", "error", err)
+	}
+	return provenance{
+		Version:      beatVersion(),
+		Commit:       beatCommit(),
+		BuildTime:    beatBuildTime(),
+		Hostname:     host,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		HashTypes:    s.config.HashTypes,
+		CombinedHash: s.config.CombinedHash,
+	}
+}
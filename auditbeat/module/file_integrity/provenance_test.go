@@ -0,0 +1,60 @@
+package file_integrity
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+func TestScannerIncludeProvenance(t *testing.T) {
+	built := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	defer func(v, c func() string, b func() time.Time, h func() (string, error)) {
+		beatVersion, beatCommit, beatBuildTime, hostname = v, c, b, h
+	}(beatVersion, beatCommit, beatBuildTime, hostname)
+	beatVersion = func() string { return "9.9.9" }
+	beatCommit = func() string { return "0123abcd" }
+	beatBuildTime = func() time.Time { return built }
+	hostname = func() (string, error) { return "fim-host", nil }
+
+	if err := logp.DevelopmentSetup(logp.ToObserverOutput()); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	// summary scans dir and returns the fields of the summary log message.
+	summary := func(config Config) map[string]interface{} {
+		logp.ObserverLogs().TakeAll()
+		scanEvents(t, config, dir)
+		logs := logp.ObserverLogs().FilterMessage("File system scan completed").All()
+		if len(logs) != 1 {
+			t.Fatalf("expected one summary but got %d", len(logs))
+		}
+		return logs[0].ContextMap()
+	}
+
+	config := defaultConfig
+	config.HashTypes = []HashType{SHA1, SHA256}
+	config.CombinedHash = SHA256
+	config.IncludeProvenance = true
+	assert.Equal(t, provenance{
+		Version:      "9.9.9",
+		Commit:       "0123abcd",
+		BuildTime:    built,
+		Hostname:     "fim-host",
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		HashTypes:    []HashType{SHA1, SHA256},
+		CombinedHash: SHA256,
+	}, summary(config)["provenance"])
+
+	// Provenance is omitted by default.
+	config.IncludeProvenance = false
+	assert.NotContains(t, summary(config), "provenance")
+}
@@ -0,0 +1,124 @@
+// +build linux
+
+package file_integrity
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Magic numbers of pseudo filesystems (from linux/magic.h). Files on these
+// filesystems generally report a size of 0 but can block or return an
+// unbounded amount of data when read (e.g. /proc/kcore).
+var pseudoFilesystemMagics = map[uint32]string{
+	0x9fa0:     "proc",
+	0x62656572: "sysfs",
+	0x64626720: "debugfs",
+	0x74726163: "tracefs",
+	0x73636673: "securityfs",
+	0x27e0eb:   "cgroup",
+	0x63677270: "cgroup2",
+	0x6e736673: "nsfs",
+	0xcafe4a11: "bpf",
+	0x42494e4d: "binfmt_misc",
+	0x1cd1:     "devpts",
+}
+
+// statfsType returns the filesystem type (magic number) for the path. It is a
+// variable so that it can be replaced in tests. The type is signed on some
+// 32-bit architectures so it is converted to uint32 to compare it with the
+// magic numbers without sign extension.
+var statfsType = func(path string) (uint32, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint32(st.Type), nil
+}
+
+// stNoatime is the ST_NOATIME statfs flag (from linux/statfs.h).
+const stNoatime = 0x400
+
+// statfsFlags returns the mount flags of the filesystem that path resides on.
+// It is a variable so that it can be replaced in tests.
+var statfsFlags = func(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Flags), nil
+}
+
+// noatimeMount returns true if the filesystem that path resides on is mounted
+// with noatime, meaning access times are not updated.
+func noatimeMount(path string) (bool, error) {
+	flags, err := statfsFlags(path)
+	if err != nil {
+		return false, err
+	}
+	return flags&stNoatime != 0, nil
+}
+
+// pseudoFilesystem returns the name of the pseudo filesystem that path
+// resides on, or an empty string if path is not on a pseudo filesystem.
+func pseudoFilesystem(path string) (string, error) {
+	magic, err := statfsType(path)
+	if err != nil {
+		return "", err
+	}
+	return pseudoFilesystemMagics[magic], nil
+}
+
+// mountsFile lists the mounted filesystems. It is a variable so that it can be
+// replaced in tests.
+var mountsFile = "/proc/self/mounts"
+
+// autofsMountpoints returns the mountpoints of autofs filesystems. Accessing
+// the contents of these directories triggers a mount.
+func autofsMountpoints() (map[string]struct{}, error) {
+	f, err := os.Open(mountsFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read mounts")
+	}
+	defer f.Close()
+
+	mountpoints := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: device mountpoint fstype options dump pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != "autofs" {
+			continue
+		}
+		mountpoints[unescapeMountpoint(fields[1])] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read mounts")
+	}
+	return mountpoints, nil
+}
+
+// unescapeMountpoint decodes the octal escapes (e.g. \040 for a space) used
+// for whitespace and backslashes in mount paths.
+func unescapeMountpoint(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				buf = append(buf, byte(c))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
@@ -0,0 +1,143 @@
+// +build linux
+
+package file_integrity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerPseudoFilesystem(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "subdir", "d"), []byte("file d"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend that subdir is on procfs.
+	fakeProcDir := filepath.Join(dir, "subdir")
+	fakeProcFile := filepath.Join(fakeProcDir, "c")
+	defer func(orig func(string) (uint32, error)) { statfsType = orig }(statfsType)
+	calls := map[string]int{}
+	statfsType = func(path string) (uint32, error) {
+		calls[path]++
+		if path == fakeProcDir {
+			return 0x9fa0, nil
+		}
+		return 0xef53, nil // ext4
+	}
+
+	config := defaultConfig
+	config.Recursive = true
+
+	t.Run("default", func(t *testing.T) {
+		events := scanEvents(t, config, dir)
+
+		event, found := events[fakeProcFile]
+		if assert.True(t, found) {
+			assert.NotNil(t, event.Info, "metadata must still be reported")
+			assert.Empty(t, event.Hashes, "file on procfs must not be read")
+		}
+		assert.Empty(t, events[filepath.Join(fakeProcDir, "d")].Hashes)
+		assert.NotEmpty(t, events[filepath.Join(dir, "b")].Hashes)
+
+		// The filesystem type is determined once per directory, and again
+		// for the files that follow a subdirectory.
+		assert.Equal(t, 1, calls[fakeProcDir])
+		assert.True(t, calls[dir] <= 2, "expected at most 2 statfs calls, got %d", calls[dir])
+	})
+
+	t.Run("hash_pseudo_filesystems", func(t *testing.T) {
+		c := config
+		c.HashPseudoFilesystems = true
+		events := scanEvents(t, c, dir)
+
+		assert.NotEmpty(t, events[fakeProcFile].Hashes)
+	})
+}
+
+func TestNoatimeMount(t *testing.T) {
+	defer func(orig func(string) (int64, error)) { statfsFlags = orig }(statfsFlags)
+
+	statfsFlags = func(path string) (int64, error) { return stNoatime | 0x1, nil }
+	noatime, err := noatimeMount("/")
+	if assert.NoError(t, err) {
+		assert.True(t, noatime)
+	}
+
+	statfsFlags = func(path string) (int64, error) { return 0x1000, nil } // relatime
+	noatime, err = noatimeMount("/")
+	if assert.NoError(t, err) {
+		assert.False(t, noatime)
+	}
+}
+
+func TestScannerAutofsBoundary(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend that subdir is an autofs mountpoint.
+	mountpoint := filepath.Join(dir, "subdir")
+	mounts := filepath.Join(dir, "mounts")
+	entries := fmt.Sprintf("/dev/sda1 / ext4 rw 0 0\n"+
+		"auto.misc %v autofs rw,fd=7,pgrp=1,timeout=300 0 0\n", mountpoint)
+	if err = ioutil.WriteFile(mounts, []byte(entries), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { mountsFile = orig }(mountsFile)
+	mountsFile = mounts
+
+	config := defaultConfig
+	config.Recursive = true
+
+	t.Run("default", func(t *testing.T) {
+		events := scanEvents(t, config, dir)
+
+		assert.Contains(t, events, mountpoint, "mountpoint itself must be reported")
+		assert.NotContains(t, events, filepath.Join(mountpoint, "c"), "autofs mountpoint must not be entered")
+	})
+
+	t.Run("descend_autofs", func(t *testing.T) {
+		c := config
+		c.DescendAutofs = true
+		events := scanEvents(t, c, dir)
+
+		assert.Contains(t, events, filepath.Join(mountpoint, "c"))
+	})
+}
+
+func TestAutofsMountpoints(t *testing.T) {
+	f, err := ioutil.TempFile("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("proc /proc proc rw 0 0\n" +
+		"systemd-1 /mnt/my\\040share autofs rw 0 0\n" +
+		"/etc/auto.net /net autofs rw 0 0\n")
+	f.Close()
+
+	defer func(orig string) { mountsFile = orig }(mountsFile)
+	mountsFile = f.Name()
+
+	mountpoints, err := autofsMountpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]struct{}{"/mnt/my share": {}, "/net": {}}, mountpoints)
+}
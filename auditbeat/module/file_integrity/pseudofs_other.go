@@ -0,0 +1,21 @@
+// +build !linux
+
+package file_integrity
+
+// noatimeMount is not supported on this platform and always returns false and
+// no error.
+func noatimeMount(path string) (bool, error) {
+	return false, nil
+}
+
+// pseudoFilesystem is not supported on this platform and always returns an
+// empty string and no error.
+func pseudoFilesystem(path string) (string, error) {
+	return "", nil
+}
+
+// autofsMountpoints is not supported on this platform and always returns no
+// mountpoints and no error.
+func autofsMountpoints() (map[string]struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,269 @@
+package file_integrity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QuarantineConfig configures moving files with a bad or unknown reputation
+// to a quarantine directory. It requires a ReputationLookup.
+type QuarantineConfig struct {
+	Enabled        bool   `config:"enabled"`
+	Path           string `config:"path"`            // Directory that receives quarantined files.
+	IncludeUnknown bool   `config:"include_unknown"` // Also quarantine files with an unknown reputation.
+}
+
+// validate validates the quarantine config against the configured scan paths.
+func (c *QuarantineConfig) validate(scanPaths []string) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Path == "" {
+		return errors.New("quarantine.path is required when quarantine is enabled")
+	}
+	if !filepath.IsAbs(c.Path) {
+		return errors.Errorf("quarantine.path (%v) must be an absolute path", c.Path)
+	}
+
+	// Quarantined files must never be scanned (and quarantined) again.
+	for _, p := range scanPaths {
+		if isSubPath(c.Path, p) || isSubPath(p, c.Path) {
+			return errors.Errorf("quarantine.path (%v) must not overlap with paths (%v)", c.Path, p)
+		}
+	}
+	return nil
+}
+
+// shouldQuarantine returns true if files with the given reputation must be
+// quarantined.
+func (c *QuarantineConfig) shouldQuarantine(r Reputation) bool {
+	if !c.Enabled {
+		return false
+	}
+	return r == KnownBad || (c.IncludeUnknown && r == UnknownReputation)
+}
+
+// isSubPath returns true if path is equal to or inside of dir.
+func isSubPath(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// renameFile is used to move files. It is a variable so that cross-device
+// moves can be simulated in tests.
+var renameFile = os.Rename
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE which is returned on Windows when
+// renaming across volumes.
+const errorNotSameDevice = syscall.Errno(17)
+
+func isCrossDeviceError(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	if !ok {
+		return false
+	}
+	errno, ok := linkErr.Err.(syscall.Errno)
+	if !ok {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return errno == errorNotSameDevice
+	}
+	return errno == syscall.EXDEV
+}
+
+// quarantineRecordSuffix is appended to the name of a quarantined file to
+// name the record of where it came from.
+const quarantineRecordSuffix = ".quarantine.json"
+
+// quarantineRecord is written next to each quarantined file so that it can be
+// restored to its original location with its original metadata.
+type quarantineRecord struct {
+	OriginalPath  string              `json:"original_path"`
+	QuarantinedAt time.Time           `json:"quarantined_at"`
+	Reputation    Reputation          `json:"reputation"`
+	Hashes        map[HashType]Digest `json:"hashes,omitempty"`
+	Metadata      *Metadata           `json:"metadata"` // Mode, owner, and mtime of the original.
+}
+
+// quarantineMode returns the mode of a quarantined file with the given
+// original mode. The execute, setuid, setgid, and sticky bits are removed so
+// that the file cannot be run from the quarantine dir. The original mode is
+// kept in the quarantineRecord.
+func quarantineMode(mode os.FileMode) os.FileMode {
+	return mode.Perm() &^ 0111
+}
+
+// prepareQuarantineDir creates dir if it does not exist. Existing dirs that
+// are accessible by the group or other users are refused because they would
+// expose the quarantined files.
+func prepareQuarantineDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create quarantine dir")
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat quarantine dir")
+	}
+	if !info.IsDir() {
+		return errors.Errorf("quarantine dir %v is not a directory", dir)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return errors.Errorf("quarantine dir %v must not be accessible by "+
+			"the group or other users (mode %v)", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// quarantineFile moves the file described by event into dir and returns its
+// new path. A quarantineRecord is written next to it first. Within the same
+// filesystem the file is renamed. Otherwise it is copied (preserving
+// ownership and modification time) and the original is removed. If the
+// original cannot be removed the copy is deleted so that the file is never
+// left in both places. Either way the mode of the quarantined file is
+// restricted by quarantineMode. On failure neither the record nor a partial
+// copy is left in dir.
+func quarantineFile(event *Event, dir string) (dst string, err error) {
+	if err = prepareQuarantineDir(dir); err != nil {
+		return "", err
+	}
+
+	dst = filepath.Join(dir, fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(event.Path)))
+	record := dst + quarantineRecordSuffix
+	if err = writeQuarantineRecord(record, event); err != nil {
+		return "", errors.Wrap(err, "failed to write quarantine record")
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(record)
+		}
+	}()
+
+	err = renameFile(event.Path, dst)
+	if err == nil {
+		if err = restrictMode(dst); err != nil {
+			// Roll back.
+			if mvErr := renameFile(dst, event.Path); mvErr != nil {
+				return "", errors.Wrapf(err, "failed to restrict the mode of the "+
+					"quarantined file and failed to move it back from %v (%v)", dst, mvErr)
+			}
+			return "", errors.Wrap(err, "failed to restrict the mode of the quarantined file")
+		}
+		return dst, nil
+	}
+	if !isCrossDeviceError(err) {
+		return "", errors.Wrap(err, "failed to move file to quarantine")
+	}
+
+	if err = copyFile(event.Path, dst, event.Info); err != nil {
+		return "", errors.Wrap(err, "failed to copy file to quarantine")
+	}
+
+	if err = os.Remove(event.Path); err != nil {
+		// Roll back.
+		if rmErr := os.Remove(dst); rmErr != nil {
+			return "", errors.Wrapf(err, "failed to remove original after copying "+
+				"to quarantine and failed to roll back the copy at %v (%v)", dst, rmErr)
+		}
+		return "", errors.Wrap(err, "failed to remove original after copying to quarantine")
+	}
+	return dst, nil
+}
+
+// restrictMode changes the mode of the file at path to its quarantineMode.
+func restrictMode(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, quarantineMode(info.Mode()))
+}
+
+// writeQuarantineRecord writes the quarantineRecord of the file described by
+// event to the new file path.
+func writeQuarantineRecord(path string, event *Event) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(path)
+		}
+	}()
+
+	if err = json.NewEncoder(f).Encode(quarantineRecord{
+		OriginalPath:  event.Path,
+		QuarantinedAt: time.Now().UTC(),
+		Reputation:    event.Reputation,
+		Hashes:        event.Hashes,
+		Metadata:      event.Info,
+	}); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// copyFile copies src to the new file dst preserving its owner and
+// modification time. The mode of dst is the quarantineMode of the original
+// mode. The data
+// is written to a temporary file next to dst that is renamed to dst once it
+// is complete, so dst never contains a partial copy. The temporary file is
+// removed if the copy fails.
+func copyFile(src, dst string, info *Metadata) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".partial"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "windows" {
+		// Changing the owner requires privileges. The copy is kept either way.
+		os.Lchown(tmp, int(info.UID), int(info.GID))
+	}
+	if err = os.Chmod(tmp, quarantineMode(info.Mode)); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmp, info.MTime, info.MTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
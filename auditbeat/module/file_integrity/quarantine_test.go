@@ -0,0 +1,257 @@
+package file_integrity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerQuarantine(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	quarantineDir, err := ioutil.TempDir("", "audit-file-quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(quarantineDir)
+
+	evil := filepath.Join(dir, "evil")
+	if err = ioutil.WriteFile(evil, []byte("malware"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.ReputationLookup = NewAllowlistLookup(SHA1,
+		[]Digest{sha1Digest("file a"), sha1Digest("file b")}, 0.0001,
+		&fakeReputationLookup{
+			reputation: UnknownReputation,
+			known:      map[string]Reputation{string(sha1Digest("malware")): KnownBad},
+		})
+	config.Quarantine = QuarantineConfig{Enabled: true, Path: quarantineDir}
+
+	_, list := runScan(t, config)
+	events := map[string]Event{}
+	for _, event := range list {
+		events[filepath.Base(event.Path)] = event
+	}
+
+	e := events["evil"]
+	assert.Equal(t, KnownBad, e.Reputation)
+	if assert.NotEmpty(t, e.QuarantinePath) {
+		assert.Equal(t, quarantineDir, filepath.Dir(e.QuarantinePath))
+		data, err := ioutil.ReadFile(e.QuarantinePath)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "malware", string(data))
+		}
+		info, err := os.Lstat(e.QuarantinePath)
+		if assert.NoError(t, err) && runtime.GOOS != "windows" {
+			assert.Equal(t, os.FileMode(0640), info.Mode(), "execute bits must be removed")
+		}
+	}
+	_, err = os.Lstat(evil)
+	assert.True(t, os.IsNotExist(err), "original must be removed")
+	if e.QuarantinePath != "" {
+		assert.Equal(t, evil, readQuarantineRecord(t, e.QuarantinePath)["original_path"])
+	}
+
+	assert.Empty(t, events["a"].QuarantinePath)
+	_, err = os.Lstat(filepath.Join(dir, "a"))
+	assert.NoError(t, err, "known-good file must not be moved")
+}
+
+// simulateCrossDevice makes renames fail like moves to another filesystem
+// until the returned function is called.
+func simulateCrossDevice() (restore func()) {
+	orig := renameFile
+	renameFile = func(oldpath, newpath string) error {
+		errno := syscall.EXDEV
+		if runtime.GOOS == "windows" {
+			errno = errorNotSameDevice
+		}
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errno}
+	}
+	return func() { renameFile = orig }
+}
+
+// readQuarantineRecord reads the record of the quarantined file at path.
+func readQuarantineRecord(t *testing.T, path string) map[string]interface{} {
+	data, err := ioutil.ReadFile(path + quarantineRecordSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]interface{}
+	if err = json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestQuarantineFileCrossDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "bad")
+	if err = ioutil.WriteFile(src, []byte("malware"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(src, 0750|os.ModeSetuid|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err = os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	defer simulateCrossDevice()()
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := NewMetadata(src, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := quarantineFile(&Event{Path: src, Info: meta, Reputation: KnownBad}, filepath.Join(dir, "quarantine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Lstat(src)
+	assert.True(t, os.IsNotExist(err), "original must be removed")
+
+	dstInfo, err := os.Lstat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, mtime.Equal(dstInfo.ModTime()), "mtime must be preserved")
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0640), dstInfo.Mode(), "execute, setuid, and setgid bits must be removed")
+	}
+	data, err := ioutil.ReadFile(dst)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "malware", string(data))
+	}
+
+	record := readQuarantineRecord(t, dst)
+	assert.Equal(t, src, record["original_path"])
+	assert.Equal(t, "known_bad", record["reputation"])
+	if metadata, ok := record["metadata"].(map[string]interface{}); assert.True(t, ok) {
+		assert.EqualValues(t, meta.Mode, metadata["mode"], "the original mode must be recorded")
+		assert.EqualValues(t, meta.UID, metadata["uid"])
+		recorded, err := time.Parse(time.RFC3339Nano, metadata["mtime"].(string))
+		if assert.NoError(t, err) {
+			assert.True(t, mtime.Equal(recorded), "mtime must be recorded")
+		}
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "quarantine"))
+	if assert.NoError(t, err) {
+		assert.Len(t, entries, 2, "only the copy and its record")
+	}
+}
+
+func TestQuarantineFileFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	quarantineDir := filepath.Join(dir, "quarantine")
+	assertEmpty := func(t *testing.T) {
+		entries, err := ioutil.ReadDir(quarantineDir)
+		if assert.NoError(t, err) {
+			assert.Empty(t, entries, "nothing must be left in the quarantine dir")
+		}
+	}
+
+	defer simulateCrossDevice()()
+
+	t.Run("copy fails", func(t *testing.T) {
+		// Reading a directory fails after the copy was created.
+		src := filepath.Join(dir, "unreadable")
+		if err := os.Mkdir(src, 0700); err != nil {
+			t.Fatal(err)
+		}
+		_, err := quarantineFile(&Event{Path: src, Info: &Metadata{Mode: 0600}}, quarantineDir)
+		assert.Error(t, err)
+		assertEmpty(t)
+	})
+
+	t.Run("remove fails", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			t.Skip("requires directory permissions that deny removing files")
+		}
+
+		parent := filepath.Join(dir, "readonly")
+		if err := os.Mkdir(parent, 0700); err != nil {
+			t.Fatal(err)
+		}
+		src := filepath.Join(parent, "bad")
+		if err := ioutil.WriteFile(src, []byte("malware"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(parent, 0500); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(parent, 0700)
+
+		_, err := quarantineFile(&Event{Path: src, Info: &Metadata{Mode: 0600}}, quarantineDir)
+		assert.Error(t, err)
+		assertEmpty(t)
+		_, err = os.Lstat(src)
+		assert.NoError(t, err, "original must be kept")
+	})
+
+	t.Run("dir accessible by others", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("requires POSIX permissions")
+		}
+
+		src := filepath.Join(dir, "bad")
+		if err := ioutil.WriteFile(src, []byte("malware"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(quarantineDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(quarantineDir, 0700)
+
+		_, err := quarantineFile(&Event{Path: src, Info: &Metadata{Mode: 0600}}, quarantineDir)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "must not be accessible")
+		}
+		assertEmpty(t)
+		_, err = os.Lstat(src)
+		assert.NoError(t, err, "original must be kept")
+	})
+}
+
+func TestQuarantineConfigValidate(t *testing.T) {
+	root := "/var/lib"
+	if runtime.GOOS == "windows" {
+		root = `C:\data`
+	}
+
+	c := QuarantineConfig{Enabled: true, Path: filepath.Join(root, "quarantine")}
+	assert.NoError(t, c.validate([]string{filepath.Join(root, "bin")}))
+	assert.Error(t, c.validate([]string{root}), "quarantine inside of a scanned path")
+	assert.Error(t, c.validate([]string{filepath.Join(root, "quarantine", "x")}))
+	assert.Error(t, (&QuarantineConfig{Enabled: true}).validate(nil))
+	assert.Error(t, (&QuarantineConfig{Enabled: true, Path: "relative"}).validate(nil))
+	assert.NoError(t, (&QuarantineConfig{}).validate(nil))
+}
@@ -0,0 +1,216 @@
+package file_integrity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Redaction methods.
+const (
+	RedactBlank = "blank" // Remove the field from the event.
+	RedactHash  = "hash"  // Replace the value with its hex encoded SHA-256 hash, or HMAC-SHA256 if a key is set.
+)
+
+// RedactField configures the redaction of an event field before the event is
+// published.
+//
+// An unkeyed hash only allows correlating equal values. It does not keep them
+// confidential because values with few possible choices, such as user names,
+// can be recovered by hashing candidates. Set a secret Key for RedactHash to
+// prevent this.
+type RedactField struct {
+	Field  string `config:"field"`  // Name of the field as published (e.g. file.owner).
+	Method string `config:"method"` // RedactBlank (default) or RedactHash.
+	Key    string `config:"key"`    // Secret HMAC key for RedactHash.
+}
+
+// RedactFields is a list of fields to redact.
+type RedactFields []RedactField
+
+func (r RedactFields) validate() error {
+	var errs multierror.Errors
+	for i, f := range r {
+		if f.Field == "" {
+			errs = append(errs, errors.Errorf("redact_fields[%d].field is required", i))
+		}
+		switch f.Method {
+		case "", RedactBlank, RedactHash:
+		default:
+			errs = append(errs, errors.Errorf("invalid redact_fields[%d].method value '%v'", i, f.Method))
+		}
+		if f.Key != "" && f.Method != RedactHash {
+			errs = append(errs, errors.Errorf("redact_fields[%d].key requires method '%v'", i, RedactHash))
+		}
+	}
+	return errs.Err()
+}
+
+// apply redacts the configured fields that are present in fields.
+func (r RedactFields) apply(fields common.MapStr) {
+	for _, f := range r {
+		v, err := fields.GetValue(f.Field)
+		if err != nil {
+			continue
+		}
+
+		if f.Method != RedactHash {
+			fields.Delete(f.Field)
+			continue
+		}
+
+		fields.Put(f.Field, f.hash(v))
+	}
+}
+
+// hash returns the hashed value of v. Lists are kept as lists so that each
+// element is redacted on its own.
+func (f RedactField) hash(v interface{}) interface{} {
+	if list, ok := v.([]string); ok {
+		hashed := make([]string, len(list))
+		for i, s := range list {
+			hashed[i] = redactHash(f.Key, s)
+		}
+		return hashed
+	}
+	return redactHash(f.Key, fmt.Sprint(v))
+}
+
+// eventFields are the published fields that redactEvent can redact in an
+// Event. Fields of hashes (hash.<type>) and of the DigestPostProcessor
+// (Event.Derived) can be redacted too.
+var eventFields = map[string]bool{
+	"file.path":            true,
+	"file.target_path":     true,
+	"file.old_path":        true,
+	"file.parent_dir":      true,
+	"file.quarantine_path": true,
+	"file.owner":           true,
+	"file.group":           true,
+	"file.origin":          true,
+}
+
+// unsupportedInEvents returns the redacted fields that redactEvent cannot
+// redact. These are the other fields of the file object, such as file.uid.
+func (r RedactFields) unsupportedInEvents() []string {
+	var fields []string
+	for _, f := range r {
+		if strings.HasPrefix(f.Field, "file.") && !eventFields[f.Field] {
+			fields = append(fields, f.Field)
+		}
+	}
+	return fields
+}
+
+// redactEvent returns a copy of the event with the fields redacted. It is used
+// for the events delivered to the EventSinks, which are not published as
+// fields. The event itself is not modified.
+func (r RedactFields) redactEvent(e Event) Event {
+	if len(r) == 0 {
+		return e
+	}
+	if e.Info != nil {
+		info := *e.Info
+		info.Origin = append([]string(nil), info.Origin...)
+		e.Info = &info
+	}
+	if e.Hashes != nil {
+		hashes := make(map[HashType]Digest, len(e.Hashes))
+		for hashType, digest := range e.Hashes {
+			hashes[hashType] = digest
+		}
+		e.Hashes = hashes
+	}
+	if e.Derived != nil {
+		e.Derived = e.Derived.Clone()
+	}
+
+	for _, f := range r {
+		redact := func(s string) string {
+			if f.Method != RedactHash || s == "" {
+				return ""
+			}
+			return redactHash(f.Key, s)
+		}
+		redactDigest := func(d Digest) Digest {
+			if f.Method != RedactHash {
+				return nil
+			}
+			// The published value is the hash of the hex encoded digest.
+			hashed, _ := hex.DecodeString(redactHash(f.Key, d.String()))
+			return hashed
+		}
+
+		switch f.Field {
+		case "file.path":
+			e.Path = redact(e.Path)
+		case "file.target_path":
+			e.TargetPath = redact(e.TargetPath)
+		case "file.old_path":
+			e.OldPath = redact(e.OldPath)
+		case "file.parent_dir":
+			e.ParentDir = redact(e.ParentDir)
+		case "file.quarantine_path":
+			e.QuarantinePath = redact(e.QuarantinePath)
+		case "file.owner", "file.group", "file.origin":
+			if e.Info == nil {
+				continue
+			}
+			switch f.Field {
+			case "file.owner":
+				e.Info.Owner = redact(e.Info.Owner)
+			case "file.group":
+				e.Info.Group = redact(e.Info.Group)
+			default:
+				var origin []string
+				for _, s := range e.Info.Origin {
+					if s = redact(s); s != "" {
+						origin = append(origin, s)
+					}
+				}
+				e.Info.Origin = origin
+			}
+		case "hash.combined":
+			if len(e.CombinedHash) > 0 {
+				e.CombinedHash = redactDigest(e.CombinedHash)
+			}
+		default:
+			hashType := HashType(strings.TrimPrefix(f.Field, "hash."))
+			if digest, found := e.Hashes[hashType]; found && strings.HasPrefix(f.Field, "hash.") {
+				if digest = redactDigest(digest); digest == nil {
+					delete(e.Hashes, hashType)
+				} else {
+					e.Hashes[hashType] = digest
+				}
+				continue
+			}
+			if v, found := e.Derived[f.Field]; found {
+				if f.Method != RedactHash {
+					delete(e.Derived, f.Field)
+				} else {
+					e.Derived[f.Field] = f.hash(v)
+				}
+			}
+		}
+	}
+	return e
+}
+
+// redactHash returns the hex encoded SHA-256 hash of s, or its HMAC-SHA256 if
+// key is not empty.
+func redactHash(key, s string) string {
+	if key == "" {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package file_integrity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestRedactFields(t *testing.T) {
+	e := &Event{
+		Timestamp: time.Now(),
+		Path:      "/home/alice/Downloads/report.pdf",
+		Action:    Created,
+		Info: &Metadata{
+			Owner:  "alice",
+			Group:  "staff",
+			Size:   1,
+			Type:   FileType,
+			Origin: []string{"https://example.com/report.pdf", "https://example.com/"},
+		},
+	}
+
+	ms := &MetricSet{config: defaultConfig}
+	ms.config.RedactFields = RedactFields{
+		{Field: "file.owner"},
+		{Field: "file.origin"},
+		{Field: "file.group", Method: RedactHash},
+		{Field: "file.not_present", Method: RedactHash},
+	}
+
+	file, err := ms.buildEvent(e, false).MetricSetFields.GetValue("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := file.(common.MapStr)
+
+	assert.NotContains(t, fields, "owner")
+	assert.NotContains(t, fields, "origin")
+	assert.NotContains(t, fields, "not_present")
+	assert.Equal(t, redactHash("", "staff"), fields["group"])
+	assert.Equal(t, e.Path, fields["path"])
+	assert.EqualValues(t, 1, fields["size"])
+
+	// Lists are hashed element by element.
+	ms.config.RedactFields = RedactFields{{Field: "file.origin", Method: RedactHash}}
+	origin, err := ms.buildEvent(e, false).MetricSetFields.GetValue("file.origin")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{
+			redactHash("", "https://example.com/report.pdf"),
+			redactHash("", "https://example.com/"),
+		}, origin)
+	}
+
+	// With a key the values are replaced by their HMAC.
+	ms.config.RedactFields = RedactFields{{Field: "file.owner", Method: RedactHash, Key: "secret"}}
+	owner, err := ms.buildEvent(e, false).MetricSetFields.GetValue("file.owner")
+	if assert.NoError(t, err) {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte("alice"))
+		assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), owner)
+		assert.NotEqual(t, redactHash("", "alice"), owner)
+	}
+}
+
+func TestRedactFieldsValidate(t *testing.T) {
+	assert.NoError(t, RedactFields{{Field: "file.owner"}, {Field: "file.path", Method: RedactHash}}.validate())
+	assert.Error(t, RedactFields{{Method: RedactBlank}}.validate())
+	assert.Error(t, RedactFields{{Field: "file.owner", Method: "mask"}}.validate())
+	assert.NoError(t, RedactFields{{Field: "file.owner", Method: RedactHash, Key: "secret"}}.validate())
+	assert.Error(t, RedactFields{{Field: "file.owner", Key: "secret"}}.validate(), "key without hash")
+}
@@ -0,0 +1,158 @@
+package file_integrity
+
+import (
+	"sync"
+)
+
+// Reputation classifies a file based on a lookup of its hash.
+type Reputation uint8
+
+func (r Reputation) String() string {
+	if name, found := reputationNames[r]; found {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalText marshals the Reputation to a textual representation of itself.
+func (r Reputation) MarshalText() ([]byte, error) { return []byte(r.String()), nil }
+
+// Enum of possible Reputations.
+const (
+	NoReputation      Reputation = iota // No lookup was performed.
+	UnknownReputation                   // The hash is not known to the lookup.
+	KnownGood
+	KnownBad
+)
+
+var reputationNames = map[Reputation]string{
+	UnknownReputation: "unknown",
+	KnownGood:         "known_good",
+	KnownBad:          "known_bad",
+}
+
+// ReputationLookup classifies files by their hashes. Implementations must be
+// safe for concurrent use.
+type ReputationLookup interface {
+	// Lookup returns the reputation of a file given its hashes.
+	Lookup(hashes map[HashType]Digest) (Reputation, error)
+}
+
+// allowlistCacheSize is the maximum number of known-good answers of the
+// remote lookup that an allowlistLookup remembers.
+const allowlistCacheSize = 16384
+
+// allowlistLookup answers lookups for allowlisted hashes from a local bloom
+// filter and delegates misses to another ReputationLookup (e.g. a remote
+// database). Because the filter can report false positives, its hits are
+// confirmed with the delegate so that they never hide a known-bad answer.
+// Known-good answers from the delegate are remembered exactly so that
+// subsequent lookups of the same hash are answered locally.
+type allowlistLookup struct {
+	hashType HashType
+	remote   ReputationLookup
+	frozen   bool // Known-good answers of remote are not remembered.
+
+	bloom *bloomFilter
+
+	// cached and previous hold the known-good answers of remote. When cached
+	// is full it replaces previous so that at most allowlistCacheSize answers
+	// are remembered.
+	mutex    sync.Mutex
+	cached   map[string]struct{}
+	previous map[string]struct{}
+}
+
+// NewAllowlistLookup returns a ReputationLookup that classifies files whose
+// hashType digest is in allowlist as KnownGood. Other files are looked up in
+// remote, or are classified as UnknownReputation if remote is nil. Because the
+// allowlist is stored in a bloom filter a file may be classified as KnownGood
+// with a false positive probability of falsePositiveRate if remote is nil.
+// Otherwise files in the allowlist are also looked up in remote and its
+// KnownBad answer takes precedence.
+func NewAllowlistLookup(
+	hashType HashType,
+	allowlist []Digest,
+	falsePositiveRate float64,
+	remote ReputationLookup,
+) ReputationLookup {
+	bloom := newBloomFilter(len(allowlist), falsePositiveRate)
+	for _, digest := range allowlist {
+		bloom.Add(digest)
+	}
+
+	return &allowlistLookup{
+		hashType: hashType,
+		remote:   remote,
+		bloom:    bloom,
+	}
+}
+
+func (l *allowlistLookup) Lookup(hashes map[HashType]Digest) (Reputation, error) {
+	digest, found := hashes[l.hashType]
+	if !found {
+		return NoReputation, nil
+	}
+
+	allowlisted := l.bloom.MayContain(digest)
+	if l.remote == nil {
+		if allowlisted {
+			return KnownGood, nil
+		}
+		return UnknownReputation, nil
+	}
+	if l.isCached(digest) {
+		return KnownGood, nil
+	}
+
+	reputation, err := l.remote.Lookup(hashes)
+	if err != nil {
+		return NoReputation, err
+	}
+
+	switch {
+	case reputation == KnownGood:
+		if !l.frozen {
+			l.cache(digest)
+		}
+	case reputation != KnownBad && allowlisted:
+		// The remote does not contradict the allowlist.
+		reputation = KnownGood
+	}
+	return reputation, nil
+}
+
+// isCached returns true if remote answered that digest is known-good.
+func (l *allowlistLookup) isCached(digest Digest) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key := string(digest)
+	if _, found := l.cached[key]; found {
+		return true
+	}
+	if _, found := l.previous[key]; found {
+		l.add(key)
+		return true
+	}
+	return false
+}
+
+// cache remembers that remote answered that digest is known-good.
+func (l *allowlistLookup) cache(digest Digest) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.add(string(digest))
+}
+
+// add adds key to the cached answers, rotating them when they are full. The
+// mutex must be held.
+func (l *allowlistLookup) add(key string) {
+	if len(l.cached) >= allowlistCacheSize/2 {
+		l.previous, l.cached = l.cached, nil
+	}
+	if l.cached == nil {
+		l.cached = map[string]struct{}{}
+	}
+	l.cached[key] = struct{}{}
+}
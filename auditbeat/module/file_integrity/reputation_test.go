@@ -0,0 +1,148 @@
+package file_integrity
+
+import (
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReputationLookup answers with reputation unless known contains the
+// reputation of the SHA1 digest.
+type fakeReputationLookup struct {
+	reputation Reputation
+	known      map[string]Reputation
+	calls      int
+}
+
+func (l *fakeReputationLookup) Lookup(hashes map[HashType]Digest) (Reputation, error) {
+	l.calls++
+	if r, found := l.known[string(hashes[SHA1])]; found {
+		return r, nil
+	}
+	return l.reputation, nil
+}
+
+func sha1Digest(data string) Digest {
+	sum := sha1.Sum([]byte(data))
+	return sum[:]
+}
+
+func TestAllowlistLookup(t *testing.T) {
+	allowlist := []Digest{sha1Digest("file a"), sha1Digest("file b")}
+
+	t.Run("local only", func(t *testing.T) {
+		l := NewAllowlistLookup(SHA1, allowlist, 0.0001, nil)
+
+		r, err := l.Lookup(map[HashType]Digest{SHA1: sha1Digest("file a")})
+		assert.NoError(t, err)
+		assert.Equal(t, KnownGood, r)
+
+		r, err = l.Lookup(map[HashType]Digest{SHA1: sha1Digest("file c")})
+		assert.NoError(t, err)
+		assert.Equal(t, UnknownReputation, r)
+
+		// The configured hash type was not computed.
+		r, err = l.Lookup(map[HashType]Digest{MD5: sha1Digest("file a")})
+		assert.NoError(t, err)
+		assert.Equal(t, NoReputation, r)
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		remote := &fakeReputationLookup{reputation: UnknownReputation}
+		l := NewAllowlistLookup(SHA1, allowlist, 0.0001, remote)
+
+		// Allowlisted files are confirmed with the remote.
+		r, _ := l.Lookup(map[HashType]Digest{SHA1: sha1Digest("file a")})
+		assert.Equal(t, KnownGood, r)
+		assert.Equal(t, 1, remote.calls)
+
+		remote.reputation = KnownBad
+		r, _ = l.Lookup(map[HashType]Digest{SHA1: sha1Digest("evil")})
+		assert.Equal(t, KnownBad, r)
+		assert.Equal(t, 2, remote.calls)
+
+		// Known-good answers from the remote are cached locally.
+		remote.reputation = KnownGood
+		r, _ = l.Lookup(map[HashType]Digest{SHA1: sha1Digest("file c")})
+		assert.Equal(t, KnownGood, r)
+		r, _ = l.Lookup(map[HashType]Digest{SHA1: sha1Digest("file c")})
+		assert.Equal(t, KnownGood, r)
+		assert.Equal(t, 3, remote.calls)
+	})
+
+	t.Run("false positive", func(t *testing.T) {
+		// A filter that holds a single bit reports everything as allowlisted.
+		remote := &fakeReputationLookup{reputation: KnownBad}
+		l := NewAllowlistLookup(SHA1, allowlist, 0.0001, remote).(*allowlistLookup)
+		l.bloom = newBloomFilterSize(64, 1)
+		l.bloom.bits[0] = ^uint64(0)
+		assert.True(t, l.bloom.MayContain(sha1Digest("evil")))
+
+		r, _ := l.Lookup(map[HashType]Digest{SHA1: sha1Digest("evil")})
+		assert.Equal(t, KnownBad, r, "a false positive must not hide a known-bad answer")
+	})
+
+	t.Run("cache size", func(t *testing.T) {
+		remote := &fakeReputationLookup{reputation: KnownGood}
+		l := NewAllowlistLookup(SHA1, nil, 0.0001, remote).(*allowlistLookup)
+		for i := 0; i < 2*allowlistCacheSize; i++ {
+			l.Lookup(map[HashType]Digest{SHA1: sha1Digest(strconv.Itoa(i))})
+		}
+		assert.True(t, len(l.cached)+len(l.previous) <= allowlistCacheSize)
+
+		// The most recent answers are still cached.
+		calls := remote.calls
+		l.Lookup(map[HashType]Digest{SHA1: sha1Digest(strconv.Itoa(2*allowlistCacheSize - 1))})
+		assert.Equal(t, calls, remote.calls)
+	})
+}
+
+func TestScannerReputation(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "unknown"), []byte("not allowlisted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.ReputationLookup = NewAllowlistLookup(SHA1,
+		[]Digest{sha1Digest("file a"), sha1Digest("file b")}, 0.0001, nil)
+
+	_, events := runScan(t, config)
+	reputations := map[string]Reputation{}
+	for _, event := range events {
+		reputations[filepath.Base(event.Path)] = event.Reputation
+	}
+
+	assert.Equal(t, KnownGood, reputations["a"])
+	assert.Equal(t, KnownGood, reputations["b"])
+	assert.Equal(t, UnknownReputation, reputations["unknown"])
+	// Files that are not hashed are not looked up.
+	assert.Equal(t, NoReputation, reputations["subdir"])
+}
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte{byte(i), byte(i >> 8), 'x'})
+	}
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.MayContain([]byte{byte(i), byte(i >> 8), 'x'}))
+	}
+
+	var falsePositives int
+	for i := 0; i < 1000; i++ {
+		if f.MayContain([]byte{byte(i), byte(i >> 8), 'y'}) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 50, "too many false positives: %v", falsePositives)
+}
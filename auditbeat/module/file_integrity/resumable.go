@@ -0,0 +1,195 @@
+package file_integrity
+
+import (
+	"encoding"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errHashIncomplete is returned when hashing of a file was interrupted and its
+// state was persisted so that the next scan can resume it.
+var errHashIncomplete = errors.New("hash_incomplete: hashing will be resumed by the next scan")
+
+// PartialHash is the persisted state of a file whose hashing was interrupted.
+type PartialHash struct {
+	Inode     uint64     `json:"inode"`
+	Size      uint64     `json:"size"`
+	MTime     time.Time  `json:"mtime"`
+	HashTypes []HashType `json:"hash_types"`
+	States    [][]byte   `json:"states"` // Marshaled state of each hash in HashTypes.
+	Offset    uint64     `json:"offset"` // Number of bytes hashed so far.
+}
+
+// matches returns true if hashing of the file described by info can be
+// resumed from the partial hash with the given hash types.
+func (p *PartialHash) matches(info *Metadata, hashTypes []HashType) bool {
+	if p.Inode != info.Inode || p.Size != info.Size || !p.MTime.Equal(info.MTime) ||
+		p.Offset > info.Size || len(p.HashTypes) != len(hashTypes) ||
+		len(p.States) != len(hashTypes) {
+		return false
+	}
+	for i, hashType := range hashTypes {
+		if p.HashTypes[i] != hashType {
+			return false
+		}
+	}
+	return true
+}
+
+// PartialHashStore persists the state of files whose hashing was interrupted.
+// A StateStore that implements it enables ResumableHashing.
+type PartialHashStore interface {
+	// LoadPartialHash returns the partial hash of path or nil if there is none.
+	LoadPartialHash(path string) (*PartialHash, error)
+	StorePartialHash(path string, p *PartialHash) error
+	DeletePartialHash(path string) error
+}
+
+// unresumableHashTypes returns the hash types whose state cannot be saved.
+// The hashes of the standard library can save their state since Go 1.10.
+func unresumableHashTypes(hashTypes []HashType) []HashType {
+	var unresumable []HashType
+	for _, hashType := range hashTypes {
+		hashes, err := newHashes([]HashType{hashType})
+		if err != nil {
+			continue
+		}
+		if _, ok := hashes[0].(encoding.BinaryMarshaler); !ok {
+			unresumable = append(unresumable, hashType)
+		}
+	}
+	return unresumable
+}
+
+// partialHashStore returns the store for partial hashes or nil if hashing is
+// not resumable.
+func (s *scanner) partialHashStore() PartialHashStore {
+	if !s.config.ResumableHashing || s.config.DoubleRead {
+		return nil
+	}
+	store, _ := s.config.State.(PartialHashStore)
+	return store
+}
+
+// hashFileResumable hashes the file, resuming from the state persisted by a
+// previous scan if the file is unchanged. If hashing is interrupted by the
+// read timeout or because ResumableHashMaxSize bytes were read, the state is
+// persisted and errHashIncomplete is returned. All hashes are computed in one
+// sequential pass so that their states are saved at the same offset, which
+// means that parallel BLAKE3 hashing and the parallel expensive hash strategy
+// are not used. Files are hashed in a single pass if any of the configured
+// hashes cannot marshal its state. The state of the combined hash is persisted
+// after the state of the configured hashes.
+func (s *scanner) hashFileResumable(path string, info *Metadata, store PartialHashStore) (map[HashType]Digest, Digest, error) {
+	hashTypes := s.config.HashTypes
+	if s.config.CombinedHash != "" {
+		hashTypes = append(hashTypes[:len(hashTypes):len(hashTypes)], s.config.CombinedHash)
+	}
+	hashes, err := newHashes(hashTypes)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, h := range hashes {
+		if _, ok := h.(encoding.BinaryMarshaler); !ok {
+			return s.computeHashesCombined(path, info)
+		}
+	}
+
+	var offset uint64
+	if p, err := store.LoadPartialHash(path); err != nil {
+		s.log.Debugw("Failed to load partial hash", "file_path", path, "error", err)
+	} else if p != nil {
+		if !p.matches(info, hashTypes) {
+			s.log.Debugw("Discarding partial hash of changed file", "file_path", path)
+		} else if err = restoreHashes(hashes, p.States); err != nil {
+			s.log.Debugw("Discarding partial hash", "file_path", path, "error", err)
+			if hashes, err = newHashes(hashTypes); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			offset = p.Offset
+		}
+	}
+
+	f, err := s.openFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open file for hashing")
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to seek to partial hash offset")
+	}
+
+	var r io.Reader = f
+	max := s.config.ResumableHashMaxSizeBytes
+	if max > 0 {
+		r = io.LimitReader(r, int64(max))
+	}
+	if deadline := s.readDeadline(info.Size - offset); !deadline.IsZero() {
+		r = &deadlineReader{r: r, deadline: deadline}
+	}
+
+	release := s.limitExpensive(hashTypes)
+	n, err := io.Copy(multiWriter(hashes), r)
+	release()
+	offset += uint64(n)
+	if err != nil && errors.Cause(err) != errReadTimeout {
+		store.DeletePartialHash(path)
+		return nil, nil, errors.Wrap(err, "failed to calculate file hashes")
+	}
+
+	// Reading less than the limit means that the end of the file was reached.
+	if err == nil && (max == 0 || uint64(n) < max || offset >= info.Size) {
+		if err = store.DeletePartialHash(path); err != nil {
+			s.log.Debugw("Failed to delete partial hash", "file_path", path, "error", err)
+		}
+		var combined Digest
+		if s.config.CombinedHash != "" {
+			last := len(hashes) - 1
+			combined = (&combinedHasher{Hash: hashes[last], info: info}).digest()
+			hashes = hashes[:last]
+		}
+		sums := make(map[HashType]Digest, len(hashes))
+		for i, h := range hashes {
+			sums[hashTypes[i]] = h.Sum(nil)
+		}
+		return sums, combined, nil
+	}
+
+	states := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		if states[i], err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to marshal hash state")
+		}
+	}
+	err = store.StorePartialHash(path, &PartialHash{
+		Inode:     info.Inode,
+		Size:      info.Size,
+		MTime:     info.MTime,
+		HashTypes: hashTypes,
+		States:    states,
+		Offset:    offset,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to store partial hash")
+	}
+	return nil, nil, errors.Wrapf(errHashIncomplete, "hashed %d of %d bytes", offset, info.Size)
+}
+
+// restoreHashes restores the marshaled state of each hash.
+func restoreHashes(hashes []hash.Hash, states [][]byte) error {
+	for i, h := range hashes {
+		u, ok := h.(encoding.BinaryUnmarshaler)
+		if !ok {
+			return errors.New("hash state cannot be restored")
+		}
+		if err := u.UnmarshalBinary(states[i]); err != nil {
+			return errors.Wrap(err, "failed to restore hash state")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package file_integrity
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type mapPartialHashStore struct {
+	mapStateStore
+	partial map[string]*PartialHash
+}
+
+func (s *mapPartialHashStore) LoadPartialHash(path string) (*PartialHash, error) {
+	return s.partial[path], nil
+}
+
+func (s *mapPartialHashStore) StorePartialHash(path string, p *PartialHash) error {
+	s.partial[path] = p
+	return nil
+}
+
+func (s *mapPartialHashStore) DeletePartialHash(path string) error {
+	delete(s.partial, path)
+	return nil
+}
+
+func TestScannerResumableHashing(t *testing.T) {
+	if _, ok := sha1.New().(encoding.BinaryMarshaler); !ok {
+		t.Skip("hash state cannot be marshaled with this Go version")
+	}
+
+	dir, err := ioutil.TempDir("", "audit-file-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := make([]byte, 300*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	huge := filepath.Join(dir, "huge")
+	if err = ioutil.WriteFile(huge, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	state := &mapPartialHashStore{mapStateStore{}, map[string]*PartialHash{}}
+	config := defaultConfig
+	config.HashTypes = []HashType{SHA1, SHA256}
+	config.CombinedHash = SHA256
+	config.ResumableHashing = true
+	config.ResumableHashMaxSizeBytes = 200 * 1024
+	config.State = state
+
+	scan := func() Event {
+		return scanEvents(t, config, dir)[huge]
+	}
+
+	// The first run hashes 200 KiB and persists the state.
+	e := scan()
+	assert.True(t, e.HashIncomplete)
+	assert.Nil(t, e.Hashes)
+	if assert.Len(t, e.errors, 1) {
+		assert.Equal(t, errHashIncomplete, errors.Cause(e.errors[0]))
+	}
+	if p := state.partial[huge]; assert.NotNil(t, p) {
+		assert.EqualValues(t, 200*1024, p.Offset)
+	}
+
+	// The second run hashes the remaining 100 KiB.
+	e = scan()
+	assert.False(t, e.HashIncomplete)
+	sha1Sum := sha1.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+	assert.Equal(t, Digest(sha1Sum[:]), e.Hashes[SHA1])
+	assert.Equal(t, Digest(sha256Sum[:]), e.Hashes[SHA256])
+	combined := sha256.Sum256(append(content, canonicalMetadata(e.Info)...))
+	assert.Equal(t, Digest(combined[:]), e.CombinedHash)
+	assert.Empty(t, state.partial)
+
+	// A partial hash of a file that changed since is discarded.
+	assert.True(t, scan().HashIncomplete)
+	mtime := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(huge, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, scan().HashIncomplete)
+	if p := state.partial[huge]; assert.NotNil(t, p) {
+		assert.EqualValues(t, 200*1024, p.Offset, "expected hashing to restart")
+	}
+}
+
+func TestResumableHashingUnsupportedHashType(t *testing.T) {
+	// SHA-3 hashes cannot save their state.
+	config := defaultConfig
+	config.Paths = []string{os.TempDir()}
+	config.HashTypes = []HashType{SHA1, SHA3_256}
+	config.ResumableHashing = true
+	config.State = &mapPartialHashStore{mapStateStore{}, map[string]*PartialHash{}}
+
+	assert.Equal(t, []HashType{SHA3_256}, unresumableHashTypes(config.HashTypes))
+
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, reader.(*scanner).partialHashStore(), "resumable hashing must be disabled")
+}
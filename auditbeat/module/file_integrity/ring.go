@@ -0,0 +1,163 @@
+package file_integrity
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/paths"
+)
+
+const defaultEventRingFile = "file_integrity_events.ndjson"
+
+// EventRingConfig configures an in-memory ring buffer holding the last events
+// generated by the scanner. The buffer is written to Path when the scanner
+// panics or is stopped before completing so that the files that were being
+// scanned can be identified.
+type EventRingConfig struct {
+	Size int    `config:"size"` // Number of events retained. Zero disables the buffer.
+	Path string `config:"path"` // Dump file. Defaults to a file in the data directory.
+}
+
+func (c *EventRingConfig) validate() error {
+	if c.Size < 0 {
+		return errors.Errorf("event_ring.size value (%v) must not be negative", c.Size)
+	}
+	return nil
+}
+
+func (c *EventRingConfig) path() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return paths.Resolve(paths.Data, defaultEventRingFile)
+}
+
+// eventRing is a fixed size ring buffer of events. It is safe for concurrent
+// use.
+type eventRing struct {
+	mu         sync.Mutex
+	events     []Event
+	next       int // Index of the slot to overwrite next.
+	full       bool
+	inProgress string // Path whose event is being generated.
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{events: make([]Event, size)}
+}
+
+// Begin records that the event of path is being generated. Events are only
+// added once the file was hashed, so this identifies the file that was being
+// read when the scanner panicked.
+func (r *eventRing) Begin(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inProgress = path
+}
+
+// Add adds the event to the ring, overwriting the oldest event when the ring
+// is full.
+func (r *eventRing) Add(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.Path == r.inProgress {
+		r.inProgress = ""
+	}
+	r.events[r.next] = event
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Events returns the retained events from oldest to newest.
+func (r *eventRing) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]Event(nil), r.events[:r.next]...)
+	}
+	out := make([]Event, 0, len(r.events))
+	out = append(out, r.events[r.next:]...)
+	return append(out, r.events[:r.next]...)
+}
+
+// InProgress returns the path recorded by Begin whose event has not been
+// added yet.
+func (r *eventRing) InProgress() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.inProgress
+}
+
+// Dump writes the retained events from oldest to newest to w as
+// newline-delimited JSON, followed by a record of the path that was in
+// progress, if any.
+func (r *eventRing) Dump(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, event := range r.Events() {
+		if err := enc.Encode(event); err != nil {
+			return errors.Wrapf(err, "failed to encode event for %v", event.Path)
+		}
+	}
+	if path := r.InProgress(); path != "" {
+		record := struct {
+			Path       string `json:"path"`
+			InProgress bool   `json:"in_progress"`
+		}{path, true}
+		if err := enc.Encode(record); err != nil {
+			return errors.Wrapf(err, "failed to encode in progress path %v", path)
+		}
+	}
+	return nil
+}
+
+// dumpRing writes the event ring buffer to its configured file.
+func (s *scanner) dumpRing(reason string) {
+	var buf bytes.Buffer
+	err := s.ring.Dump(&buf)
+	if err == nil {
+		err = ioutil.WriteFile(s.config.EventRing.path(), buf.Bytes(), 0600)
+	}
+	if err != nil {
+		s.log.Errorw("Failed to dump event ring buffer", "reason", reason, "error", err)
+		return
+	}
+	s.log.Infow("Dumped event ring buffer", "reason", reason,
+		"file_path", s.config.EventRing.path())
+}
+
+// dumpRingOnPanic dumps the event ring buffer if the goroutine panics. It must
+// be deferred by the goroutines that the scanner starts to hash files.
+func (s *scanner) dumpRingOnPanic() {
+	if s.ring == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		s.dumpRing("panic")
+		panic(r)
+	}
+}
+
+// dumpRingOnExit dumps the event ring buffer if the scan goroutine panics or
+// the scanner is stopped. It must be deferred by the scan goroutine.
+func (s *scanner) dumpRingOnExit() {
+	if r := recover(); r != nil {
+		s.dumpRing("panic")
+		panic(r)
+	}
+
+	select {
+	case <-s.done:
+		s.dumpRing("shutdown")
+	default:
+	}
+}
@@ -0,0 +1,148 @@
+package file_integrity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventRing(t *testing.T) {
+	ring := newEventRing(3)
+	assert.Empty(t, ring.Events())
+
+	for i := 0; i < 5; i++ {
+		ring.Add(Event{Path: strconv.Itoa(i), Action: Created})
+	}
+
+	var paths []string
+	for _, e := range ring.Events() {
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, paths)
+
+	var buf bytes.Buffer
+	if err := ring.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	paths = nil
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, e["path"].(string))
+		assert.Equal(t, "created", e["action"])
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, paths)
+
+	// The path in progress is cleared when its event is added.
+	ring.Begin("5")
+	assert.Equal(t, "5", ring.InProgress())
+	ring.Add(Event{Path: "5"})
+	assert.Empty(t, ring.InProgress())
+}
+
+func TestScannerDumpRingOnPanic(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "file_integrity_ring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := defaultConfig
+	config.Paths = []string{dumpDir}
+	config.EventRing = EventRingConfig{Size: 2, Path: filepath.Join(dumpDir, "events.ndjson")}
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := reader.(*scanner)
+
+	// A hashing goroutine panics while the event of "b" is generated.
+	s.ring.Add(Event{Path: "a"})
+	s.ring.Begin("b")
+	recovered := func() (r interface{}) {
+		defer func() { r = recover() }()
+		defer s.dumpRingOnPanic()
+		panic("hash failed")
+	}()
+	assert.Equal(t, "hash failed", recovered, "the panic is propagated")
+
+	data, err := ioutil.ReadFile(config.EventRing.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if assert.Len(t, lines, 2) {
+		var e map[string]interface{}
+		if err = json.Unmarshal(lines[1], &e); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, map[string]interface{}{"path": "b", "in_progress": true}, e)
+	}
+}
+
+func TestScannerEventRing(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumpDir, err := ioutil.TempDir("", "file_integrity_ring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.EventRing = EventRingConfig{Size: 2, Path: filepath.Join(dumpDir, "events.ndjson")}
+
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	eventC, err := reader.Start(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop the scanner after the first event.
+	first := <-eventC
+	close(done)
+	for range eventC {
+	}
+
+	data, err := ioutil.ReadFile(config.EventRing.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if bytes.Contains(lines[len(lines)-1], []byte(`"in_progress":true`)) {
+		// The scanner was stopped while generating the next event.
+		lines = lines[:len(lines)-1]
+	}
+	if assert.True(t, len(lines) >= 1 && len(lines) <= 2, "expected at most 2 events, got %d", len(lines)) {
+		var e map[string]interface{}
+		if err = json.Unmarshal(lines[0], &e); err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) == 1 {
+			assert.Equal(t, first.Path, e["path"])
+		}
+	}
+}
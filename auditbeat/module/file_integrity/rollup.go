@@ -0,0 +1,111 @@
+package file_integrity
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// DirRollup summarizes the direct children of a directory. It is computed by
+// the scanner when the walk leaves the directory.
+type DirRollup struct {
+	ChildCount           uint64    `json:"child_count"`           // Number of direct children.
+	TotalBytes           uint64    `json:"total_bytes"`           // Sum of the sizes of the child files.
+	NewestMTime          time.Time `json:"newest_mtime"`          // Most recent mtime of any child.
+	PermissionViolations uint64    `json:"permission_violations"` // Children that could not be read due to permissions.
+}
+
+type dirRollupState struct {
+	path   string
+	event  *Event // Nil for directories that are descended but not reported.
+	rollup DirRollup
+}
+
+// pushRollup starts a rollup for the directory at path that the walk is
+// descending into. event describes the directory or is nil if it is not
+// reported, in which case no rollup event is emitted for it. Every descended
+// directory must be pushed so that the stack mirrors the walk.
+func (s *scanner) pushRollup(path string, event *Event) {
+	if !s.config.DirRollup {
+		return
+	}
+
+	s.rollups = append(s.rollups, &dirRollupState{path: path, event: event})
+}
+
+// popRollups emits a rollup event for each directory on the stack that is not
+// the parent of path. The walk visits paths in lexical order so once a path
+// outside of a directory is seen all of the directory's children are known.
+// An empty path flushes all rollups.
+func (s *scanner) popRollups(path string) error {
+	parent := filepath.Dir(path)
+	for len(s.rollups) > 0 {
+		top := s.rollups[len(s.rollups)-1]
+		if path != "" && top.path == parent {
+			return nil
+		}
+		s.rollups = s.rollups[:len(s.rollups)-1]
+		if top.event == nil {
+			continue
+		}
+
+		rollup := top.rollup
+		event := Event{
+			Timestamp: time.Now().UTC(),
+			Path:      top.event.Path,
+			Info:      top.event.Info,
+			Source:    SourceScan,
+			Rollup:    &rollup,
+		}
+		if err := s.send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parentRollup returns the rollup for the parent directory of path or nil if
+// the parent is not being rolled up.
+func (s *scanner) parentRollup(path string) *DirRollup {
+	if len(s.rollups) == 0 {
+		return nil
+	}
+
+	top := s.rollups[len(s.rollups)-1]
+	if top.event == nil || top.path != filepath.Dir(path) {
+		return nil
+	}
+	return &top.rollup
+}
+
+// addRollupChild accounts for the child described by event in its parent's
+// rollup.
+func (s *scanner) addRollupChild(event *Event) {
+	rollup := s.parentRollup(event.Path)
+	if rollup == nil {
+		return
+	}
+
+	rollup.ChildCount++
+	if event.Info != nil {
+		if event.Info.Type == FileType {
+			rollup.TotalBytes += event.Info.Size
+		}
+		if event.Info.MTime.After(rollup.NewestMTime) {
+			rollup.NewestMTime = event.Info.MTime
+		}
+	}
+	for _, err := range event.errors {
+		if isPermissionError(err) {
+			rollup.PermissionViolations++
+			break
+		}
+	}
+}
+
+// addRollupPermissionViolation records that path could not be read due to a
+// permission error.
+func (s *scanner) addRollupPermissionViolation(path string) {
+	if rollup := s.parentRollup(path); rollup != nil {
+		rollup.PermissionViolations++
+	}
+}
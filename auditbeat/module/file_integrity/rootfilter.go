@@ -0,0 +1,134 @@
+package file_integrity
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/common/match"
+)
+
+// Precedences of the include_files and exclude_files of a root filter.
+const (
+	ExcludeWins = "exclude_wins"
+	IncludeWins = "include_wins"
+)
+
+// RootFilter narrows down the files reported below one of the configured
+// paths. If IncludeFiles is set, only paths that match one of its regular
+// expressions are reported. Paths that match one of ExcludeFiles are not. The
+// precedence decides about paths that match both.
+type RootFilter struct {
+	Path         string          `config:"path"`
+	IncludeFiles []match.Matcher `config:"include_files"`
+	ExcludeFiles []match.Matcher `config:"exclude_files"`
+	Precedence   string          `config:"precedence"` // One of exclude_wins (default) or include_wins.
+}
+
+// RootFilters holds the filters of the configured paths.
+type RootFilters []RootFilter
+
+// validate validates the filters and resolves symlinks in their paths, like
+// it is done for the configured paths.
+func (f RootFilters) validate(paths []string) error {
+	var errs multierror.Errors
+	seen := map[string]bool{}
+	for i := range f {
+		r := &f[i]
+		if r.Path == "" {
+			errs = append(errs, errors.Errorf("root_filters[%d].path is required", i))
+			continue
+		}
+		if evalPath, err := filepath.EvalSymlinks(r.Path); err == nil {
+			r.Path = evalPath
+		}
+		if seen[r.Path] {
+			errs = append(errs, errors.Errorf("root_filters[%d].path (%v) has more than one filter", i, r.Path))
+		}
+		seen[r.Path] = true
+
+		found := false
+		for _, p := range paths {
+			if p == r.Path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, errors.Errorf("root_filters[%d].path (%v) is not one of the paths", i, r.Path))
+		}
+
+		switch r.Precedence {
+		case "":
+			r.Precedence = ExcludeWins
+		case ExcludeWins, IncludeWins:
+		default:
+			errs = append(errs, errors.Errorf("invalid root_filters[%d].precedence value '%v'", i, r.Precedence))
+		}
+	}
+	return errs.Err()
+}
+
+// rootFilter returns the filter of the innermost configured path that
+// contains path and its index. It returns nil if that path has no filter.
+func (c *Config) rootFilter(path string) (*RootFilter, int) {
+	if len(c.RootFilters) == 0 {
+		return nil, -1
+	}
+	var root string
+	for _, p := range c.Paths {
+		if len(p) > len(root) && isSubPath(path, p) {
+			root = p
+		}
+	}
+	for i := range c.RootFilters {
+		if c.RootFilters[i].Path == root {
+			return &c.RootFilters[i], i
+		}
+	}
+	return nil, -1
+}
+
+// filterPath returns a description of the rule that excludes path. It returns
+// an empty string if path is reported. Files below an excluded directory can
+// still be included if the include_files of its root are allowlist-first or
+// the directory was only excluded for matching none of them, so prune is false
+// if the walk must still descend into the directory.
+func (c *Config) filterPath(path string) (rule string, prune bool) {
+	f, i := c.rootFilter(path)
+	if f == nil {
+		return matchingRule("exclude_files", c.ExcludeFiles, path), true
+	}
+
+	allowlist := f.Precedence == IncludeWins && len(f.IncludeFiles) > 0
+	included := len(f.IncludeFiles) == 0 ||
+		matchingRule("", f.IncludeFiles, path) != ""
+	if allowlist && included {
+		return "", true
+	}
+
+	rule = matchingRule("exclude_files", c.ExcludeFiles, path)
+	if rule == "" {
+		rule = matchingRule(fmt.Sprintf("root_filters[%d].exclude_files", i), f.ExcludeFiles, path)
+	}
+	if rule != "" {
+		return rule, !allowlist
+	}
+	if !included {
+		return fmt.Sprintf("root_filters[%d].include_files: no match", i), false
+	}
+	return "", true
+}
+
+// matchingRule returns a description of the first of the option's matchers
+// that matches path. It returns an empty string if none matches.
+func matchingRule(option string, matchers []match.Matcher, path string) string {
+	for i, matcher := range matchers {
+		if matcher.MatchString(path) {
+			return fmt.Sprintf("%v[%d]: %v", option, i, matcher.String())
+		}
+	}
+	return ""
+}
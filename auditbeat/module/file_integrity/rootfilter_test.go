@@ -0,0 +1,92 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common/match"
+)
+
+func TestScannerRootFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-root-filters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both roots have the same files and rules but opposite precedence.
+	allowlist, broad := filepath.Join(dir, "allowlist"), filepath.Join(dir, "broad")
+	for _, root := range []string{allowlist, broad} {
+		if err = os.MkdirAll(filepath.Join(root, "cache"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"app.conf", "secret.conf", "notes.txt", filepath.Join("cache", "x.conf")} {
+			if err = ioutil.WriteFile(filepath.Join(root, name), []byte(name), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	filter := func(root, precedence string) RootFilter {
+		return RootFilter{
+			Path:         root,
+			IncludeFiles: []match.Matcher{match.MustCompile(`\.conf$`)},
+			ExcludeFiles: []match.Matcher{match.MustCompile(`secret`), match.MustCompile(`/cache$`)},
+			Precedence:   precedence,
+		}
+	}
+
+	config := defaultConfig
+	config.Paths = []string{allowlist, broad}
+	config.Recursive = true
+	config.RootFilters = RootFilters{filter(allowlist, IncludeWins), filter(broad, ExcludeWins)}
+	if err = config.RootFilters.validate(config.Paths); err != nil {
+		t.Fatal(err)
+	}
+
+	_, events := runScan(t, config)
+	var paths []string
+	for _, event := range events {
+		rel, err := filepath.Rel(dir, event.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	// Includes override excludes in the allowlist root, so the excluded cache
+	// dir is still walked. In the other root excludes prune the cache dir.
+	assert.Equal(t, []string{
+		"allowlist/app.conf",
+		"allowlist/cache/x.conf",
+		"allowlist/secret.conf",
+		"broad/app.conf",
+	}, paths)
+
+	assert.True(t, config.IsExcludedPath(filepath.Join(broad, "secret.conf")))
+	assert.False(t, config.IsExcludedPath(filepath.Join(allowlist, "secret.conf")))
+	assert.True(t, config.IsExcludedPath(filepath.Join(allowlist, "notes.txt")))
+}
+
+func TestRootFiltersValidate(t *testing.T) {
+	f := RootFilters{{Path: "/etc"}}
+	if assert.NoError(t, f.validate([]string{"/etc", "/usr"})) {
+		assert.Equal(t, ExcludeWins, f[0].Precedence)
+	}
+
+	assert.Error(t, RootFilters{{}}.validate([]string{"/etc"}))
+	assert.Error(t, RootFilters{{Path: "/var"}}.validate([]string{"/etc"}))
+	assert.Error(t, RootFilters{{Path: "/etc"}, {Path: "/etc"}}.validate([]string{"/etc"}))
+	assert.Error(t, RootFilters{{Path: "/etc", Precedence: "first"}}.validate([]string{"/etc"}))
+}
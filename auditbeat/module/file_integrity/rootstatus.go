@@ -0,0 +1,68 @@
+package file_integrity
+
+// Completion status of a configured root. A root is partial if the scan did
+// not cover all of it. The status describes the most severe reason: errors,
+// then read timeouts, then limits.
+const (
+	RootComplete       = "complete"
+	RootPartialLimit   = "partial-limit"
+	RootPartialTimeout = "partial-timeout"
+	RootPartialError   = "partial-error"
+)
+
+var rootStatusSeverity = map[string]int{
+	RootComplete:       0,
+	RootPartialLimit:   1,
+	RootPartialTimeout: 2,
+	RootPartialError:   3,
+}
+
+// rootStatus is the completion status of a configured path or squashfs
+// image. It is included in the scan summary.
+type rootStatus struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// newRootStatuses returns the statuses of the given roots. Roots are partial
+// until their scan begins so that roots that were never reached because the
+// scanner stopped are not reported as complete.
+func newRootStatuses(roots ...[]string) []rootStatus {
+	var statuses []rootStatus
+	for _, paths := range roots {
+		for _, path := range paths {
+			statuses = append(statuses, rootStatus{Path: path, Status: RootPartialError})
+		}
+	}
+	return statuses
+}
+
+// beginRoot makes the i-th root the one that is currently being scanned.
+func (s *scanner) beginRoot(i int) {
+	s.root = &s.roots[i]
+	s.root.Status = RootComplete
+}
+
+// markPartial records that the root currently being scanned was not covered
+// completely. A less severe status does not replace a more severe one.
+func (s *scanner) markPartial(status string) {
+	if s.root != nil && rootStatusSeverity[status] > rootStatusSeverity[s.root.Status] {
+		s.root.Status = status
+	}
+}
+
+// observeCoverage marks the current root as partial if the event shows that
+// the file was not covered completely.
+func (s *scanner) observeCoverage(event *Event) {
+	switch {
+	case event.ReadTimedOut:
+		s.markPartial(RootPartialTimeout)
+	case event.HashIncomplete:
+		s.markPartial(RootPartialLimit)
+	case len(event.errors) > 0 && !event.Vanished:
+		s.markPartial(RootPartialError)
+	case event.Info != nil && event.Info.Type == FileType && !event.Skipped &&
+		event.Info.Size > s.config.MaxFileSizeBytes:
+		s.markPartial(RootPartialLimit)
+	}
+}
@@ -0,0 +1,84 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common/file"
+)
+
+func TestScannerRootStatus(t *testing.T) {
+	good := setupTestDir(t)
+	defer os.RemoveAll(good)
+
+	bad := setupTestDir(t)
+	defer os.RemoveAll(bad)
+
+	var err error
+	if bad, err = filepath.EvalSymlinks(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading a file in the middle of the bad root fails.
+	unreadable := filepath.Join(bad, "b")
+	openForHashing = func(name string) (*os.File, error) {
+		if name == unreadable {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EIO}
+		}
+		return file.ReadOpen(name)
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+
+	config := defaultConfig
+	config.Paths = []string{good, bad}
+	config.Recursive = true
+
+	s, events := runScan(t, config)
+	var paths []string
+	for _, event := range events {
+		paths = append(paths, event.Path)
+	}
+	assert.Contains(t, paths, filepath.Join(bad, "subdir", "c"), "expected the walk to continue")
+
+	assert.Equal(t, []rootStatus{
+		{Path: good, Status: RootComplete},
+		{Path: bad, Status: RootPartialError},
+	}, s.roots)
+}
+
+func TestScannerRootStatusLimit(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "large"), make([]byte, 2048), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir, filepath.Join(dir, "missing")}
+	config.MaxFileSizeBytes = 1024
+
+	s, _ := runScan(t, config)
+
+	assert.Equal(t, []rootStatus{
+		{Path: dir, Status: RootPartialLimit},
+		{Path: filepath.Join(dir, "missing"), Status: RootPartialError},
+	}, s.roots)
+}
+
+func TestRootStatusSeverity(t *testing.T) {
+	s := &scanner{roots: newRootStatuses([]string{"/a"}, []string{"/b.sqfs"})}
+	assert.Equal(t, RootPartialError, s.roots[1].Status, "roots are partial until scanned")
+
+	s.beginRoot(0)
+	s.markPartial(RootPartialTimeout)
+	s.markPartial(RootPartialLimit)
+	assert.Equal(t, RootPartialTimeout, s.roots[0].Status)
+	s.markPartial(RootPartialError)
+	assert.Equal(t, RootPartialError, s.roots[0].Status)
+}
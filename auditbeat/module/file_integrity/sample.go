@@ -0,0 +1,72 @@
+package file_integrity
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+)
+
+// SamplingConfig configures the scanner to only scan a deterministic sample
+// of the files in large directories. Files are selected by a hash of their
+// path and the seed so the same files are selected by every scan until the
+// seed is changed.
+type SamplingConfig struct {
+	Seed        uint64            `config:"seed"`
+	Directories []SampleDirectory `config:"directories"`
+}
+
+// SampleDirectory is a directory whose files are sampled at the given rate.
+type SampleDirectory struct {
+	Path string  `config:"path"`
+	Rate float64 `config:"rate"` // Fraction of files to scan (0, 1].
+}
+
+// validate validates the sampling config.
+func (c *SamplingConfig) validate() error {
+	var errs multierror.Errors
+	for i, d := range c.Directories {
+		if !filepath.IsAbs(d.Path) {
+			errs = append(errs, errors.Errorf("sampling.directories[%d].path (%v) must be an absolute path", i, d.Path))
+		}
+		if d.Rate <= 0 || d.Rate > 1 {
+			errs = append(errs, errors.Errorf("sampling.directories[%d].rate (%v) must be in (0, 1]", i, d.Rate))
+		}
+	}
+	return errs.Err()
+}
+
+// rate returns the sample rate that applies to the file at path. When
+// sampled directories are nested the innermost one applies.
+func (c *SamplingConfig) rate(path string) float64 {
+	rate, depth := 1.0, -1
+	for _, d := range c.Directories {
+		dir := filepath.Clean(d.Path)
+		if path == dir || !isSubPath(path, dir) {
+			continue
+		}
+		if n := len(dir); n > depth {
+			rate, depth = d.Rate, n
+		}
+	}
+	return rate
+}
+
+// selected returns true if the file at path is part of the sample.
+func (c *SamplingConfig) selected(path string) bool {
+	rate := c.rate(path)
+	if rate >= 1 {
+		return true
+	}
+
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], c.Seed)
+	h := sha1.New()
+	h.Write(seed[:])
+	h.Write([]byte(path))
+	v := binary.BigEndian.Uint64(h.Sum(nil))
+	return float64(v) < rate*math.MaxUint64
+}
@@ -1,18 +1,48 @@
 package file_integrity
 
 import (
-	"errors"
+	"bytes"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/juju/ratelimit"
+	"github.com/pkg/errors"
 
 	"github.com/elastic/beats/libbeat/logp"
 )
 
+// errDone is returned by the walk function when the done channel is closed.
+var errDone = errors.New("done")
+
+// errUnstableRead is returned when re-reading a file produced different hashes
+// than the first read.
+var errUnstableRead = errors.New("unstable_read: file contents changed between reads")
+
+// errNoReadCapability is returned when a privileged read was requested but the
+// process is not permitted to bypass file read permission checks.
+var errNoReadCapability = errors.New("process is not permitted to read files regardless of their permissions")
+
+// sinceHashStart returns the time elapsed since hashing of a file began. It is
+// a variable so that tests can control the measured duration.
+var sinceHashStart = time.Since
+
+// StateStore provides access to the last persisted state of files.
+type StateStore interface {
+	// Load returns the last persisted event for path or nil if there is none.
+	Load(path string) (*Event, error)
+
+	// Store persists the event as the last state of its path.
+	Store(event *Event) error
+}
+
 // scannerID is used as a global monotonically increasing counter for assigning
 // a unique name to each scanner instance for logging purposes. Use
 // atomic.AddUint32() to get a new value.
@@ -26,6 +56,68 @@ type scanner struct {
 	done   <-chan struct{}
 	eventC chan Event
 
+	// rollups is a stack of the directories currently being walked. It is
+	// only used when DirRollup is enabled.
+	rollups []*dirRollupState
+
+	// pendingHashes are files waiting to be hashed by the configured Hasher.
+	// pendingBytes is the size of their buffered contents.
+	pendingHashes []pendingHash
+	pendingBytes  uint64
+
+	// autofs holds the autofs mountpoints that the scanner must not descend
+	// into.
+	autofs map[string]struct{}
+
+	// fsType is the pseudo filesystem, if any, of the directory fsTypeDir.
+	fsTypeDir string
+	fsType    string
+
+	// depth tracks how deep the scan descended into the configured paths.
+	depth depthStats
+
+	// largest and slowest are the top lists of files by size and by hash
+	// duration included in the scan summary.
+	largest *topN
+	slowest *topN
+
+	// roots holds the completion status of each configured root and root
+	// points to the status of the one that is currently being scanned.
+	roots []rootStatus
+	root  *rootStatus
+
+	// suppress is the set of SuppressHashes.
+	suppress map[string]struct{}
+
+	// expensive caps the number of expensive hash operations that run at the
+	// same time.
+	expensive *hashLimiter
+
+	// sinks holds the delivery stats of each of the configured Sinks, and
+	// sinkQueues the events buffered for them.
+	sinks      []sinkStats
+	sinkQueues []chan Event
+	sinksDone  sync.WaitGroup
+
+	// backpressure slows down the scanner while the consumer of eventC falls
+	// behind. It is nil unless Backpressure is configured.
+	backpressure *backpressure
+
+	// usage is the CPU time and IO consumed by the process during the scan.
+	usage resourceUsage
+
+	// summary describes the scan once it has completed. It is set before
+	// eventC is closed.
+	summary *scanSummary
+
+	// ring holds the last emitted events. It is nil unless EventRing is
+	// configured.
+	ring *eventRing
+
+	pauseMu     sync.Mutex
+	resumeC     chan struct{} // Non-nil while paused. Closed on resume.
+	stopSignals func()
+
 	log    *logp.Logger
 	config Config
 }
@@ -33,11 +125,53 @@ type scanner struct {
 // NewFileSystemScanner creates a new EventProducer instance that scans the
 // configured file paths.
 func NewFileSystemScanner(c Config) (EventProducer, error) {
-	return &scanner{
-		log:    logp.NewLogger(moduleName).With("scanner_id", atomic.AddUint32(&scannerID, 1)),
-		config: c,
-		eventC: make(chan Event, 1),
-	}, nil
+	if c.HashBatchSize <= 0 {
+		c.HashBatchSize = defaultHashBatchSize
+	}
+	if c.HashBatchMaxFileSize == 0 {
+		c.HashBatchMaxFileSize = defaultHashBatchMaxFileSize
+	}
+
+	s := &scanner{
+		log:     logp.NewLogger(moduleName).With("scanner_id", atomic.AddUint32(&scannerID, 1)),
+		config:  c,
+		eventC:  make(chan Event, 1),
+		largest: newTopN(c.SummaryTopN),
+		slowest: newTopN(c.SummaryTopN),
+
+		expensive: newHashLimiter(c.ExpensiveHashes.MaxConcurrent),
+		suppress:  c.SuppressHashes.set(),
+
+		backpressure: newBackpressure(c.Backpressure),
+		sinks:        newSinkStats(c.Sinks),
+	}
+	if len(c.Sinks) > 0 {
+		if fields := c.RedactFields.unsupportedInEvents(); len(fields) > 0 {
+			return nil, errors.Errorf("redact_fields %v cannot be redacted in "+
+				"the events sent to sinks", fields)
+		}
+	}
+	if c.EventRing.Size > 0 {
+		s.ring = newEventRing(c.EventRing.Size)
+	}
+	if c.ResumableHashing {
+		hashTypes := c.HashTypes
+		if c.CombinedHash != "" {
+			hashTypes = append(hashTypes[:len(hashTypes):len(hashTypes)], c.CombinedHash)
+		}
+		if types := unresumableHashTypes(hashTypes); len(types) > 0 {
+			s.log.Warnw("Resumable hashing is disabled because the state of some "+
+				"hash types cannot be saved (saving the state of SHA and MD5 hashes "+
+				"requires Go 1.10 or newer)", "hash_types", types)
+			s.config.ResumableHashing = false
+		}
+	}
+	if c.KnownGood.Path != "" {
+		if err := s.loadKnownGood(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
 // Start starts the EventProducer. The provided done channel can be used to stop
@@ -47,6 +181,28 @@ func NewFileSystemScanner(c Config) (EventProducer, error) {
 func (s *scanner) Start(done <-chan struct{}) (<-chan Event, error) {
 	s.done = done
 
+	if s.config.WarmCacheOnly && s.config.State == nil {
+		return nil, errors.New("warm_cache_only requires a state store")
+	}
+
+	if s.config.SelfTest {
+		hashTypes := s.config.HashTypes
+		if s.config.CombinedHash != "" {
+			hashTypes = append(hashTypes[:len(hashTypes):len(hashTypes)], s.config.CombinedHash)
+		}
+		if err := selfTest(hashTypes, selfTestNewHashes); err != nil {
+			return nil, errors.Wrap(err, "hash self-test failed")
+		}
+	}
+
+	if s.config.SignalControl {
+		stop, err := s.enableSignalControl()
+		if err != nil {
+			return nil, err
+		}
+		s.stopSignals = stop
+	}
+
 	if s.config.ScanRateBytesPerSec > 0 {
 		s.log.With(
 			"bytes_per_sec", s.config.ScanRateBytesPerSec,
@@ -70,59 +226,231 @@ func (s *scanner) scan() {
 	s.log.Debugw("File system scanner is starting", "file_path", s.config.Paths)
 	defer s.log.Debug("File system scanner is stopping")
 	defer close(s.eventC)
+	if s.stopSignals != nil {
+		defer s.stopSignals()
+	}
+	if s.ring != nil {
+		defer s.dumpRingOnExit()
+	}
 	startTime := time.Now()
+	startUsage, usageErr := processResourceUsage()
+	if usageErr != nil {
+		s.log.Debugw("Failed to measure resource usage", "error", usageErr)
+	}
+
+	if !s.config.DescendAutofs {
+		var err error
+		if s.autofs, err = autofsMountpoints(); err != nil {
+			s.log.Warnw("Failed to detect autofs mountpoints", "error", err)
+		}
+	}
+
+	if len(s.sinks) > 0 {
+		s.startSinks()
+	}
+
+	s.roots = newRootStatuses(s.config.Paths, s.config.SquashfsImages)
+	for i, path := range s.config.Paths {
+		s.beginRoot(i)
 
-	for _, path := range s.config.Paths {
 		// Resolve symlinks to ensure we have an absolute path.
 		evalPath, err := filepath.EvalSymlinks(path)
 		if err != nil {
+			if info, lerr := os.Lstat(path); os.IsNotExist(err) && lerr == nil &&
+				info.Mode()&os.ModeSymlink != 0 {
+				// Report the dangling symlink itself.
+				if err = s.send(s.newScanEvent(path, info, nil)); err != nil {
+					s.markPartial(RootPartialError)
+					break
+				}
+				continue
+			}
 			s.log.Warnw("Failed to scan", "file_path", path, "error", err)
+			s.markPartial(RootPartialError)
 			continue
 		}
 
+		if s.config.ExcludeAtimeOlderThan > 0 {
+			if noatime, err := noatimeMount(evalPath); err == nil && noatime {
+				s.log.Warnw("Path is on a filesystem mounted with noatime so "+
+					"exclude_atime_older_than relies on stale access times",
+					"file_path", evalPath)
+			}
+		}
+
 		if err = s.walkDir(evalPath); err != nil {
 			s.log.Warnw("Failed to scan", "file_path", evalPath, "error", err)
+			s.markPartial(RootPartialError)
 		}
 	}
 
-	duration := time.Since(startTime)
-	byteCount := atomic.LoadUint64(&s.byteCount)
-	fileCount := atomic.LoadUint64(&s.fileCount)
-	s.log.Infow("File system scan completed",
-		"took", duration,
-		"file_count", fileCount,
-		"total_bytes", byteCount,
-		"bytes_per_sec", float64(byteCount)/float64(duration)*float64(time.Second),
-		"files_per_sec", float64(fileCount)/float64(duration)*float64(time.Second),
-	)
+	for i, image := range s.config.SquashfsImages {
+		s.beginRoot(len(s.config.Paths) + i)
+		if err := s.scanSquashfs(image); err != nil {
+			s.log.Warnw("Failed to scan squashfs image", "file_path", image, "error", err)
+			s.markPartial(RootPartialError)
+		}
+	}
+	s.root = nil
+	if len(s.sinks) > 0 {
+		s.stopSinks()
+	}
+
+	if usage, err := processResourceUsage(); err == nil && usageErr == nil {
+		s.usage = usage.sub(startUsage)
+	}
+	s.summary = s.summarize(startTime)
+	s.log.Infow("File system scan completed", s.summary.keysAndValues()...)
+}
+
+// depthStats summarizes the depth of the directories reached by the scanner,
+// relative to the configured path they were found under.
+type depthStats struct {
+	max   int
+	sum   uint64
+	count uint64
+}
+
+// add records the depth of the directory path found while walking root.
+func (d *depthStats) add(root, path string) {
+	var depth int
+	if rel := strings.TrimPrefix(path[len(root):], string(filepath.Separator)); rel != "" {
+		depth = strings.Count(rel, string(filepath.Separator)) + 1
+	}
+	if depth > d.max {
+		d.max = depth
+	}
+	d.sum += uint64(depth)
+	d.count++
+}
+
+// avg returns the average depth of the directories reached.
+func (d *depthStats) avg() float64 {
+	if d.count == 0 {
+		return 0
+	}
+	return float64(d.sum) / float64(d.count)
 }
 
 func (s *scanner) walkDir(dir string) error {
-	errDone := errors.New("done")
+	walk := filepath.Walk
+	if s.config.TraversalOrder == TraversalBreadthFirst {
+		walk = walkBreadthFirst
+	}
+
+	// revisited is the last path that was visited again because it reappeared
+	// after it vanished. A path is only visited again once so that a file that
+	// keeps vanishing is reported as vanished.
+	var revisited string
+	var visit filepath.WalkFunc
+
+	// vanished applies the vanished_files policy to the path. A path that
+	// reappears is visited like any other. If walked is false the walk does
+	// not descend into it so a reappeared directory is walked here.
+	vanished := func(path string, walked bool) error {
+		info, event, report := s.vanished(path)
+		if info != nil && path != revisited {
+			revisited = path
+			err := visit(path, info, nil)
+			if err != nil || walked || !info.IsDir() {
+				return err
+			}
+			return walk(path, func(p string, i os.FileInfo, err error) error {
+				if p == path {
+					return nil
+				}
+				return visit(p, i, err)
+			})
+		}
+		if info != nil {
+			event, report = newVanishedEvent(path), true
+		}
+		if report {
+			return s.send(event)
+		}
+		return nil
+	}
+
 	startTime := time.Now()
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	visit = func(path string, info os.FileInfo, err error) error {
+		if err := s.waitIfPaused(); err != nil {
+			return err
+		}
+
+		if err := s.popRollups(path); err != nil {
+			return err
+		}
+
 		if err != nil {
+			if os.IsNotExist(err) && s.config.excludeRule(path) == "" {
+				// The path was listed by its parent directory but is gone.
+				return vanished(path, false)
+			}
 			if !os.IsNotExist(err) {
 				s.log.Warnw("Scanner is skipping a path because of an error",
 					"file_path", path, "error", err)
+				s.markPartial(RootPartialError)
+			}
+			if isPermissionError(err) {
+				s.addRollupPermissionViolation(path)
 			}
 			return nil
 		}
 
-		if s.config.IsExcludedPath(path) {
+		if rule, prune := s.config.filterPath(path); rule != "" {
+			if s.config.EmitSkips {
+				if err := s.send(newSkipEvent(path, rule)); err != nil {
+					return err
+				}
+			}
 			if info.IsDir() {
-				return filepath.SkipDir
+				if prune || !s.descend(dir, path, info) {
+					return filepath.SkipDir
+				}
+				s.pushRollup(path, nil)
+			}
+			return nil
+		}
+
+		if s.config.isDormant(info) {
+			if s.config.EmitSkips {
+				rule := "exclude_atime_older_than: " + s.config.ExcludeAtimeOlderThan.String()
+				if err := s.send(newSkipEvent(path, rule)); err != nil {
+					return err
+				}
 			}
 			return nil
 		}
 		defer func() { startTime = time.Now() }()
 
+		if s.config.RequirePermissions != 0 && !s.config.RequirePermissions.Matches(info.Mode()) {
+			if info.IsDir() {
+				if !s.descend(dir, path, info) {
+					return filepath.SkipDir
+				}
+				s.pushRollup(path, nil)
+			}
+			return nil
+		}
+
+		// Files that are not part of the sample are neither hashed nor
+		// reported.
+		if info.Mode().IsRegular() && !s.config.Sampling.selected(path) {
+			return nil
+		}
+
 		event := s.newScanEvent(path, info, err)
+		if isVanished(&event) {
+			return vanished(path, true)
+		}
 		event.rtt = time.Since(startTime)
-		select {
-		case s.eventC <- event:
-		case <-s.done:
-			return errDone
+		s.addRollupChild(&event)
+		if content := event.batchContent; content != nil {
+			event.batchContent = nil
+			return s.queueHash(event, content)
+		}
+		if err := s.send(event); err != nil {
+			return err
 		}
 
 		// Throttle reading and hashing rate.
@@ -130,26 +458,141 @@ func (s *scanner) walkDir(dir string) error {
 			s.throttle(event.Info.Size)
 		}
 
-		// Always traverse into the start dir.
-		if !info.IsDir() || dir == path {
+		if !info.IsDir() {
 			return nil
 		}
+		s.depth.add(dir, path)
 
-		// Only step into directories if recursion is enabled.
-		// Skip symlinks to dirs.
-		m := info.Mode()
-		if !s.config.Recursive || m&os.ModeSymlink > 0 {
+		if !s.descend(dir, path, info) {
 			return filepath.SkipDir
 		}
 
+		s.pushRollup(path, &event)
 		return nil
-	})
+	}
+
+	err := walk(dir, visit)
+	if err == nil {
+		// Directories that were still open when the walk finished.
+		err = s.popRollups("")
+	}
+	if err == nil {
+		err = s.flushHashes()
+	}
 	if err == errDone {
+		// The scanner stopped before the walk finished.
+		s.markPartial(RootPartialError)
 		err = nil
 	}
 	return err
 }
 
+// descend returns true if the walk of root should step into the directory at
+// path.
+func (s *scanner) descend(root, path string, info os.FileInfo) bool {
+	// Always traverse into the start dir.
+	if root == path {
+		return true
+	}
+
+	// Only step into other directories if recursion is enabled.
+	// Skip symlinks to dirs.
+	if !s.config.Recursive || info.Mode()&os.ModeSymlink != 0 {
+		return false
+	}
+
+	// Don't trigger automounts.
+	if _, found := s.autofs[path]; found {
+		s.log.Debugw("Scanner is not descending into autofs mountpoint", "file_path", path)
+		return false
+	}
+	return true
+}
+
+// newSkipEvent returns an event reporting that path was skipped because of
+// the given rule.
+func newSkipEvent(path, rule string) Event {
+	return Event{
+		Timestamp:   time.Now().UTC(),
+		Path:        path,
+		Source:      SourceScan,
+		Skipped:     true,
+		MatchedRule: rule,
+	}
+}
+
+// send sends the event to the event channel after the events of files that
+// are queued for batch hashing. It returns errDone if the scanner is stopped
+// before the event could be delivered.
+func (s *scanner) send(event Event) error {
+	if err := s.flushHashes(); err != nil {
+		return err
+	}
+	return s.emit(event)
+}
+
+// emit sends the event to the event channel. In warm cache only mode the
+// event is written to the state store instead. Batch hashed files are emitted
+// after their hashes are computed so the DigestPostProcessor is called here.
+func (s *scanner) emit(event Event) error {
+	if s.config.DigestPostProcessor != nil && event.Rollup == nil && !event.Skipped {
+		s.config.DigestPostProcessor(&event)
+	}
+	event.Suppressed = s.isSuppressed(&event)
+	s.observeCoverage(&event)
+	if s.ring != nil {
+		s.ring.Add(event)
+	}
+
+	if s.config.WarmCacheOnly {
+		select {
+		case <-s.done:
+			return errDone
+		default:
+		}
+		s.warmCache(&event)
+		return nil
+	}
+
+	if s.config.IncludeParentDir {
+		// Paths are absolute (symlinks in the configured paths are resolved)
+		// so this is never "." for scanner events.
+		event.ParentDir = filepath.Dir(event.Path)
+	}
+	if len(s.sinks) > 0 {
+		s.fanOut(&event)
+	}
+
+	if s.backpressure == nil {
+		select {
+		case s.eventC <- event:
+			return nil
+		case <-s.done:
+			return errDone
+		}
+	}
+
+	start := s.backpressure.now()
+	select {
+	case s.eventC <- event:
+	case <-s.done:
+		return errDone
+	}
+	return s.backOff(s.backpressure.now().Sub(start))
+}
+
+// warmCache persists the state of the file described by the event. Events
+// that do not describe a scanned file are dropped.
+func (s *scanner) warmCache(event *Event) {
+	if event.Info == nil || event.Rollup != nil || event.Skipped || event.Vanished {
+		return
+	}
+
+	if err := s.config.State.Store(event); err != nil {
+		s.log.Warnw("Failed to store file state", "file_path", event.Path, "error", err)
+	}
+}
+
 func (s *scanner) throttle(fileSize uint64) {
 	if s.tokenBucket == nil {
 		return
@@ -166,13 +609,385 @@ func (s *scanner) throttle(fileSize uint64) {
 }
 
 func (s *scanner) newScanEvent(path string, info os.FileInfo, err error) Event {
+	if s.ring != nil {
+		s.ring.Begin(path)
+	}
+
+	// The scanner does its own hashing so no hash types are passed.
 	event := NewEventFromFileInfo(path, info, err, None, SourceScan,
-		s.config.MaxFileSizeBytes, s.config.HashTypes)
+		s.config.MaxFileSizeBytes, nil)
+
+	if s.config.IncludeModeString && err == nil {
+		event.ModeString = lsModeString(info.Mode())
+	}
+	s.describe(&event)
+
+	hashContent := s.hashContent(path, event.Info)
+	if hashContent {
+		if hashes, combined := s.trustedHashes(&event); hashes != nil {
+			event.Hashes, event.CombinedHash = hashes, combined
+		} else if s.isBatchHashable(&event) && s.readForBatch(&event) {
+			// The hashes are computed when the batch is flushed. The
+			// combined hash is computed from the buffered contents.
+			if event.batchContent != nil {
+				s.combineBatchContent(&event)
+			}
+		} else if hashes, combined, took, err := s.hashFile(path, event.Info); err != nil {
+			event.UnstableRead = errors.Cause(err) == errUnstableRead
+			event.ReadTimedOut = errors.Cause(err) == errReadTimeout
+			event.HashIncomplete = errors.Cause(err) == errHashIncomplete
+			event.errors = append(event.errors, err)
+		} else {
+			event.Hashes, event.CombinedHash = hashes, combined
+			s.recordHashTime(&event, took)
+		}
+	}
+
+	// Batch hashed files are restat'ed when the batch is flushed.
+	if s.config.RestatAfterHash && hashContent && event.Info != nil && event.Info.Type == FileType &&
+		event.batchContent == nil {
+		s.restat(&event)
+	}
+
+	// Distinguish files that exist but cannot be read from missing files.
+	if event.Info != nil && event.Info.Type == FileType {
+		for _, err := range event.errors {
+			if isPermissionError(err) {
+				event.ReadPermissionDenied = true
+				break
+			}
+		}
+	}
+
+	// Vanished files are not counted. They are visited again if they
+	// reappear.
+	if isVanished(&event) {
+		return event
+	}
+	if event.batchContent == nil {
+		s.classify(&event)
+	}
+	s.finish(&event)
+	return event
+}
+
+// describe truncates the symlink target and flags modification times in the
+// future according to the config.
+func (s *scanner) describe(event *Event) {
+	if max := s.config.MaxSymlinkTargetLength; max > 0 && len(event.TargetPath) > max {
+		// Do not split a multi-byte character.
+		for max > 0 && !utf8.RuneStart(event.TargetPath[max]) {
+			max--
+		}
+		event.TargetPath = event.TargetPath[:max]
+		event.TargetPathTruncated = true
+	}
+
+	if event.Info != nil && event.Info.Type == FileType {
+		tolerance := s.config.FutureMtimeTolerance
+		event.FutureMTime = event.Info.MTime.After(time.Now().Add(tolerance))
+	}
+}
+
+// finish categorizes the event and updates the metrics of the scan.
+func (s *scanner) finish(event *Event) {
+	event.Category = s.config.Classifier.category(event)
+
+	if event.Info != nil && event.Info.Type == FileType {
+		s.largest.Add(event.Path, float64(event.Info.Size))
+	}
 
 	// Update metrics.
 	atomic.AddUint64(&s.fileCount, 1)
 	if event.Info != nil {
 		atomic.AddUint64(&s.byteCount, event.Info.Size)
 	}
-	return event
+}
+
+// recordHashTime records the time it took to read and hash the file in the
+// top list of the slowest files and, if configured, in the event.
+func (s *scanner) recordHashTime(event *Event, took time.Duration) {
+	s.slowest.Add(event.Path, took.Seconds())
+	if s.config.IncludeReadThroughput && len(event.Hashes) > 0 && took > 0 {
+		event.ReadThroughputMBps = float64(event.Info.Size) / 1e6 / took.Seconds()
+	}
+}
+
+// classify looks up the reputation of the hashed file and quarantines it if
+// configured.
+func (s *scanner) classify(event *Event) {
+	reputation := s.lookupReputation(event)
+	if s.config.Quarantine.shouldQuarantine(reputation) {
+		dst, err := quarantineFile(event, s.config.Quarantine.Path)
+		if err != nil {
+			s.log.Warnw("Failed to quarantine file", "file_path", event.Path,
+				"reputation", reputation, "error", err)
+			event.errors = append(event.errors, err)
+		} else {
+			s.log.Infow("Quarantined file", "file_path", event.Path,
+				"reputation", reputation, "quarantine_path", dst)
+			event.QuarantinePath = dst
+		}
+	}
+}
+
+// lookupReputation looks up the reputation of the hashed file and records it
+// in the event.
+func (s *scanner) lookupReputation(event *Event) Reputation {
+	if s.config.ReputationLookup == nil || len(event.Hashes) == 0 {
+		return NoReputation
+	}
+
+	reputation, err := s.config.ReputationLookup.Lookup(event.Hashes)
+	if err != nil {
+		event.errors = append(event.errors, errors.Wrap(err, "failed to lookup reputation"))
+	}
+	event.Reputation = reputation
+	return reputation
+}
+
+// trustedHashes returns the persisted hashes and combined hash of the file if
+// TrustMtime is enabled and the file's metadata indicates it has not changed
+// since. It returns nil if the file must be hashed.
+func (s *scanner) trustedHashes(event *Event) (map[HashType]Digest, Digest) {
+	if !s.config.TrustMtime || s.config.State == nil || event.FutureMTime {
+		return nil, nil
+	}
+
+	last, err := s.config.State.Load(event.Path)
+	if err != nil {
+		s.log.Debugw("Failed to load persisted state", "file_path", event.Path, "error", err)
+		return nil, nil
+	}
+	if last == nil || last.Info == nil {
+		return nil, nil
+	}
+
+	o, n := last.Info, event.Info
+	if o.Type != n.Type || o.Inode != n.Inode || o.Size != n.Size ||
+		!o.MTime.Equal(n.MTime) || !o.CTime.Equal(n.CTime) {
+		return nil, nil
+	}
+
+	// A persisted mtime in the future is not trusted either.
+	if o.MTime.After(time.Now().Add(s.config.FutureMtimeTolerance)) {
+		return nil, nil
+	}
+
+	// A metadata change also changes the ctime so the persisted combined
+	// hash is still valid.
+	if s.config.CombinedHash != "" && len(last.CombinedHash) == 0 {
+		return nil, nil
+	}
+
+	hashes := make(map[HashType]Digest, len(s.config.HashTypes))
+	for _, hashType := range s.config.HashTypes {
+		digest, found := last.Hashes[hashType]
+		if !found {
+			return nil, nil
+		}
+		hashes[hashType] = digest
+	}
+	return hashes, last.CombinedHash
+}
+
+// hashFile computes the configured hashes and the combined hash of the file
+// and returns the time taken to read it. If reading the file is not permitted
+// and PrivilegedReads is enabled, the file is read again with
+// CAP_DAC_READ_SEARCH when the process is permitted to use it.
+func (s *scanner) hashFile(path string, info *Metadata) (map[HashType]Digest, Digest, time.Duration, error) {
+	hashes, combined, took, err := s.readHashes(path, info)
+	if err == nil || !s.config.PrivilegedReads || !isPermissionError(err) {
+		return hashes, combined, took, err
+	}
+
+	if perr := withReadCapability(func() { hashes, combined, took, err = s.readHashes(path, info) }); perr != nil {
+		s.log.Debugw("Privileged read is not possible", "file_path", path, "error", perr)
+	}
+	return hashes, combined, took, err
+}
+
+// readHashes computes the configured hashes and the combined hash of the file
+// and returns the time taken to read it. When DoubleRead is enabled the file
+// is hashed twice and errUnstableRead is returned if the results differ. With
+// ResumableHashing the hashing may be spread over multiple scans.
+func (s *scanner) readHashes(path string, info *Metadata) (map[HashType]Digest, Digest, time.Duration, error) {
+	size := info.Size
+	start := time.Now()
+	if store := s.partialHashStore(); store != nil {
+		hashes, combined, err := s.hashFileResumable(path, info, store)
+		return hashes, combined, sinceHashStart(start), err
+	}
+
+	hashes, combined, err := s.computeHashesCombined(path, info)
+	took := sinceHashStart(start)
+	if err != nil {
+		return nil, nil, took, err
+	}
+	if !s.config.DoubleRead ||
+		(s.config.DoubleReadMaxSizeBytes > 0 && size > s.config.DoubleReadMaxSizeBytes) {
+		return hashes, combined, took, nil
+	}
+
+	verify, err := s.computeHashes(path, size, nil)
+	if err != nil {
+		return nil, nil, took, err
+	}
+	for hashType, digest := range hashes {
+		if !bytes.Equal(digest, verify[hashType]) {
+			return nil, nil, took, errors.Wrapf(errUnstableRead, "%v mismatch (%v != %v)",
+				hashType, digest, verify[hashType])
+		}
+	}
+	return hashes, combined, took, nil
+}
+
+// computeHashesCombined computes the configured hashes of the file and, if
+// CombinedHash is set, its combined hash in the same pass.
+func (s *scanner) computeHashesCombined(path string, info *Metadata) (map[HashType]Digest, Digest, error) {
+	if s.config.CombinedHash == "" {
+		hashes, err := s.computeHashes(path, info.Size, nil)
+		return hashes, nil, err
+	}
+
+	combined, err := newCombinedHasher(s.config.CombinedHash, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes, err := s.computeHashes(path, info.Size, combined)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hashes, combined.digest(), nil
+}
+
+// computeHashes computes the configured hashes of the file. Large files are
+// hashed in parallel for hash types whose construction allows it. With the
+// parallel strategy each expensive hash is computed by its own pass over the
+// file. The contents are also written to combined unless it is nil.
+func (s *scanner) computeHashes(path string, size uint64, combined *combinedHasher) (map[HashType]Digest, error) {
+	deadline := s.readDeadline(size)
+
+	inline := s.config.HashTypes
+	var parallelBLAKE3 bool
+	if s.config.ParallelHashMinSizeBytes > 0 && size >= s.config.ParallelHashMinSizeBytes {
+		inline = make([]HashType, 0, len(s.config.HashTypes))
+		for _, hashType := range s.config.HashTypes {
+			if hashType == BLAKE3_256 {
+				parallelBLAKE3 = true
+				continue
+			}
+			inline = append(inline, hashType)
+		}
+	}
+
+	// Each deferred hash is computed by its own pass over the file.
+	var deferred []HashType
+	if s.config.ExpensiveHashes.Strategy == ExpensiveHashParallel {
+		inline, deferred = s.config.ExpensiveHashes.split(inline)
+	}
+
+	jobs := make([]hashJob, 0, 1+len(deferred))
+	var tee io.Writer
+	if combined != nil {
+		tee = combined
+	}
+	jobs = append(jobs, func() (map[HashType]Digest, error) {
+		defer s.limitExpensive(inline)()
+		return hashFileTee(s.openFile, path, deadline, tee, inline...)
+	})
+	for _, hashType := range deferred {
+		hashType := hashType
+		jobs = append(jobs, func() (map[HashType]Digest, error) {
+			defer s.limitExpensive([]HashType{hashType})()
+			return hashFileUntil(s.openFile, path, deadline, hashType)
+		})
+	}
+	hashes, err := s.runHashJobs(jobs)
+	if err != nil || !parallelBLAKE3 {
+		return hashes, err
+	}
+
+	release := s.limitExpensive([]HashType{BLAKE3_256})
+	digest, err := hashFileBLAKE3Parallel(s.openFile, path, runtime.NumCPU(), s.dumpRingOnPanic)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	// The parallel reads cannot be interrupted so the deadline is only
+	// enforced once they complete.
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		return nil, errReadTimeout
+	}
+	if hashes == nil {
+		hashes = map[HashType]Digest{}
+	}
+	hashes[BLAKE3_256] = digest
+	return hashes, nil
+}
+
+// readDeadline returns the time by which a file of the given size must be
+// hashed. It returns a zero time if no read timeout is configured.
+func (s *scanner) readDeadline(size uint64) time.Time {
+	timeout := s.config.FileReadTimeout
+	if rate := s.config.MinReadThroughputBytes; rate > 0 {
+		timeout += time.Duration(float64(size) / float64(rate) * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// hashContent returns true if the contents of the file described by info
+// are to be hashed. Only metadata is reported for other files.
+func (s *scanner) hashContent(path string, info *Metadata) bool {
+	if info == nil || info.Type != FileType || info.Size > s.config.MaxFileSizeBytes {
+		return false
+	}
+	if s.config.HashExecutablesOnly && !isExecutable(s.openFile, path, info) {
+		return false
+	}
+	return s.isHashable(path)
+}
+
+// isHashable returns false if the contents of the regular file at path must
+// not be read. Only metadata is reported for such files.
+func (s *scanner) isHashable(path string) bool {
+	if s.config.HashPseudoFilesystems {
+		return true
+	}
+
+	fsType, err := s.pseudoFilesystem(filepath.Dir(path))
+	if err != nil {
+		s.log.Debugw("Failed to determine filesystem type", "file_path", path, "error", err)
+		return true
+	}
+	if fsType != "" {
+		s.log.Debugw("Not hashing file on pseudo filesystem",
+			"file_path", path, "fs_type", fsType)
+		return false
+	}
+	return true
+}
+
+// pseudoFilesystem returns the name of the pseudo filesystem that the files in
+// dir reside on, or an empty string if it is not a pseudo filesystem. The
+// result for the last directory is cached because the files of a directory
+// are mostly visited one after another.
+func (s *scanner) pseudoFilesystem(dir string) (string, error) {
+	if s.fsTypeDir != "" && s.fsTypeDir == dir {
+		return s.fsType, nil
+	}
+	fsType, err := pseudoFilesystem(dir)
+	if err != nil {
+		return "", err
+	}
+	s.fsTypeDir, s.fsType = dir, fsType
+	return fsType, nil
+}
+
+// isPermissionError returns true if the cause of err is a permission error.
+func isPermissionError(err error) bool {
+	return os.IsPermission(errors.Cause(err))
 }
@@ -1,12 +1,25 @@
 package file_integrity
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/file"
+	"github.com/elastic/beats/libbeat/common/match"
 )
 
 func TestScanner(t *testing.T) {
@@ -103,6 +116,136 @@ func TestScanner(t *testing.T) {
 	})
 }
 
+func TestScannerDirRollup(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	config.DirRollup = true
+
+	var fileEvents int
+	rollups := map[string]*DirRollup{}
+	_, events := runScan(t, config)
+	for _, event := range events {
+		if event.Rollup != nil {
+			rollups[event.Path] = event.Rollup
+			continue
+		}
+		fileEvents++
+	}
+	assert.Equal(t, 7, fileEvents, "rollups must not replace the per-file events")
+
+	// Compute the expected values from the children of each directory.
+	expected := func(dir string) DirRollup {
+		var r DirRollup
+		children, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, child := range children {
+			r.ChildCount++
+			if child.Mode().IsRegular() {
+				r.TotalBytes += uint64(child.Size())
+			}
+			if mtime := child.ModTime().UTC(); mtime.After(r.NewestMTime) {
+				r.NewestMTime = mtime
+			}
+		}
+		return r
+	}
+
+	if !assert.Len(t, rollups, 2) {
+		return
+	}
+	for _, path := range []string{dir, filepath.Join(dir, "subdir")} {
+		rollup, found := rollups[path]
+		if !assert.True(t, found, "missing rollup for %v", path) {
+			continue
+		}
+		exp := expected(path)
+		assert.EqualValues(t, exp.ChildCount, rollup.ChildCount, path)
+		assert.EqualValues(t, exp.TotalBytes, rollup.TotalBytes, path)
+		assert.True(t, exp.NewestMTime.Equal(rollup.NewestMTime), path)
+		assert.Zero(t, rollup.PermissionViolations, path)
+	}
+	assert.EqualValues(t, 5, rollups[dir].ChildCount)
+	assert.EqualValues(t, 12, rollups[dir].TotalBytes)
+	assert.EqualValues(t, 1, rollups[filepath.Join(dir, "subdir")].ChildCount)
+}
+
+func TestScannerDirRollupUnreportedDirs(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// z is visited after the children of subdir, which itself is descended
+	// but not reported.
+	z := filepath.Join(dir, "z")
+	if err = ioutil.WriteFile(z, []byte("file z"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rollups := func(config Config) map[string]*DirRollup {
+		config.Paths = []string{dir}
+		config.Recursive = true
+		config.DirRollup = true
+
+		rollups := map[string]*DirRollup{}
+		_, events := runScan(t, config)
+		for _, event := range events {
+			if event.Rollup != nil {
+				rollups[event.Path] = event.Rollup
+			}
+		}
+		return rollups
+	}
+
+	t.Run("require_permissions", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("setgid is not supported on Windows")
+		}
+
+		for _, path := range []string{dir, filepath.Join(dir, "a"), filepath.Join(dir, "subdir", "c"), z} {
+			if err = os.Chmod(path, 0700|os.ModeSetgid); err != nil {
+				t.Fatal(err)
+			}
+		}
+		defer os.Chmod(dir, 0700)
+
+		config := defaultConfig
+		config.RequirePermissions = 02000
+		r := rollups(config)
+		if assert.Len(t, r, 1) && assert.Contains(t, r, dir) {
+			assert.EqualValues(t, 2, r[dir].ChildCount, "a and z")
+			assert.EqualValues(t, 12, r[dir].TotalBytes)
+		}
+	})
+
+	t.Run("non-pruning exclude", func(t *testing.T) {
+		config := defaultConfig
+		config.RootFilters = RootFilters{{
+			Path:         dir,
+			IncludeFiles: []match.Matcher{match.MustCompile(`^` + regexp.QuoteMeta(dir) + `(/(a|z|subdir/c))?$`)},
+		}}
+		r := rollups(config)
+		if assert.Len(t, r, 1) && assert.Contains(t, r, dir) {
+			assert.EqualValues(t, 2, r[dir].ChildCount, "a and z")
+			assert.EqualValues(t, 12, r[dir].TotalBytes)
+		}
+	})
+}
+
 func setupTestDir(t *testing.T) string {
 	dir, err := ioutil.TempDir("", "audit-file-scan")
 	if err != nil {
@@ -135,3 +278,962 @@ func setupTestDir(t *testing.T) string {
 
 	return dir
 }
+
+// scanEvents scans dir and returns the events by path.
+func scanEvents(t *testing.T, config Config, dir string) map[string]Event {
+	config.Paths = []string{dir}
+	_, list := runScan(t, config)
+
+	events := map[string]Event{}
+	for _, event := range list {
+		events[event.Path] = event
+	}
+	return events
+}
+
+// runScan scans the configured paths and returns the scanner, whose state
+// can be inspected after the scan, and the events in the order they were
+// emitted.
+func runScan(t *testing.T, config Config) (*scanner, []Event) {
+	var events []Event
+	s := consumeScan(t, config, func(event Event) {
+		events = append(events, event)
+	})
+	return s, events
+}
+
+// consumeScan scans the configured paths and passes each event to consume as
+// it is received.
+func consumeScan(t *testing.T, config Config, consume func(Event)) *scanner {
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	eventC, err := reader.Start(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for event := range eventC {
+		consume(event)
+	}
+	return reader.(*scanner)
+}
+
+func TestScannerEmitSkips(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"x.swp", "y.tmp"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"paths":         []string{dir},
+		"recursive":     true,
+		"emit_skips":    true,
+		"exclude_files": []string{`\.swp$`, `\.tmp$`, `/subdir$`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := defaultConfig
+	if err = config.Unpack(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	_, events := runScan(t, c)
+	skips := map[string]string{}
+	for _, event := range events {
+		if event.Skipped {
+			skips[filepath.Base(event.Path)] = event.MatchedRule
+		}
+		assert.NotEqual(t, "c", filepath.Base(event.Path), "excluded dir must not be traversed")
+	}
+
+	if !assert.Len(t, skips, 3) {
+		return
+	}
+	assert.Equal(t, "exclude_files[0]: "+c.ExcludeFiles[0].String(), skips["x.swp"])
+	assert.Equal(t, "exclude_files[1]: "+c.ExcludeFiles[1].String(), skips["y.tmp"])
+	assert.Equal(t, "exclude_files[2]: "+c.ExcludeFiles[2].String(), skips["subdir"])
+}
+
+func TestScannerIncludeParentDir(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	config.IncludeParentDir = true
+	if runtime.GOOS != "windows" {
+		// Scan only the filesystem root itself by excluding its children.
+		config.Paths = append(config.Paths, "/")
+		config.ExcludeFiles = []match.Matcher{match.MustCompile(`^/[^/]+$`)}
+	}
+
+	_, events := runScan(t, config)
+	parents := map[string]string{}
+	for _, event := range events {
+		parents[event.Path] = event.ParentDir
+	}
+
+	assert.Equal(t, filepath.Dir(dir), parents[dir], "configured root")
+	assert.Equal(t, dir, parents[filepath.Join(dir, "a")])
+	assert.Equal(t, dir, parents[filepath.Join(dir, "subdir")])
+	assert.Equal(t, filepath.Join(dir, "subdir"), parents[filepath.Join(dir, "subdir", "c")])
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, "/", parents["/"], "filesystem root")
+	}
+}
+
+func TestScannerRequirePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("setuid is not supported on Windows")
+	}
+
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setuidFiles := []string{filepath.Join(dir, "a"), filepath.Join(dir, "subdir", "c")}
+	for _, f := range setuidFiles {
+		if err = os.Chmod(f, 0755|os.ModeSetuid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Chmod(filepath.Join(dir, "b"), 0755|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	config.RequirePermissions = 04000
+
+	_, events := runScan(t, config)
+	var paths []string
+	for _, event := range events {
+		paths = append(paths, event.Path)
+		if assert.NotNil(t, event.Info) {
+			assert.True(t, event.Info.SetUID)
+		}
+	}
+	assert.Equal(t, setuidFiles, paths)
+}
+
+type mapStateStore map[string]*Event
+
+func (s mapStateStore) Load(path string) (*Event, error) {
+	return s[path], nil
+}
+
+func (s mapStateStore) Store(event *Event) error {
+	e := *event
+	s[event.Path] = &e
+	return nil
+}
+
+func TestScannerTrustMtime(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	futureFile := filepath.Join(dir, "b")
+	future := time.Now().Add(24 * time.Hour)
+	if err = os.Chtimes(futureFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is stored before the combined hash was configured.
+	noCombined := filepath.Join(dir, "d")
+	if err = ioutil.WriteFile(noCombined, []byte("file d"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.HashTypes = []HashType{SHA1}
+	config.CombinedHash = SHA1
+
+	// Persist the state of the first scan with hashes that do not match the
+	// file contents so that reused hashes can be detected.
+	bogus := Digest("bogus")
+	state := mapStateStore{}
+	for path, event := range scanEvents(t, config, dir) {
+		e := event
+		if e.Hashes != nil {
+			e.Hashes = map[HashType]Digest{SHA1: bogus}
+			e.CombinedHash = bogus
+		}
+		state[path] = &e
+	}
+	state[noCombined].CombinedHash = nil
+
+	config.TrustMtime = true
+	config.State = state
+	events := scanEvents(t, config, dir)
+
+	a := events[filepath.Join(dir, "a")]
+	assert.False(t, a.FutureMTime)
+	assert.Equal(t, bogus, a.Hashes[SHA1], "expected hash to be reused for unchanged file")
+	assert.Equal(t, bogus, a.CombinedHash, "expected combined hash to be reused for unchanged file")
+
+	c := events[noCombined]
+	assert.NotEqual(t, bogus, c.Hashes[SHA1], "expected file without combined hash to be hashed")
+	assert.NotEmpty(t, c.CombinedHash)
+
+	b := events[futureFile]
+	assert.True(t, b.FutureMTime, "expected future mtime to be flagged")
+	if assert.Contains(t, b.Hashes, SHA1) {
+		assert.NotEqual(t, bogus, b.Hashes[SHA1], "expected future-dated file to be hashed")
+	}
+}
+
+func TestScannerReadPermissionDenied(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions do not prevent reads on Windows")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("root can read files regardless of their permissions")
+	}
+
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unreadable := filepath.Join(dir, "a")
+	if err = os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	events := scanEvents(t, defaultConfig, dir)
+	e := events[unreadable]
+	assert.NotNil(t, e.Info, "metadata of the file must still be reported")
+	assert.True(t, e.ReadPermissionDenied)
+	assert.Empty(t, e.Hashes)
+	assert.False(t, events[filepath.Join(dir, "b")].ReadPermissionDenied)
+}
+
+func TestScannerPrivilegedReads(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail the first attempt to open the file like an unpermitted read.
+	protected := filepath.Join(dir, "a")
+	var opens int
+	openForHashing = func(name string) (*os.File, error) {
+		if name == protected {
+			if opens++; opens == 1 {
+				return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+			}
+		}
+		return file.ReadOpen(name)
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+
+	e := scanEvents(t, defaultConfig, dir)[protected]
+	assert.True(t, e.ReadPermissionDenied, "expected read_permission_denied")
+	assert.Empty(t, e.Hashes)
+	assert.Equal(t, 1, opens, "expected no privileged read by default")
+
+	if err = withReadCapability(func() {}); err != nil {
+		t.Skipf("privileged reads are not possible: %v", err)
+	}
+
+	opens = 0
+	config := defaultConfig
+	config.PrivilegedReads = true
+	e = scanEvents(t, config, dir)[protected]
+	assert.False(t, e.ReadPermissionDenied)
+	assert.NotEmpty(t, e.Hashes)
+	assert.Equal(t, 2, opens)
+}
+
+func TestScannerDigestPostProcessor(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	config := defaultConfig
+	config.HashTypes = []HashType{SHA256}
+	config.DigestPostProcessor = func(e *Event) {
+		calls++
+		if digest, found := e.Hashes[SHA256]; found {
+			e.Derived = common.MapStr{"hash.short_id": digest.String()[:12]}
+		}
+	}
+	events := scanEvents(t, config, dir)
+	assert.Equal(t, len(events), calls, "expected one call per event")
+
+	e := events[filepath.Join(dir, "a")]
+	if assert.NotNil(t, e.Derived) {
+		assert.Equal(t, e.Hashes[SHA256].String()[:12], e.Derived["hash.short_id"])
+	}
+	assert.Nil(t, events[dir].Derived)
+
+	ms := &MetricSet{config: config}
+	shortID, err := ms.buildEvent(&e, false).MetricSetFields.GetValue("hash.short_id")
+	if assert.NoError(t, err) {
+		assert.Equal(t, e.Derived["hash.short_id"], shortID)
+	}
+}
+
+func TestScannerSuppressHashes(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte("file a"))
+	config := defaultConfig
+	config.HashTypes = []HashType{SHA1, SHA256}
+	config.SuppressHashes = SuppressHashes{strings.ToUpper(hex.EncodeToString(sum[:]))}
+	if err = config.SuppressHashes.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := scanEvents(t, config, dir)
+	assert.True(t, events[filepath.Join(dir, "a")].Suppressed)
+	assert.False(t, events[filepath.Join(dir, "b")].Suppressed)
+	assert.False(t, events[filepath.Join(dir, "subdir")].Suppressed)
+
+	assert.Error(t, SuppressHashes{"not-hex"}.validate())
+}
+
+func TestScannerCombinedHash(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.HashTypes = []HashType{SHA256}
+	config.CombinedHash = SHA256
+
+	scan := func() Event {
+		_, events := runScan(t, config)
+		var a Event
+		for _, event := range events {
+			if event.Path == filepath.Join(dir, "a") {
+				a = event
+			}
+		}
+		return a
+	}
+
+	before := scan()
+	if assert.NotEmpty(t, before.CombinedHash) {
+		assert.NotEqual(t, before.Hashes[SHA256], before.CombinedHash,
+			"combined hash must cover more than the contents")
+	}
+	expected := sha256.Sum256(append([]byte("file a"), canonicalMetadata(before.Info)...))
+	assert.Equal(t, Digest(expected[:]), before.CombinedHash)
+
+	// The combined hash is computed by the pass that computes the hashes.
+	opens := map[string]int{}
+	openForHashing = func(name string) (*os.File, error) {
+		opens[name]++
+		return file.ReadOpen(name)
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+	scan()
+	assert.Equal(t, 1, opens[filepath.Join(dir, "a")], "expected the file to be read once")
+	assert.Equal(t, before.CombinedHash, scan().CombinedHash, "combined hash must be stable")
+
+	if err = os.Chmod(filepath.Join(dir, "a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after := scan()
+	assert.Equal(t, before.Hashes[SHA256], after.Hashes[SHA256])
+	assert.NotEqual(t, before.CombinedHash, after.CombinedHash,
+		"combined hash must change when the permissions change")
+
+	fields := buildMetricbeatEvent(&after, false).MetricSetFields
+	combined, err := fields.GetValue("hash.combined")
+	if assert.NoError(t, err) {
+		assert.Equal(t, after.CombinedHash, combined)
+	}
+}
+
+func TestScannerDepthStats(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories at depths 0 (dir), 1 (subdir), 2, and 3.
+	if err = os.MkdirAll(filepath.Join(dir, "subdir", "x", "y"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+
+	s, _ := runScan(t, config)
+	stats := s.depth
+	assert.Equal(t, 3, stats.max)
+	assert.EqualValues(t, 4, stats.count)
+	assert.Equal(t, 1.5, stats.avg())
+}
+
+func TestDepthStatsRoot(t *testing.T) {
+	var stats depthStats
+	root := string(filepath.Separator)
+	stats.add(root, root)
+	stats.add(root, filepath.Join(root, "a", "b"))
+	assert.Equal(t, 2, stats.max)
+	assert.Equal(t, 1.0, stats.avg())
+}
+
+func TestScannerReadTimeout(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small := filepath.Join(dir, "a")
+	fast := filepath.Join(dir, "fast")
+	slow := filepath.Join(dir, "slow")
+	for _, name := range []string{fast, slow} {
+		if err = ioutil.WriteFile(name, make([]byte, 200*1024), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Feed the slow file through a pipe at 200 KB/s so reading it takes a
+	// second.
+	openForHashing = func(name string) (*os.File, error) {
+		if name != slow {
+			return file.ReadOpen(name)
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			defer w.Close()
+			chunk := make([]byte, 10*1024)
+			for i := 0; i < 20; i++ {
+				time.Sleep(50 * time.Millisecond)
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+			}
+		}()
+		return r, nil
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+
+	// A 200 KiB file must be read within 100ms + 200ms.
+	config := defaultConfig
+	config.FileReadTimeout = 100 * time.Millisecond
+	config.MinReadThroughput = "1 MB"
+	config.MinReadThroughputBytes = 1000 * 1000
+
+	events := scanEvents(t, config, dir)
+
+	for _, name := range []string{small, fast} {
+		e := events[name]
+		assert.False(t, e.ReadTimedOut, "unexpected timeout for %v", name)
+		assert.NotEmpty(t, e.Hashes, "expected hashes for %v", name)
+	}
+
+	e := events[slow]
+	assert.True(t, e.ReadTimedOut, "expected slow read to time out")
+	assert.Nil(t, e.Hashes)
+	if assert.Len(t, e.errors, 1) {
+		assert.Equal(t, errReadTimeout, errors.Cause(e.errors[0]))
+	}
+}
+
+func TestScannerWarmCacheOnly(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := mapStateStore{}
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.HashTypes = []HashType{SHA1}
+	config.WarmCacheOnly = true
+	config.State = state
+
+	_, events := runScan(t, config)
+	assert.Empty(t, events)
+
+	a, found := state[filepath.Join(dir, "a")]
+	if assert.True(t, found, "expected file state to be stored") {
+		assert.Contains(t, a.Hashes, SHA1)
+	}
+	assert.Contains(t, state, dir)
+
+	// Without a state store there is nowhere to write the hashes.
+	config.State = nil
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	_, err = reader.Start(done)
+	assert.Error(t, err)
+}
+
+func TestScannerDoubleRead(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unstable := filepath.Join(dir, "a")
+	other := filepath.Join(dir, "b")
+	if err = ioutil.WriteFile(other, []byte("different bytes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Return different contents every second time the unstable file is read.
+	var reads int
+	openForHashing = func(name string) (*os.File, error) {
+		if name == unstable {
+			reads++
+			if reads%2 == 0 {
+				return file.ReadOpen(other)
+			}
+		}
+		return file.ReadOpen(name)
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+
+	config := defaultConfig
+	config.DoubleRead = true
+
+	events := scanEvents(t, config, dir)
+
+	e := events[unstable]
+	assert.True(t, e.UnstableRead, "expected unstable read to be reported")
+	assert.Nil(t, e.Hashes, "expected no hashes to be recorded")
+	if assert.Len(t, e.errors, 1) {
+		assert.Equal(t, errUnstableRead, errors.Cause(e.errors[0]))
+	}
+	assert.Equal(t, 2, reads)
+
+	e = events[other]
+	assert.False(t, e.UnstableRead)
+	assert.NotEmpty(t, e.Hashes)
+}
+
+func TestScannerExcludeAtimeOlderThan(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	old := now.Add(-30 * 24 * time.Hour)
+	dormant := []string{filepath.Join(dir, "a"), filepath.Join(dir, "subdir", "c")}
+	for _, f := range dormant {
+		if err = os.Chtimes(f, old, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	active := filepath.Join(dir, "b")
+	if err = os.Chtimes(active, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Recursive = true
+	config.ExcludeAtimeOlderThan = 7 * 24 * time.Hour
+	config.EmitSkips = true
+
+	events := scanEvents(t, config, dir)
+
+	for _, f := range dormant {
+		if e, found := events[f]; assert.True(t, found, f) {
+			assert.True(t, e.Skipped, "expected %v to be skipped", f)
+			assert.Contains(t, e.MatchedRule, "exclude_atime_older_than")
+			assert.Nil(t, e.Hashes)
+		}
+	}
+	if e, found := events[active]; assert.True(t, found) {
+		assert.False(t, e.Skipped)
+		assert.NotEmpty(t, e.Hashes)
+	}
+	if e, found := events[filepath.Join(dir, "subdir")]; assert.True(t, found) {
+		assert.False(t, e.Skipped, "directories are not subject to the atime rule")
+	}
+}
+
+func TestScannerReadThroughput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 MB read in 2 seconds.
+	f := filepath.Join(dir, "data")
+	if err = ioutil.WriteFile(f, make([]byte, 3000000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sinceHashStart = func(time.Time) time.Duration { return 2 * time.Second }
+	defer func() { sinceHashStart = time.Since }()
+
+	config := defaultConfig
+	config.IncludeReadThroughput = true
+
+	events := scanEvents(t, config, dir)
+
+	e := events[f]
+	assert.Equal(t, 1.5, e.ReadThroughputMBps)
+	assert.Zero(t, events[dir].ReadThroughputMBps, "directories are not read")
+
+	fields := buildMetricbeatEvent(&e, false).MetricSetFields
+	assert.Equal(t, 1.5, fields["file"].(common.MapStr)["read_throughput_mbps"])
+}
+
+func TestScannerVanishedFiles(t *testing.T) {
+	scan := func(t *testing.T, policy string, reappear bool) (map[string]Event, string) {
+		dir := setupTestDir(t)
+		defer os.RemoveAll(dir)
+
+		dir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Delete the file after it was enumerated but before it is hashed.
+		vanishing := filepath.Join(dir, "a")
+		var once bool
+		openForHashing = func(name string) (*os.File, error) {
+			if name == vanishing && !once {
+				once = true
+				if err := os.Remove(name); err != nil {
+					t.Fatal(err)
+				}
+				if reappear {
+					if err := ioutil.WriteFile(name, []byte("new file a"), 0600); err != nil {
+						t.Fatal(err)
+					}
+					// The first open still sees the file as missing.
+					return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+				}
+			}
+			return file.ReadOpen(name)
+		}
+		defer func() { openForHashing = file.ReadOpen }()
+
+		config := defaultConfig
+		config.VanishedFiles = policy
+		config.VanishedRetryDelay = time.Millisecond
+
+		events := scanEvents(t, config, dir)
+		assert.True(t, once, "file was not hashed")
+		return events, vanishing
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		events, vanishing := scan(t, VanishedSkip, false)
+		assert.NotContains(t, events, vanishing)
+		assert.Len(t, events, 5)
+	})
+
+	t.Run("emit", func(t *testing.T) {
+		events, vanishing := scan(t, VanishedEmit, false)
+		if e, found := events[vanishing]; assert.True(t, found) {
+			assert.True(t, e.Vanished)
+			assert.Nil(t, e.Info)
+			assert.Zero(t, e.Action, "vanished is not a deletion")
+
+			fields := buildMetricbeatEvent(&e, false).MetricSetFields
+			assert.Equal(t, true, fields["file"].(common.MapStr)["vanished"])
+		}
+	})
+
+	t.Run("retry vanished", func(t *testing.T) {
+		events, vanishing := scan(t, VanishedRetry, false)
+		if e, found := events[vanishing]; assert.True(t, found) {
+			assert.True(t, e.Vanished)
+		}
+	})
+
+	t.Run("retry reappeared", func(t *testing.T) {
+		events, vanishing := scan(t, VanishedRetry, true)
+		if e, found := events[vanishing]; assert.True(t, found) {
+			assert.False(t, e.Vanished)
+			assert.NotNil(t, e.Info)
+			assert.Contains(t, e.Hashes, SHA1)
+		}
+	})
+}
+
+func TestScannerVanishedDuringWalk(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove b after the walk listed it, while a is hashed, so that the walk
+	// fails to lstat it. It reappears before it is checked again.
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	openForHashing = func(name string) (*os.File, error) {
+		if name == a {
+			if err := os.Remove(b); err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+		}
+		return file.ReadOpen(name)
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+	lstatVanished = func(name string) (os.FileInfo, error) {
+		if name == b {
+			if err := ioutil.WriteFile(b, []byte("new file b"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return os.Lstat(name)
+	}
+	defer func() { lstatVanished = os.Lstat }()
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.VanishedFiles = VanishedRetry
+	config.VanishedRetryDelay = time.Millisecond
+	config.DirRollup = true
+
+	s, events := runScan(t, config)
+	var reappeared *Event
+	var rollup *DirRollup
+	for i, e := range events {
+		if e.Path == b {
+			reappeared = &events[i]
+		}
+		if e.Path == dir && e.Rollup != nil {
+			rollup = e.Rollup
+		}
+	}
+	if assert.NotNil(t, reappeared) {
+		assert.False(t, reappeared.Vanished)
+		assert.Contains(t, reappeared.Hashes, SHA1)
+	}
+
+	// The reappeared file is counted once and is a child of its directory.
+	assert.EqualValues(t, len(events)-1, s.fileCount, "every event but the rollup is counted")
+	if assert.NotNil(t, rollup) {
+		assert.EqualValues(t, 5, rollup.ChildCount)
+	}
+}
+
+func TestScannerSampling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numFiles = 1000
+	big := filepath.Join(dir, "big")
+	if err = os.Mkdir(big, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < numFiles; i++ {
+		if err = ioutil.WriteFile(filepath.Join(big, strconv.Itoa(i)), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	small := filepath.Join(dir, "small")
+	if err = ioutil.WriteFile(small, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(seed uint64) map[string]bool {
+		config := defaultConfig
+		config.Paths = []string{dir}
+		config.Recursive = true
+		config.Sampling = SamplingConfig{
+			Seed:        seed,
+			Directories: []SampleDirectory{{Path: big, Rate: 0.1}},
+		}
+
+		_, events := runScan(t, config)
+		paths := map[string]bool{}
+		for _, event := range events {
+			paths[event.Path] = true
+		}
+		return paths
+	}
+
+	sampled := func(paths map[string]bool) int {
+		var n int
+		for p := range paths {
+			if filepath.Dir(p) == big {
+				n++
+			}
+		}
+		return n
+	}
+
+	first := scan(1)
+	assert.True(t, first[dir])
+	assert.True(t, first[big], "sampled directory must be reported")
+	assert.True(t, first[small], "files outside of sampled directories must be reported")
+	n := sampled(first)
+	assert.True(t, n > numFiles*0.1*0.7 && n < numFiles*0.1*1.3, "sampled %d of %d files", n, numFiles)
+
+	assert.Equal(t, first, scan(1), "sample must be stable for the same seed")
+	assert.NotEqual(t, first, scan(2), "sample must change with the seed")
+}
+
+func TestSamplingConfigRate(t *testing.T) {
+	c := SamplingConfig{Directories: []SampleDirectory{
+		{Path: "/a", Rate: 0.5},
+		{Path: "/a/b", Rate: 0.1},
+	}}
+	assert.Equal(t, 1.0, c.rate("/a"))
+	assert.Equal(t, 0.5, c.rate("/a/x"))
+	assert.Equal(t, 0.1, c.rate("/a/b/x"))
+	assert.Equal(t, 1.0, c.rate("/ab/x"))
+	assert.Equal(t, 1.0, c.rate("/c/x"))
+}
+
+func TestScannerDanglingSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dangling := filepath.Join(dir, "dangling")
+	target := "/nonexistent/" + strings.Repeat("x", 100) + "/shadow"
+	if err = os.Symlink(target, dangling); err != nil {
+		t.Fatal(err)
+	}
+
+	// A configured path that is a dangling symlink.
+	root := filepath.Join(dir, "dangling_root")
+	if err = os.Symlink(filepath.Join(dir, "missing"), root); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(c Config) map[string]Event {
+		_, list := runScan(t, c)
+		events := map[string]Event{}
+		for _, event := range list {
+			if _, found := events[event.Path]; !found {
+				events[event.Path] = event
+			}
+		}
+		return events
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir, root}
+	events := scan(config)
+
+	if e, found := events[dangling]; assert.True(t, found) {
+		assert.Equal(t, SymlinkType, e.Info.Type)
+		assert.True(t, e.Dangling)
+		assert.Equal(t, target, e.TargetPath)
+		assert.False(t, e.TargetPathTruncated)
+
+		fields := buildMetricbeatEvent(&e, false).MetricSetFields
+		assert.Equal(t, true, fields["file"].(common.MapStr)["dangling"])
+	}
+	if e, found := events[root]; assert.True(t, found, "dangling configured path") {
+		assert.True(t, e.Dangling)
+		assert.Equal(t, filepath.Join(dir, "missing"), e.TargetPath)
+	}
+	if e, found := events[filepath.Join(dir, "link_to_b")]; assert.True(t, found) {
+		assert.False(t, e.Dangling)
+		assert.Equal(t, filepath.Join(dir, "b"), e.TargetPath)
+	}
+
+	config.MaxSymlinkTargetLength = 20
+	events = scan(config)
+	if e, found := events[dangling]; assert.True(t, found) {
+		assert.Equal(t, target[:20], e.TargetPath)
+		assert.True(t, e.TargetPathTruncated)
+	}
+}
@@ -0,0 +1,35 @@
+package file_integrity
+
+import (
+	"os"
+	"time"
+)
+
+// ScanWindow is the state of a file before and after the scanner hashed it.
+// The pre-hash values are the ones in the event's metadata.
+type ScanWindow struct {
+	PreMTime  time.Time `json:"pre_mtime"`
+	PostMTime time.Time `json:"post_mtime"`
+	PreSize   uint64    `json:"pre_size"`
+	PostSize  uint64    `json:"post_size"`
+}
+
+// restat stats the file again after it was hashed and records whether its
+// mtime or size changed in the meantime, in which case the hashes may not
+// correspond to any state the file was in.
+func (s *scanner) restat(event *Event) {
+	info, err := os.Lstat(event.Path)
+	if err != nil {
+		s.log.Debugw("Failed to stat file after hashing", "file_path", event.Path, "error", err)
+		return
+	}
+
+	event.ScanWindow = &ScanWindow{
+		PreMTime:  event.Info.MTime,
+		PostMTime: info.ModTime().UTC(),
+		PreSize:   event.Info.Size,
+		PostSize:  uint64(info.Size()),
+	}
+	event.ChangedDuringScan = !event.ScanWindow.PreMTime.Equal(event.ScanWindow.PostMTime) ||
+		event.ScanWindow.PreSize != event.ScanWindow.PostSize
+}
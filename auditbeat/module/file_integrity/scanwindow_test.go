@@ -0,0 +1,71 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common/file"
+)
+
+func TestScannerRestatAfterHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-file-restat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preMTime := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	postMTime := preMTime.Add(time.Hour)
+	changing, stable := filepath.Join(dir, "changing"), filepath.Join(dir, "stable")
+	for _, name := range []string{changing, stable} {
+		if err = ioutil.WriteFile(name, []byte("abc"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(name, preMTime, preMTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The changing file is appended to while it is being hashed.
+	openForHashing = func(name string) (*os.File, error) {
+		f, err := file.ReadOpen(name)
+		if err != nil || name != changing {
+			return f, err
+		}
+		if err = ioutil.WriteFile(name, []byte("abcdef"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(name, postMTime, postMTime); err != nil {
+			t.Fatal(err)
+		}
+		return f, nil
+	}
+	defer func() { openForHashing = file.ReadOpen }()
+
+	config := defaultConfig
+	config.RestatAfterHash = true
+	events := scanEvents(t, config, dir)
+
+	e := events[changing]
+	assert.True(t, e.ChangedDuringScan)
+	assert.Equal(t, &ScanWindow{PreMTime: preMTime, PostMTime: postMTime, PreSize: 3, PostSize: 6}, e.ScanWindow)
+
+	e = events[stable]
+	assert.False(t, e.ChangedDuringScan)
+	assert.Equal(t, &ScanWindow{PreMTime: preMTime, PostMTime: preMTime, PreSize: 3, PostSize: 3}, e.ScanWindow)
+	assert.Nil(t, events[dir].ScanWindow, "directories are not hashed")
+
+	// Files are not stat'ed again by default.
+	config.RestatAfterHash = false
+	assert.Nil(t, scanEvents(t, config, dir)[stable].ScanWindow)
+}
@@ -96,8 +96,49 @@ func (rcv *Event) Hashes(obj *Hash) *Hash {
 	return nil
 }
 
+func (rcv *Event) DeviceMajor() uint32 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.GetUint32(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *Event) MutateDeviceMajor(n uint32) bool {
+	return rcv._tab.MutateUint32Slot(16, n)
+}
+
+func (rcv *Event) DeviceMinor() uint32 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
+	if o != 0 {
+		return rcv._tab.GetUint32(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *Event) MutateDeviceMinor(n uint32) bool {
+	return rcv._tab.MutateUint32Slot(18, n)
+}
+
+func (rcv *Event) CombinedHash(j int) int8 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		a := rcv._tab.Vector(o)
+		return rcv._tab.GetInt8(a + flatbuffers.UOffsetT(j*1))
+	}
+	return 0
+}
+
+func (rcv *Event) CombinedHashLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
 func EventStart(builder *flatbuffers.Builder) {
-	builder.StartObject(6)
+	builder.StartObject(9)
 }
 func EventAddTimestampNs(builder *flatbuffers.Builder, timestampNs int64) {
 	builder.PrependInt64Slot(0, timestampNs, 0)
@@ -117,6 +158,18 @@ func EventAddInfo(builder *flatbuffers.Builder, info flatbuffers.UOffsetT) {
 func EventAddHashes(builder *flatbuffers.Builder, hashes flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(5, flatbuffers.UOffsetT(hashes), 0)
 }
+func EventAddDeviceMajor(builder *flatbuffers.Builder, deviceMajor uint32) {
+	builder.PrependUint32Slot(6, deviceMajor, 0)
+}
+func EventAddDeviceMinor(builder *flatbuffers.Builder, deviceMinor uint32) {
+	builder.PrependUint32Slot(7, deviceMinor, 0)
+}
+func EventAddCombinedHash(builder *flatbuffers.Builder, combinedHash flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(8, flatbuffers.UOffsetT(combinedHash), 0)
+}
+func EventStartCombinedHashVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(1, numElems, 1)
+}
 func EventEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
@@ -3,15 +3,19 @@
 package schema
 
 const (
-	TypeUnknown = 0
-	TypeFile    = 1
-	TypeDir     = 2
-	TypeSymlink = 3
+	TypeUnknown     = 0
+	TypeFile        = 1
+	TypeDir         = 2
+	TypeSymlink     = 3
+	TypeCharDevice  = 4
+	TypeBlockDevice = 5
 )
 
 var EnumNamesType = map[int]string{
-	TypeUnknown: "Unknown",
-	TypeFile:    "File",
-	TypeDir:     "Dir",
-	TypeSymlink: "Symlink",
+	TypeUnknown:     "Unknown",
+	TypeFile:        "File",
+	TypeDir:         "Dir",
+	TypeSymlink:     "Symlink",
+	TypeCharDevice:  "CharDevice",
+	TypeBlockDevice: "BlockDevice",
 }
@@ -0,0 +1,123 @@
+package file_integrity
+
+import (
+	"encoding/hex"
+	"hash"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+)
+
+// selfTestInputs are hashed by the self-test. The second input is the one
+// used by the BLAKE3 test vectors and spans multiple blocks of every hash.
+var selfTestInputs = [][]byte{[]byte("abc"), blake3VectorInput(1025)}
+
+// selfTestVectors are the expected hex encoded digests of each of the
+// selfTestInputs.
+var selfTestVectors = map[HashType][]string{
+	BLAKE2B_256: {
+		"bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+		"533c8d76c0f61487431e7c31d15417c8b53887e4765b5597d0d03cb085014afb",
+	},
+	BLAKE2B_384: {
+		"6f56a82c8e7ef526dfe182eb5212f7db9df1317e57815dbda46083fc30f54ee6c66ba83be64b302d7cba6ce15bb556f4",
+		"d0d359b37ec961d6637f2541a3d42dc4d4ea08ac30a51b4aa48d5b2f0a47c18d6d138f5ea31802eb0eca5c40704a6632",
+	},
+	BLAKE2B_512: {
+		"ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+		"7a9e5283a15d13b995755360fde4c65c2ae1bc0cf33e8db2ce8416e5d10697c73fc4b2622a29b938a1faec43d931b02e71ad8635e071265633643a9d9396ec28",
+	},
+	BLAKE3_256: {
+		"6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+		"d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444",
+	},
+	MD5: {
+		"900150983cd24fb0d6963f7d28e17f72",
+		"3f3789452b88cb32b8cbfbafe715e29a",
+	},
+	SHA1: {
+		"a9993e364706816aba3e25717850c26c9cd0d89d",
+		"ca9fdc040579afc74c0e6314fee7af12bd5c4284",
+	},
+	SHA224: {
+		"23097d223405d8228642a477bda255b32aadbce4bda0b3f7e36c9da7",
+		"614b5e145a54576f471b21e207a4f91e70d6db7c668471da7f96eda3",
+	},
+	SHA256: {
+		"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"bc0b6b10b89b9487a12fda2a8cc13194e7091c217aabf8b92846274026f4bcd0",
+	},
+	SHA384: {
+		"cb00753f45a35e8bb5a03d699ac65007272c32ab0eded1631a8b605a43ff5bed8086072ba1e7cc2358baeca134c825a7",
+		"fde60c0157845cf167a59164fd4d47085b967721548e1580428dd54161e2d359db5981f758998e8b0e933115cd7ce811",
+	},
+	SHA512: {
+		"ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		"1f0cb287c12671e2f498170ff2762886686ceb88b7d63f944708d3060752376ff38e4a88ab7ceb0bb437083e7f1d051049b8d94356e72e4d59adcc102f585ac0",
+	},
+	SHA512_224: {
+		"4634270f707b6a54daae7530460842e20e37ed265ceee9a43e8924aa",
+		"7ebb5055a99d2a3b8e528d01210cc6c35398e5bec07882d884b978ee",
+	},
+	SHA512_256: {
+		"53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23",
+		"55d00c70e6bed390e0b965e7a06a675062c4f057e6121eabbba4310dba0d6a27",
+	},
+	SHA3_224: {
+		"e642824c3f8cf24ad09234ee7d3c766fc9a3a5168d0c94ad73b46fdf",
+		"faa2566329eef816510312ec08e329cdc855e96a440e47e92da4cc92",
+	},
+	SHA3_256: {
+		"3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+		"413cf357775aef534fcd49da91a30f7877b50bbd924a20649315a4827f79cac0",
+	},
+	SHA3_384: {
+		"ec01498288516fc926459f58e2c6ad8df9b473cb0fc08c2596da7cf0e49be4b298d88cea927ac7f539f1edf228376d25",
+		"d00dfd2110cb761ca3f3f3195037e89b29660805e6ce37e9f3164a22517e1c1ac937adbd38c78ad568ada4340f039e58",
+	},
+	SHA3_512: {
+		"b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0",
+		"4d77323a341a3c8edd736ed718cb07deb66bcc5317dbc6f73da4c686dcec8440e414a2ed46ed5219cc226160b2416b276d9d9d95ad83c4c9e301397c89e37864",
+	},
+}
+
+// selfTestNewHashes creates the hashes tested by the scanner's self-test. It
+// is a variable so that tests can inject broken hashes.
+var selfTestNewHashes = newHashes
+
+// blake3VectorInput returns the n byte input of the BLAKE3 test vectors.
+func blake3VectorInput(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// selfTest hashes the selfTestInputs with each of the hash types using
+// hashes created by newHashes and compares the digests to the selfTestVectors.
+// It returns an error for each hash type that produced a wrong digest.
+func selfTest(hashTypes []HashType, newHashes func([]HashType) ([]hash.Hash, error)) error {
+	var errs multierror.Errors
+	for _, hashType := range hashTypes {
+		expected, found := selfTestVectors[hashType]
+		if !found {
+			errs = append(errs, errors.Errorf("no self-test vectors for hash type '%v'", hashType))
+			continue
+		}
+		for i, input := range selfTestInputs {
+			hashes, err := newHashes([]HashType{hashType})
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "self-test of %v failed", hashType))
+				break
+			}
+			hashes[0].Write(input)
+			if digest := hex.EncodeToString(hashes[0].Sum(nil)); digest != expected[i] {
+				errs = append(errs, errors.Errorf("self-test of %v failed: digest of input %d is %v but expected %v",
+					hashType, i, digest, expected[i]))
+				break
+			}
+		}
+	}
+	return errs.Err()
+}
@@ -0,0 +1,85 @@
+package file_integrity
+
+import (
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tamperedHash produces wrong digests by hashing an extra byte.
+type tamperedHash struct {
+	hash.Hash
+}
+
+func (h tamperedHash) Sum(b []byte) []byte {
+	h.Hash.Write([]byte{0})
+	return h.Hash.Sum(b)
+}
+
+// tamperedHashes returns newHashes that produces wrong digests for hashType.
+func tamperedHashes(hashType HashType) func([]HashType) ([]hash.Hash, error) {
+	return func(hashTypes []HashType) ([]hash.Hash, error) {
+		hashes, err := newHashes(hashTypes)
+		if err == nil && hashTypes[0] == hashType {
+			hashes[0] = tamperedHash{hashes[0]}
+		}
+		return hashes, err
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	assert.NoError(t, selfTest(validHashes, newHashes))
+	for _, hashType := range validHashes {
+		assert.Len(t, selfTestVectors[hashType], len(selfTestInputs), "vectors of %v", hashType)
+	}
+
+	err := selfTest([]HashType{SHA1, SHA256}, tamperedHashes(SHA256))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "self-test of sha256 failed")
+		assert.NotContains(t, err.Error(), "sha1")
+	}
+}
+
+func TestScannerSelfTest(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	config := defaultConfig
+	config.HashTypes = []HashType{SHA1, BLAKE3_256}
+	config.CombinedHash = SHA256
+	config.SelfTest = true
+
+	// The scan runs when the self-test passes.
+	events := scanEvents(t, config, dir)
+	assert.Len(t, events, 6)
+	for _, e := range events {
+		assert.Empty(t, e.errors, e.Path)
+	}
+	e := events[filepath.Join(dir, "a")]
+	assert.Len(t, e.Hashes, 2)
+	assert.NotEmpty(t, e.CombinedHash)
+
+	// The scanner does not start when one of the hash types, including the
+	// combined hash, fails the self-test.
+	defer func() { selfTestNewHashes = newHashes }()
+	for _, hashType := range []HashType{BLAKE3_256, SHA256} {
+		selfTestNewHashes = tamperedHashes(hashType)
+
+		reader, err := NewFileSystemScanner(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = reader.Start(make(chan struct{}))
+		if assert.Error(t, err, string(hashType)) {
+			assert.Contains(t, err.Error(), "hash self-test failed")
+			assert.Contains(t, err.Error(), "self-test of "+string(hashType)+" failed")
+		}
+	}
+
+	// The self-test is only run if enabled.
+	config.SelfTest = false
+	assert.Len(t, scanEvents(t, config, dir), 6)
+}
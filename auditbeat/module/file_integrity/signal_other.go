@@ -0,0 +1,10 @@
+// +build !linux,!freebsd,!openbsd,!netbsd,!darwin
+
+package file_integrity
+
+import "github.com/pkg/errors"
+
+// notifyPauseSignals is not supported on this platform.
+func notifyPauseSignals(p Pausable) (func(), error) {
+	return nil, errors.New("signal_control is not supported on this platform")
+}
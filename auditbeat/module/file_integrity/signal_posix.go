@@ -0,0 +1,38 @@
+// +build linux freebsd openbsd netbsd darwin
+
+package file_integrity
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyPauseSignals pauses p on SIGUSR1 and resumes it on SIGUSR2 until the
+// returned function is called.
+func notifyPauseSignals(p Pausable) (func(), error) {
+	sigC := make(chan os.Signal, 1)
+	stopC := make(chan struct{})
+	signal.Notify(sigC, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigC:
+				switch sig {
+				case syscall.SIGUSR1:
+					p.Pause()
+				case syscall.SIGUSR2:
+					p.Resume()
+				}
+			case <-stopC:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigC)
+		close(stopC)
+	}, nil
+}
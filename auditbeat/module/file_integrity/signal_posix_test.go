@@ -0,0 +1,93 @@
+// +build linux freebsd openbsd netbsd darwin
+
+package file_integrity
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerSignalControl(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	config.SignalControl = true
+
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := reader.(*scanner)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	eventC, err := reader.Start(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one scanner can handle the signals.
+	other, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Start(done)
+	assert.Equal(t, errSignalControlInUse, err)
+
+	paused := func() bool {
+		s.pauseMu.Lock()
+		defer s.pauseMu.Unlock()
+		return s.resumeC != nil
+	}
+	waitFor := func(cond func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout")
+			}
+		}
+	}
+
+	count := 0
+	<-eventC
+	count++
+
+	if err = syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(paused)
+
+	// Drain the events that were in flight when the scan was paused.
+	for drained := false; !drained; {
+		select {
+		case _, ok := <-eventC:
+			if !ok {
+				t.Fatal("scan completed while paused")
+			}
+			count++
+		case <-time.After(200 * time.Millisecond):
+			drained = true
+		}
+	}
+	assert.True(t, count < 7, "scan did not pause")
+
+	if err = syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	for range eventC {
+		count++
+	}
+	assert.Equal(t, 7, count)
+	assert.False(t, paused())
+
+	// Signal control is released when the scan completes.
+	waitFor(func() bool { return atomic.LoadInt32(&signalControlEnabled) == 0 })
+}
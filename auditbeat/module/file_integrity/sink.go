@@ -0,0 +1,171 @@
+package file_integrity
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const defaultSinkBufferSize = 1000
+
+// EventSink receives events from the scanner in addition to its event
+// channel (e.g. to forward high-value events to an alerting system). Each sink
+// is sent its events by a goroutine of its own so that a slow sink does not
+// delay the scan. Events that do not fit into the sink's buffer are dropped.
+// A completed scan ends once the buffered events were delivered. The fields in
+// RedactFields are redacted in the events sent to sinks like in published
+// events, which requires that RedactFields only lists fields that redactEvent
+// supports.
+type EventSink interface {
+	// Send delivers the event. An error is logged and counted but neither
+	// stops the scan nor the delivery to other sinks. The event must not be
+	// modified.
+	Send(event Event) error
+}
+
+// SinkConfig registers an EventSink with the scanner.
+type SinkConfig struct {
+	Name       string            // Identifies the sink in logs and the scan summary.
+	Sink       EventSink         // Sink that the events are delivered to.
+	Filter     func(*Event) bool // Selects the events delivered to the sink. It is given the unredacted event and must not modify it. Nil selects all events.
+	BufferSize int               // Number of events buffered while the sink is busy. Zero selects the default of 1000.
+}
+
+// sinkStats counts the events delivered to a sink. The counters are updated
+// atomically.
+type sinkStats struct {
+	Name    string `json:"name"`
+	Sent    uint64 `json:"sent"`
+	Errors  uint64 `json:"errors"`
+	Dropped uint64 `json:"dropped"` // Events not delivered because the buffer was full.
+}
+
+// startSinks starts a goroutine for each sink that delivers the events that
+// fanOut buffers for it.
+func (s *scanner) startSinks() {
+	s.sinkQueues = make([]chan Event, len(s.config.Sinks))
+	for i, sink := range s.config.Sinks {
+		size := sink.BufferSize
+		if size <= 0 {
+			size = defaultSinkBufferSize
+		}
+		s.sinkQueues[i] = make(chan Event, size)
+
+		s.sinksDone.Add(1)
+		go func(sink SinkConfig, stats *sinkStats, queue <-chan Event) {
+			defer s.sinksDone.Done()
+			for event := range queue {
+				if err := send(sink, event); err != nil {
+					atomic.AddUint64(&stats.Errors, 1)
+					s.log.Warnw("Failed to send event to sink", "sink", stats.Name,
+						"file_path", event.Path, "error", err)
+					continue
+				}
+				atomic.AddUint64(&stats.Sent, 1)
+			}
+		}(sink, &s.sinks[i], s.sinkQueues[i])
+	}
+}
+
+// stopSinks waits until the sinks delivered their buffered events or the
+// scanner is stopped.
+func (s *scanner) stopSinks() {
+	for _, queue := range s.sinkQueues {
+		close(queue)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.sinksDone.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-s.done:
+	}
+}
+
+// fanOut buffers the event for each sink whose filter selects it. Sinks are
+// isolated from each other, so errors and panics of one sink or its filter are
+// recorded in its stats and the event is still delivered to the others. The
+// event is dropped for sinks whose buffer is full.
+func (s *scanner) fanOut(event *Event) {
+	var redacted *Event
+	for i, sink := range s.config.Sinks {
+		stats := &s.sinks[i]
+		selected, err := selects(sink, event)
+		if err != nil {
+			atomic.AddUint64(&stats.Errors, 1)
+			s.log.Warnw("Failed to send event to sink", "sink", stats.Name,
+				"file_path", event.Path, "error", err)
+			continue
+		}
+		if !selected {
+			continue
+		}
+		if redacted == nil {
+			e := s.config.RedactFields.redactEvent(*event)
+			redacted = &e
+		}
+
+		select {
+		case s.sinkQueues[i] <- *redacted:
+		default:
+			atomic.AddUint64(&stats.Dropped, 1)
+		}
+	}
+}
+
+// selects returns true if the filter of the sink selects the event. A panic of
+// the filter is returned as an error.
+func selects(sink SinkConfig, event *Event) (selected bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			selected, err = false, errors.Errorf("sink filter panicked: %v", r)
+		}
+	}()
+	return sink.Filter == nil || sink.Filter(event), nil
+}
+
+// send sends the event to the sink. A panic of the sink is returned as an
+// error.
+func send(sink SinkConfig, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("sink panicked: %v", r)
+		}
+	}()
+	return sink.Sink.Send(event)
+}
+
+// sinkSummary returns a snapshot of the stats of the sinks.
+func (s *scanner) sinkSummary() []sinkStats {
+	summary := make([]sinkStats, len(s.sinks))
+	for i := range s.sinks {
+		stats := &s.sinks[i]
+		summary[i] = sinkStats{
+			Name:    stats.Name,
+			Sent:    atomic.LoadUint64(&stats.Sent),
+			Errors:  atomic.LoadUint64(&stats.Errors),
+			Dropped: atomic.LoadUint64(&stats.Dropped),
+		}
+	}
+	return summary
+}
+
+// newSinkStats returns the stats of the configured sinks. Unnamed sinks are
+// named by their index.
+func newSinkStats(sinks []SinkConfig) []sinkStats {
+	if len(sinks) == 0 {
+		return nil
+	}
+	stats := make([]sinkStats, len(sinks))
+	for i, sink := range sinks {
+		stats[i].Name = sink.Name
+		if stats[i].Name == "" {
+			stats[i].Name = fmt.Sprintf("sinks[%d]", i)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,203 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink records the base names of the events sent to it.
+type recordingSink struct {
+	paths []string
+}
+
+func (s *recordingSink) Send(event Event) error {
+	s.paths = append(s.paths, filepath.Base(event.Path))
+	return nil
+}
+
+// failingSink fails every other send and panics on the others.
+type failingSink struct {
+	calls int
+}
+
+func (s *failingSink) Send(event Event) error {
+	s.calls++
+	if s.calls%2 == 0 {
+		panic("broken sink")
+	}
+	return errors.New("sink unavailable")
+}
+
+func TestScannerSinks(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The world-writable file with an unknown reputation is the high-value
+	// event.
+	unknown := filepath.Join(dir, "unknown")
+	if err = ioutil.WriteFile(unknown, []byte("not allowlisted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(unknown, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	alerting, archival, failing := &recordingSink{}, &recordingSink{}, &failingSink{}
+	config := defaultConfig
+	config.ReputationLookup = NewAllowlistLookup(SHA1,
+		[]Digest{sha1Digest("file a"), sha1Digest("file b"), sha1Digest("file c")}, 0.0001, nil)
+	config.Sinks = []SinkConfig{
+		{Name: "failing", Sink: failing},
+		{
+			Name: "alerting",
+			Sink: alerting,
+			Filter: func(e *Event) bool {
+				return e.Reputation == UnknownReputation ||
+					(e.Info != nil && e.Info.Type == FileType && e.Info.Mode&0002 != 0)
+			},
+		},
+		{Sink: archival},
+	}
+	config.Paths = []string{dir}
+	config.Recursive = true
+
+	s, events := runScan(t, config)
+	var all []string
+	for _, event := range events {
+		all = append(all, filepath.Base(event.Path))
+	}
+
+	assert.Equal(t, []string{"unknown"}, alerting.paths)
+	sort.Strings(all)
+	sort.Strings(archival.paths)
+	assert.Equal(t, all, archival.paths)
+
+	// The failing sink neither stopped the scan nor the other sinks.
+	assert.Len(t, all, 8)
+	assert.Equal(t, len(all), failing.calls)
+	assert.Equal(t, []sinkStats{
+		{Name: "failing", Errors: uint64(len(all))},
+		{Name: "alerting", Sent: 1},
+		{Name: "sinks[2]", Sent: uint64(len(all))},
+	}, s.sinks)
+}
+
+// eventSink records the events sent to it.
+type eventSink struct {
+	events []Event
+}
+
+func (s *eventSink) Send(event Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestScannerSinksRedactFields(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &eventSink{}
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Sinks = []SinkConfig{{Sink: sink}}
+	config.RedactFields = RedactFields{
+		{Field: "file.owner"},
+		{Field: "file.path", Method: RedactHash, Key: "secret"},
+		{Field: "hash.sha1", Method: RedactHash},
+	}
+
+	_, events := runScan(t, config)
+	if !assert.Len(t, sink.events, len(events)) {
+		return
+	}
+
+	// The sinks receive the values that are published.
+	ms := &MetricSet{config: config}
+	for i, event := range events {
+		redacted := sink.events[i]
+		published := ms.buildEvent(&event, false).MetricSetFields
+
+		assert.NotEqual(t, event.Path, redacted.Path, "the path must be redacted")
+		path, _ := published.GetValue("file.path")
+		assert.Equal(t, path, redacted.Path)
+
+		if event.Info != nil {
+			assert.NotEmpty(t, event.Info.Owner)
+			assert.Empty(t, redacted.Info.Owner, "the owner must be removed")
+		}
+
+		if digest, found := event.Hashes[SHA1]; found {
+			assert.NotEqual(t, digest, redacted.Hashes[SHA1])
+			sha1, _ := published.GetValue("hash.sha1")
+			assert.Equal(t, sha1, redacted.Hashes[SHA1].String())
+		}
+	}
+
+	// Redacting fields that are not supported in the events sent to sinks
+	// fails instead of leaking them.
+	config.RedactFields = RedactFields{{Field: "file.uid"}}
+	_, err = NewFileSystemScanner(config)
+	assert.Error(t, err)
+}
+
+// blockingSink blocks until release is closed.
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Send(event Event) error {
+	<-s.release
+	return nil
+}
+
+func TestScannerBlockingSink(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	_, events := runScan(t, config)
+
+	blocking := &blockingSink{release: make(chan struct{})}
+	config.Sinks = []SinkConfig{{Sink: blocking, BufferSize: 1}}
+
+	reader, err := NewFileSystemScanner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventC, err := reader.Start(make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All events are emitted while the sink is blocked.
+	for range events {
+		<-eventC
+	}
+	close(blocking.release)
+	for range eventC {
+	}
+
+	stats := reader.(*scanner).sinkSummary()[0]
+	// One event is being sent and one is buffered.
+	assert.EqualValues(t, len(events), stats.Sent+stats.Dropped)
+	assert.True(t, stats.Sent <= 2, "expected at most 2 sent events, got %d", stats.Sent)
+	assert.Zero(t, stats.Errors)
+}
@@ -0,0 +1,111 @@
+package file_integrity
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot is the state of the files found by a scan keyed by their path. It
+// allows diffing two scans without a persistent state store.
+type Snapshot map[string]*Event
+
+// ReadSnapshot collects the events received from eventC until it is closed.
+// Events that do not describe a scanned file, like skip and rollup events,
+// are ignored.
+func ReadSnapshot(eventC <-chan Event) Snapshot {
+	snapshot := Snapshot{}
+	for event := range eventC {
+		if event.Info == nil || event.Rollup != nil || event.Skipped || event.Vanished {
+			continue
+		}
+		e := event
+		snapshot[e.Path] = &e
+	}
+	return snapshot
+}
+
+// DiffSnapshots returns an event for each file that was created, modified,
+// deleted, or moved between the old and the new snapshot, ordered by path. A
+// deleted and a created file are reported as a single Moved event of the new
+// path if they have the same inode and are otherwise unchanged.
+// Inodes of deleted files are reused so a changed file is reported as deleted
+// and created. Changes are classified the same way as changes relative to the
+// state store.
+func DiffSnapshots(old, new Snapshot) []Event {
+	now := time.Now().UTC()
+
+	var created, deleted []string
+	var changes []Event
+	for path, n := range new {
+		o, found := old[path]
+		if !found {
+			created = append(created, path)
+			continue
+		}
+		if action, changed := diffEvents(o, n); changed {
+			e := *n
+			e.Action = action
+			changes = append(changes, e)
+		}
+	}
+	for path := range old {
+		if _, found := new[path]; !found {
+			deleted = append(deleted, path)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(deleted)
+
+	// Match the deleted files with the created files.
+	movedFrom := map[string]*Event{}
+	for _, path := range deleted {
+		o := old[path]
+		if o.Info == nil || o.Info.Inode == 0 {
+			continue
+		}
+		for _, newPath := range created {
+			if _, matched := movedFrom[newPath]; !matched && isMove(o, new[newPath]) {
+				movedFrom[newPath] = o
+				break
+			}
+		}
+	}
+
+	moved := map[string]bool{}
+	for _, path := range created {
+		e := *new[path]
+		if o, found := movedFrom[path]; found {
+			e.Action = Moved
+			e.OldPath = o.Path
+			moved[o.Path] = true
+		} else {
+			e.Action = Created
+		}
+		changes = append(changes, e)
+	}
+	for _, path := range deleted {
+		if !moved[path] {
+			changes = append(changes, Event{
+				Timestamp: now,
+				Path:      path,
+				Source:    old[path].Source,
+				Action:    Deleted,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// isMove returns true if the file described by new is the file described by
+// old at a different path.
+func isMove(old, new *Event) bool {
+	if new.Info == nil || new.Info.Inode != old.Info.Inode {
+		return false
+	}
+	renamed := *old
+	renamed.Path = new.Path
+	_, changed := diffEvents(&renamed, new)
+	return !changed
+}
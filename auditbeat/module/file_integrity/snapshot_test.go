@@ -0,0 +1,74 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.Recursive = true
+	scan := func() Snapshot {
+		_, events := runScan(t, config)
+		eventC := make(chan Event, len(events))
+		for _, event := range events {
+			eventC <- event
+		}
+		close(eventC)
+		return ReadSnapshot(eventC)
+	}
+
+	before := scan()
+	assert.Empty(t, DiffSnapshots(before, before))
+
+	// Modify a, move b, delete c, and create d.
+	if err = ioutil.WriteFile(filepath.Join(dir, "a"), []byte("file a modified"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(filepath.Join(dir, "b"), filepath.Join(dir, "b.moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "subdir", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "d"), []byte("file d"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]Event{}
+	for _, e := range DiffSnapshots(before, scan()) {
+		rel, err := filepath.Rel(dir, e.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes[rel] = e
+	}
+
+	actions := map[string]Action{}
+	for rel, e := range changes {
+		actions[rel] = e.Action
+	}
+	assert.Equal(t, map[string]Action{
+		"a":        Updated | AttributesModified,
+		"b.moved":  Moved,
+		"d":        Created,
+		"subdir/c": Deleted,
+	}, actions)
+
+	assert.Equal(t, filepath.Join(dir, "b"), changes["b.moved"].OldPath)
+	assert.NotEmpty(t, changes["d"].Hashes)
+	assert.Nil(t, changes["subdir/c"].Info)
+}
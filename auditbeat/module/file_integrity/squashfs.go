@@ -0,0 +1,746 @@
+package file_integrity
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This is a reader for squashfs 4.0 images. It allows the scanner to verify
+// the contents of an image without mounting it. Only gzip compression is
+// supported, which is the default used by mksquashfs. Other formats, such as
+// EROFS, are not supported.
+
+// squashfsPathSeparator separates the path of an image from the path of a file
+// within the image in the paths of events.
+const squashfsPathSeparator = "!"
+
+const (
+	squashfsMagic          = 0x73717368
+	squashfsSuperblockSize = 96
+
+	squashfsCompressionGzip = 1
+
+	squashfsMetadataSize       = 8192
+	squashfsMetadataUncompress = 0x8000 // Metadata block header flag.
+	squashfsDataUncompressed   = 1 << 24
+	squashfsNoFragment         = 0xffffffff
+
+	squashfsFragmentEntrySize = 16
+
+	squashfsMinBlockSize = 4 << 10
+	squashfsMaxBlockSize = 1 << 20
+
+	// squashfsMaxSymlinkSize is the longest symlink target that is accepted.
+	// Like the kernel, longer targets are treated as corruption.
+	squashfsMaxSymlinkSize = 4096
+)
+
+// Inode types.
+const (
+	squashfsDirType = iota + 1
+	squashfsFileType
+	squashfsSymlinkType
+	squashfsBlockDevType
+	squashfsCharDevType
+	squashfsFifoType
+	squashfsSocketType
+	squashfsLDirType
+	squashfsLFileType
+	squashfsLSymlinkType
+	squashfsLBlockDevType
+	squashfsLCharDevType
+	squashfsLFifoType
+	squashfsLSocketType
+)
+
+var (
+	errSquashfsUnsupportedCompression = errors.New("unsupported squashfs compression")
+	errSquashfsCorrupt                = errors.New("corrupt squashfs image")
+)
+
+type squashfsSuperblock struct {
+	Magic               uint32
+	InodeCount          uint32
+	ModificationTime    uint32
+	BlockSize           uint32
+	FragmentEntryCount  uint32
+	CompressionID       uint16
+	BlockLog            uint16
+	Flags               uint16
+	IDCount             uint16
+	VersionMajor        uint16
+	VersionMinor        uint16
+	RootInodeRef        uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrIDTableStart   uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	ExportTableStart    uint64
+}
+
+type squashfsFragment struct {
+	Start  uint64
+	Size   uint32
+	Unused uint32
+}
+
+// squashfsInode holds the parts of an inode that are needed for reporting and
+// reading its contents.
+type squashfsInode struct {
+	Type   uint16
+	Mode   uint16 // Permission bits.
+	UID    uint32
+	GID    uint32
+	MTime  uint32
+	Number uint32
+
+	// Regular files.
+	Size           uint64
+	BlocksStart    uint64
+	FragmentIndex  uint32
+	FragmentOffset uint32
+	BlockSizes     []uint32
+
+	// Directories.
+	DirBlock  uint32
+	DirOffset uint16
+	DirSize   uint32
+
+	// Symlinks.
+	Target string
+}
+
+func (in *squashfsInode) isDir() bool {
+	return in.Type == squashfsDirType || in.Type == squashfsLDirType
+}
+
+func (in *squashfsInode) isFile() bool {
+	return in.Type == squashfsFileType || in.Type == squashfsLFileType
+}
+
+func (in *squashfsInode) isSymlink() bool {
+	return in.Type == squashfsSymlinkType || in.Type == squashfsLSymlinkType
+}
+
+// metadata converts the inode to the Metadata reported in events. Names of
+// owners are not resolved because the IDs belong to the image.
+func (in *squashfsInode) metadata() *Metadata {
+	mtime := time.Unix(int64(in.MTime), 0).UTC()
+	md := &Metadata{
+		Inode:  uint64(in.Number),
+		UID:    in.UID,
+		GID:    in.GID,
+		MTime:  mtime,
+		CTime:  mtime,
+		Mode:   os.FileMode(in.Mode).Perm(),
+		SetUID: in.Mode&04000 != 0,
+		SetGID: in.Mode&02000 != 0,
+	}
+	switch {
+	case in.isFile():
+		md.Type = FileType
+		md.Size = in.Size
+	case in.isDir():
+		md.Type = DirType
+	case in.isSymlink():
+		md.Type = SymlinkType
+		md.Size = uint64(len(in.Target))
+	}
+	return md
+}
+
+// fileMode returns the inode's type and permissions as an os.FileMode.
+func (in *squashfsInode) fileMode() os.FileMode {
+	mode := os.FileMode(in.Mode).Perm()
+	if in.Mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if in.Mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if in.Mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	switch in.Type {
+	case squashfsDirType, squashfsLDirType:
+		mode |= os.ModeDir
+	case squashfsSymlinkType, squashfsLSymlinkType:
+		mode |= os.ModeSymlink
+	case squashfsBlockDevType, squashfsLBlockDevType:
+		mode |= os.ModeDevice
+	case squashfsCharDevType, squashfsLCharDevType:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case squashfsFifoType, squashfsLFifoType:
+		mode |= os.ModeNamedPipe
+	case squashfsSocketType, squashfsLSocketType:
+		mode |= os.ModeSocket
+	}
+	return mode
+}
+
+type squashfsDirEntry struct {
+	Name string
+	Ref  uint64 // Inode reference (metadata block start << 16 | offset).
+}
+
+// squashfsImage is a squashfs image opened for reading.
+type squashfsImage struct {
+	r         io.ReaderAt
+	sb        squashfsSuperblock
+	ids       []uint32
+	fragments []squashfsFragment
+}
+
+// openSquashfs reads the superblock and lookup tables of the squashfs image
+// of size bytes read from r.
+func openSquashfs(r io.ReaderAt, size int64) (*squashfsImage, error) {
+	img := &squashfsImage{r: r}
+
+	buf := make([]byte, squashfsSuperblockSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, errors.Wrap(err, "failed to read squashfs superblock")
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &img.sb); err != nil {
+		return nil, errors.Wrap(err, "failed to decode squashfs superblock")
+	}
+	if img.sb.Magic != squashfsMagic {
+		return nil, errors.New("not a squashfs image")
+	}
+	if img.sb.VersionMajor != 4 || img.sb.VersionMinor != 0 {
+		return nil, errors.Errorf("unsupported squashfs version %d.%d",
+			img.sb.VersionMajor, img.sb.VersionMinor)
+	}
+	if img.sb.CompressionID != squashfsCompressionGzip {
+		return nil, errors.Wrapf(errSquashfsUnsupportedCompression, "compression id %d",
+			img.sb.CompressionID)
+	}
+	if err := img.sb.validate(size); err != nil {
+		return nil, errors.Wrap(err, "invalid squashfs superblock")
+	}
+
+	img.ids = make([]uint32, img.sb.IDCount)
+	if err := img.readTable(img.sb.IDTableStart, len(img.ids)*4, img.ids); err != nil {
+		return nil, errors.Wrap(err, "failed to read squashfs id table")
+	}
+
+	img.fragments = make([]squashfsFragment, img.sb.FragmentEntryCount)
+	if err := img.readTable(img.sb.FragmentTableStart, len(img.fragments)*squashfsFragmentEntrySize, img.fragments); err != nil {
+		return nil, errors.Wrap(err, "failed to read squashfs fragment table")
+	}
+
+	return img, nil
+}
+
+// validate checks the fields of the superblock that sizes and offsets are
+// derived from against each other and against the size of the image so that
+// a corrupt image cannot cause excessive allocations.
+func (sb *squashfsSuperblock) validate(size int64) error {
+	if sb.BlockSize < squashfsMinBlockSize || sb.BlockSize > squashfsMaxBlockSize ||
+		sb.BlockSize&(sb.BlockSize-1) != 0 {
+		return errors.Wrapf(errSquashfsCorrupt, "invalid block size %d", sb.BlockSize)
+	}
+	if sb.BlockLog > 31 || 1<<sb.BlockLog != sb.BlockSize {
+		return errors.Wrapf(errSquashfsCorrupt, "block log %d does not match block size %d", sb.BlockLog, sb.BlockSize)
+	}
+	if size < 0 || sb.BytesUsed > uint64(size) {
+		return errors.Wrapf(errSquashfsCorrupt, "bytes used (%d) exceeds the image size (%d)", sb.BytesUsed, size)
+	}
+	for _, start := range []uint64{sb.InodeTableStart, sb.DirectoryTableStart, sb.FragmentTableStart, sb.IDTableStart} {
+		if start >= sb.BytesUsed {
+			return errors.Wrapf(errSquashfsCorrupt, "table start %d is outside of the image", start)
+		}
+	}
+	// Every fragment is stored in the image so the fragment table cannot be
+	// larger than the image.
+	if uint64(sb.FragmentEntryCount)*squashfsFragmentEntrySize > sb.BytesUsed {
+		return errors.Wrapf(errSquashfsCorrupt, "fragment count %d exceeds the image size", sb.FragmentEntryCount)
+	}
+	return nil
+}
+
+// readTable decodes a lookup table of size bytes into data. The table is
+// stored in consecutive metadata blocks that are located by the list of
+// block pointers found at start.
+func (img *squashfsImage) readTable(start uint64, size int, data interface{}) error {
+	if size == 0 {
+		return nil
+	}
+
+	var first [8]byte
+	if _, err := img.r.ReadAt(first[:], int64(start)); err != nil {
+		return err
+	}
+
+	mr, err := img.metadataReader(binary.LittleEndian.Uint64(first[:]), 0)
+	if err != nil {
+		return err
+	}
+	return binary.Read(mr, binary.LittleEndian, data)
+}
+
+// block reads a block of size bytes at offset and decompresses it. Neither the
+// stored nor the decompressed block may be larger than limit.
+func (img *squashfsImage) block(offset int64, size uint32, compressed bool, limit int) ([]byte, error) {
+	if int64(size) > int64(limit) {
+		return nil, errors.Wrapf(errSquashfsCorrupt, "block of %d bytes exceeds %d bytes", size, limit)
+	}
+	if offset < 0 || uint64(offset)+uint64(size) > img.sb.BytesUsed {
+		return nil, errors.Wrapf(errSquashfsCorrupt, "block at %d is outside of the image", offset)
+	}
+	buf := make([]byte, size)
+	if _, err := img.r.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return buf, nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress squashfs block")
+	}
+	defer zr.Close()
+
+	// Read one byte more than the limit to detect blocks that decompress to
+	// more data.
+	data, err := ioutil.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress squashfs block")
+	}
+	if len(data) > limit {
+		return nil, errors.Wrapf(errSquashfsCorrupt, "block decompresses to more than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// squashfsMetadataReader reads a stream of consecutive metadata blocks.
+type squashfsMetadataReader struct {
+	img  *squashfsImage
+	next int64 // Position of the next metadata block.
+	buf  []byte
+}
+
+// metadataReader returns a reader that starts at offset within the
+// uncompressed metadata block located at position start.
+func (img *squashfsImage) metadataReader(start uint64, offset uint16) (*squashfsMetadataReader, error) {
+	mr := &squashfsMetadataReader{img: img, next: int64(start)}
+	if err := mr.fill(); err != nil {
+		return nil, err
+	}
+	if int(offset) > len(mr.buf) {
+		return nil, errors.Errorf("squashfs metadata offset %d out of range", offset)
+	}
+	mr.buf = mr.buf[offset:]
+	return mr, nil
+}
+
+func (mr *squashfsMetadataReader) fill() error {
+	var header [2]byte
+	if _, err := mr.img.r.ReadAt(header[:], mr.next); err != nil {
+		return errors.Wrap(err, "failed to read squashfs metadata block header")
+	}
+
+	h := binary.LittleEndian.Uint16(header[:])
+	size := uint32(h &^ squashfsMetadataUncompress)
+	data, err := mr.img.block(mr.next+2, size, h&squashfsMetadataUncompress == 0, squashfsMetadataSize)
+	if err != nil {
+		return err
+	}
+
+	mr.next += 2 + int64(size)
+	mr.buf = data
+	return nil
+}
+
+func (mr *squashfsMetadataReader) Read(p []byte) (int, error) {
+	if len(mr.buf) == 0 {
+		if err := mr.fill(); err != nil {
+			return 0, err
+		}
+		if len(mr.buf) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	n := copy(p, mr.buf)
+	mr.buf = mr.buf[n:]
+	return n, nil
+}
+
+// inode reads the inode referenced by ref.
+func (img *squashfsImage) inode(ref uint64) (*squashfsInode, error) {
+	mr, err := img.metadataReader(img.sb.InodeTableStart+ref>>16, uint16(ref))
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Type, Mode, UIDIndex, GIDIndex uint16
+		MTime, Number                  uint32
+	}
+	if err = binary.Read(mr, binary.LittleEndian, &header); err != nil {
+		return nil, errors.Wrap(err, "failed to read squashfs inode")
+	}
+	if int(header.UIDIndex) >= len(img.ids) || int(header.GIDIndex) >= len(img.ids) {
+		return nil, errors.New("squashfs inode has invalid id index")
+	}
+
+	in := &squashfsInode{
+		Type:   header.Type,
+		Mode:   header.Mode,
+		UID:    img.ids[header.UIDIndex],
+		GID:    img.ids[header.GIDIndex],
+		MTime:  header.MTime,
+		Number: header.Number,
+	}
+
+	read := func(data ...interface{}) {
+		for _, d := range data {
+			if err == nil {
+				err = binary.Read(mr, binary.LittleEndian, d)
+			}
+		}
+	}
+
+	var hardLinks, xattr, parent uint32
+	switch in.Type {
+	case squashfsDirType:
+		var size uint16
+		read(&in.DirBlock, &hardLinks, &size, &in.DirOffset, &parent)
+		in.DirSize = uint32(size)
+	case squashfsLDirType:
+		var indexCount uint16
+		read(&hardLinks, &in.DirSize, &in.DirBlock, &parent, &indexCount, &in.DirOffset, &xattr)
+	case squashfsFileType:
+		var start, size uint32
+		read(&start, &in.FragmentIndex, &in.FragmentOffset, &size)
+		in.BlocksStart, in.Size = uint64(start), uint64(size)
+	case squashfsLFileType:
+		var sparse uint64
+		read(&in.BlocksStart, &in.Size, &sparse, &hardLinks, &in.FragmentIndex, &in.FragmentOffset, &xattr)
+	case squashfsSymlinkType, squashfsLSymlinkType:
+		var size uint32
+		read(&hardLinks, &size)
+		if err == nil && size > squashfsMaxSymlinkSize {
+			err = errors.Wrapf(errSquashfsCorrupt, "symlink target of %d bytes", size)
+		}
+		if err == nil {
+			target := make([]byte, size)
+			if _, err = io.ReadFull(mr, target); err == nil {
+				in.Target = string(target)
+			}
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read squashfs inode")
+	}
+
+	if in.isFile() {
+		blocks := in.Size / uint64(img.sb.BlockSize)
+		if in.FragmentIndex == squashfsNoFragment && in.Size%uint64(img.sb.BlockSize) != 0 {
+			blocks++
+		}
+		// The block list is stored in the image.
+		if blocks*4 > img.sb.BytesUsed {
+			return nil, errors.Wrapf(errSquashfsCorrupt, "file of %d bytes exceeds the image size", in.Size)
+		}
+		in.BlockSizes = make([]uint32, blocks)
+		if err = binary.Read(mr, binary.LittleEndian, in.BlockSizes); err != nil {
+			return nil, errors.Wrap(err, "failed to read squashfs block list")
+		}
+	}
+	return in, nil
+}
+
+// readDir returns the entries of the directory in the order they are stored,
+// which is sorted by name.
+func (img *squashfsImage) readDir(dir *squashfsInode) ([]squashfsDirEntry, error) {
+	// The stored size includes the implicit "." and ".." entries.
+	if dir.DirSize <= 3 {
+		return nil, nil
+	}
+
+	mr, err := img.metadataReader(img.sb.DirectoryTableStart+uint64(dir.DirBlock), dir.DirOffset)
+	if err != nil {
+		return nil, err
+	}
+	r := io.LimitReader(mr, int64(dir.DirSize-3))
+
+	var entries []squashfsDirEntry
+	for {
+		var header struct{ Count, Start, Number uint32 }
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, errors.Wrap(err, "failed to read squashfs directory header")
+		}
+
+		for i := uint32(0); i <= header.Count; i++ {
+			var entry struct {
+				Offset      uint16
+				InodeOffset int16
+				Type        uint16
+				NameSize    uint16
+			}
+			if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+				return nil, errors.Wrap(err, "failed to read squashfs directory entry")
+			}
+			name := make([]byte, int(entry.NameSize)+1)
+			if _, err := io.ReadFull(r, name); err != nil {
+				return nil, errors.Wrap(err, "failed to read squashfs directory entry")
+			}
+			entries = append(entries, squashfsDirEntry{
+				Name: string(name),
+				Ref:  uint64(header.Start)<<16 | uint64(entry.Offset),
+			})
+		}
+	}
+}
+
+// walk calls fn for every inode in the image in depth-first order, starting
+// with the root directory whose path is "/". If fn returns filepath.SkipDir
+// for a directory its contents are not visited. Directories cannot be hard
+// linked so a directory that is reached twice, such as one that lists one of
+// its ancestors, is an error.
+func (img *squashfsImage) walk(fn func(path string, in *squashfsInode) error) error {
+	root, err := img.inode(img.sb.RootInodeRef)
+	if err != nil {
+		return err
+	}
+	visited := map[uint64]struct{}{}
+	return img.walkInode("/", img.sb.RootInodeRef, root, visited, fn)
+}
+
+func (img *squashfsImage) walkInode(p string, ref uint64, in *squashfsInode, visited map[uint64]struct{}, fn func(string, *squashfsInode) error) error {
+	if in.isDir() {
+		if _, found := visited[ref]; found {
+			return errors.Wrapf(errSquashfsCorrupt, "directory %v is reached twice", p)
+		}
+		visited[ref] = struct{}{}
+	}
+
+	if err := fn(p, in); err != nil || !in.isDir() {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	entries, err := img.readDir(in)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read directory %v", p)
+	}
+	for _, entry := range entries {
+		child, err := img.inode(entry.Ref)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read inode of %v", path.Join(p, entry.Name))
+		}
+		if err = img.walkInode(path.Join(p, entry.Name), entry.Ref, child, visited, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns a reader for the contents of the regular file.
+func (img *squashfsImage) open(in *squashfsInode) io.Reader {
+	return &squashfsFileReader{img: img, in: in, next: int64(in.BlocksStart), remaining: in.Size}
+}
+
+type squashfsFileReader struct {
+	img       *squashfsImage
+	in        *squashfsInode
+	block     int   // Index of the next block.
+	next      int64 // Position of the next data block.
+	remaining uint64
+	buf       []byte
+}
+
+func (fr *squashfsFileReader) Read(p []byte) (int, error) {
+	if len(fr.buf) == 0 {
+		if fr.remaining == 0 {
+			return 0, io.EOF
+		}
+		if err := fr.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, fr.buf)
+	fr.buf = fr.buf[n:]
+	return n, nil
+}
+
+func (fr *squashfsFileReader) fill() error {
+	blockSize := uint64(fr.img.sb.BlockSize)
+	want := fr.remaining
+	if want > blockSize {
+		want = blockSize
+	}
+
+	var data []byte
+	var err error
+	if fr.block < len(fr.in.BlockSizes) {
+		sizeInfo := fr.in.BlockSizes[fr.block]
+		size := sizeInfo &^ squashfsDataUncompressed
+		fr.block++
+		if size == 0 {
+			// Sparse block.
+			data = make([]byte, want)
+		} else {
+			data, err = fr.img.block(fr.next, size, sizeInfo&squashfsDataUncompressed == 0, int(fr.img.sb.BlockSize))
+			fr.next += int64(size)
+		}
+	} else {
+		data, err = fr.fragment()
+		if err == nil {
+			offset := uint64(fr.in.FragmentOffset)
+			if offset+want > uint64(len(data)) {
+				return errors.New("squashfs fragment is truncated")
+			}
+			data = data[offset:]
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read squashfs data block")
+	}
+	if uint64(len(data)) < want {
+		return errors.New("squashfs data block is truncated")
+	}
+
+	fr.buf = data[:want]
+	fr.remaining -= want
+	return nil
+}
+
+func (fr *squashfsFileReader) fragment() ([]byte, error) {
+	index := fr.in.FragmentIndex
+	if index == squashfsNoFragment || int(index) >= len(fr.img.fragments) {
+		return nil, errors.New("squashfs file has no fragment for its tail")
+	}
+	frag := fr.img.fragments[index]
+	size := frag.Size &^ squashfsDataUncompressed
+	return fr.img.block(int64(frag.Start), size, frag.Size&squashfsDataUncompressed == 0, int(fr.img.sb.BlockSize))
+}
+
+// scanSquashfs generates events for the contents of the squashfs image.
+func (s *scanner) scanSquashfs(image string) error {
+	f, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	img, err := openSquashfs(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	err = img.walk(func(p string, in *squashfsInode) error {
+		if err := s.waitIfPaused(); err != nil {
+			return err
+		}
+
+		path := image + squashfsPathSeparator + p
+
+		// The contents of images are filtered like the files of the paths.
+		if rule, prune := s.config.filterPath(path); rule != "" {
+			if s.config.EmitSkips {
+				if err := s.send(newSkipEvent(path, rule)); err != nil {
+					return err
+				}
+			}
+			if in.isDir() && !prune {
+				return nil
+			}
+			return filepath.SkipDir
+		}
+		if s.config.RequirePermissions != 0 && !s.config.RequirePermissions.Matches(in.fileMode()) {
+			return nil
+		}
+		if in.isFile() && !s.config.Sampling.selected(path) {
+			return nil
+		}
+
+		event := s.newSquashfsEvent(img, path, in)
+		if err := s.send(event); err != nil {
+			return err
+		}
+
+		// Throttle reading and hashing rate.
+		if len(event.Hashes) > 0 {
+			s.throttle(event.Info.Size)
+		}
+		return nil
+	})
+	if err == errDone {
+		s.markPartial(RootPartialError)
+		err = nil
+	}
+	return err
+}
+
+// newSquashfsEvent returns the event for the inode of the image. Like for
+// the files of the paths, the file is hashed within the limits of the config,
+// its reputation is looked up, and it is categorized. Files in images are
+// never quarantined.
+func (s *scanner) newSquashfsEvent(img *squashfsImage, path string, in *squashfsInode) Event {
+	if s.ring != nil {
+		s.ring.Begin(path)
+	}
+
+	event := Event{
+		Timestamp:  time.Now().UTC(),
+		Path:       path,
+		TargetPath: in.Target,
+		Info:       in.metadata(),
+		Source:     SourceScan,
+		Action:     None,
+	}
+
+	if s.config.IncludeModeString {
+		event.ModeString = lsModeString(in.fileMode())
+	}
+	s.describe(&event)
+
+	if s.hashSquashfsContent(img, in) {
+		start := time.Now()
+		hashes, err := hashReader(img.open(in), s.config.HashTypes...)
+		if err != nil {
+			event.errors = append(event.errors, err)
+		} else {
+			event.Hashes = hashes
+			s.recordHashTime(&event, time.Since(start))
+		}
+	}
+
+	s.lookupReputation(&event)
+	s.finish(&event)
+	return event
+}
+
+// hashSquashfsContent returns true if the contents of the inode are hashed.
+// It applies the limits that hashContent applies to the files of the paths.
+func (s *scanner) hashSquashfsContent(img *squashfsImage, in *squashfsInode) bool {
+	if !in.isFile() || in.Size > s.config.MaxFileSizeBytes {
+		return false
+	}
+	if s.config.HashExecutablesOnly {
+		return in.Mode&0111 != 0 || hasExecutableMagic(img.open(in))
+	}
+	return true
+}
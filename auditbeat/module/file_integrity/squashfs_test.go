@@ -0,0 +1,672 @@
+package file_integrity
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common/match"
+)
+
+type squashfsTestNode struct {
+	Path    string // Absolute path within the image.
+	Mode    uint16
+	UID     uint32
+	Content []byte
+	Target  string // Symlink target.
+	Dir     bool
+}
+
+func TestScannerSquashfs(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rnd.Read(b)
+		return b
+	}
+
+	nodes := []squashfsTestNode{
+		{Path: "/bin", Dir: true, Mode: 0755},
+		{Path: "/bin/busybox", Mode: 04755, Content: random(3*4096 + 100)},
+		{Path: "/bin/sh", Target: "busybox", Mode: 0777},
+		{Path: "/etc", Dir: true, Mode: 0755},
+		{Path: "/etc/passwd", Mode: 0644, Content: []byte("root:x:0:0:root:/root:/bin/sh\n")},
+		{Path: "/etc/empty", Mode: 0600, UID: 1000},
+		{Path: "/var", Dir: true, Mode: 01777},
+		{Path: "/var/sparse", Mode: 0644, Content: append(make([]byte, 4096), []byte("tail")...)},
+		{Path: "/var/text", Mode: 0644, Content: bytes.Repeat([]byte("compressible "), 1000)},
+		{Path: "/many", Dir: true, Mode: 0755},
+	}
+	// Enough entries for the inode, directory, and fragment tables to span
+	// multiple blocks.
+	for i := 0; i < 700; i++ {
+		nodes = append(nodes, squashfsTestNode{
+			Path:    fmt.Sprintf("/many/file-%03d", i),
+			Mode:    0644,
+			Content: []byte(fmt.Sprintf("contents of file number %03d\n", i)),
+		})
+	}
+
+	dir, err := ioutil.TempDir("", "audit-file-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "firmware.sqsh")
+	if err = ioutil.WriteFile(image, buildSquashfs(t, nodes), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = nil
+	config.SquashfsImages = []string{image}
+	config.HashTypes = []HashType{SHA1}
+
+	_, list := runScan(t, config)
+	events := map[string]Event{}
+	for _, event := range list {
+		assert.Empty(t, event.errors, event.Path)
+		events[event.Path] = event
+	}
+
+	assert.Len(t, events, len(nodes)+1)
+	if root, found := events[image+"!/"]; assert.True(t, found, "root dir") {
+		assert.Equal(t, DirType, root.Info.Type)
+	}
+
+	for _, n := range nodes {
+		e, found := events[image+"!"+n.Path]
+		if !assert.True(t, found, n.Path) || !assert.NotNil(t, e.Info, n.Path) {
+			continue
+		}
+
+		assert.Equal(t, SourceScan, e.Source)
+		assert.EqualValues(t, n.UID, e.Info.UID, n.Path)
+		assert.Equal(t, os.FileMode(n.Mode).Perm(), e.Info.Mode, n.Path)
+		assert.Equal(t, n.Mode&04000 != 0, e.Info.SetUID, n.Path)
+		switch {
+		case n.Dir:
+			assert.Equal(t, DirType, e.Info.Type, n.Path)
+		case n.Target != "":
+			assert.Equal(t, SymlinkType, e.Info.Type, n.Path)
+			assert.Equal(t, n.Target, e.TargetPath, n.Path)
+		default:
+			assert.Equal(t, FileType, e.Info.Type, n.Path)
+			assert.EqualValues(t, len(n.Content), e.Info.Size, n.Path)
+			sum := sha1.Sum(n.Content)
+			assert.Equal(t, Digest(sum[:]), e.Hashes[SHA1], n.Path)
+		}
+	}
+}
+
+func TestScannerSquashfsFilters(t *testing.T) {
+	nodes := []squashfsTestNode{
+		{Path: "/bin", Dir: true, Mode: 0755},
+		{Path: "/bin/busybox", Mode: 04755, Content: []byte("busybox")},
+		{Path: "/etc", Dir: true, Mode: 0755},
+		{Path: "/etc/app.conf", Mode: 0644, Content: []byte("app")},
+		{Path: "/etc/passwd", Mode: 0644, Content: []byte("root")},
+	}
+
+	dir, err := ioutil.TempDir("", "audit-file-scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := filepath.Join(dir, "firmware.sqsh")
+	if err = ioutil.WriteFile(image, buildSquashfs(t, nodes), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := func(config Config) []string {
+		_, events := runScan(t, config)
+		var paths []string
+		for _, event := range events {
+			paths = append(paths, strings.TrimPrefix(event.Path, image))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// The root filter of the path containing the image applies to its
+	// contents, so the excluded etc dir is still walked for includes.
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.SquashfsImages = []string{image}
+	config.RootFilters = RootFilters{{
+		Path:         dir,
+		IncludeFiles: []match.Matcher{match.MustCompile(`\.conf$`)},
+		ExcludeFiles: []match.Matcher{match.MustCompile(`!/etc$`)},
+		Precedence:   IncludeWins,
+	}}
+	if err = config.RootFilters.validate(config.Paths); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"!/etc/app.conf"}, paths(config))
+
+	config = defaultConfig
+	config.Paths = nil
+	config.SquashfsImages = []string{image}
+	config.RequirePermissions = 04000
+	assert.Equal(t, []string{"!/bin/busybox"}, paths(config))
+}
+
+func TestSquashfsDeletedFiles(t *testing.T) {
+	defer setup(t)()
+
+	dir, err := ioutil.TempDir("", "audit-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := filepath.Join(dir, "firmware.sqsh")
+	nodes := []squashfsTestNode{
+		{Path: "/a", Mode: 0644, Content: []byte("a")},
+		{Path: "/b", Mode: 0644, Content: []byte("b")},
+	}
+	write := func(nodes []squashfsTestNode) {
+		if err := ioutil.WriteFile(image, buildSquashfs(t, nodes), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	empty := filepath.Join(dir, "empty")
+	if err = os.Mkdir(empty, 0700); err != nil {
+		t.Fatal(err)
+	}
+	config := getConfig(empty)
+	config["squashfs_images"] = []string{image}
+
+	// The events of the empty path, the root dir of the image, and its files.
+	write(nodes)
+	assert.Len(t, runMetricSet(t, config, len(nodes)+2), len(nodes)+2)
+
+	// Files removed from the image are reported as deleted.
+	write(nodes[:1])
+	events := runMetricSet(t, config, 1)
+	if assert.Len(t, events, 1) {
+		fields := events[0].MetricSetFields
+		p, err := fields.GetValue("file.path")
+		if assert.NoError(t, err) {
+			assert.Equal(t, image+"!/b", p)
+		}
+		action, err := fields.GetValue("event.action")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"deleted"}, action)
+		}
+	}
+}
+
+func TestOpenSquashfsUnsupportedCompression(t *testing.T) {
+	image := buildSquashfs(t, nil)
+	binary.LittleEndian.PutUint16(image[20:], 4) // xz
+
+	_, err := openSquashfs(bytes.NewReader(image), int64(len(image)))
+	assert.Equal(t, errSquashfsUnsupportedCompression, errors.Cause(err))
+}
+
+func TestOpenSquashfsCorruptSuperblock(t *testing.T) {
+	le := binary.LittleEndian
+	for name, corrupt := range map[string]func(image []byte){
+		"zero block size":       func(image []byte) { le.PutUint32(image[12:], 0) },
+		"block size too small":  func(image []byte) { le.PutUint32(image[12:], 2048); le.PutUint16(image[22:], 11) },
+		"block size too large":  func(image []byte) { le.PutUint32(image[12:], 2<<20); le.PutUint16(image[22:], 21) },
+		"block size not pow2":   func(image []byte) { le.PutUint32(image[12:], 6000) },
+		"block log mismatch":    func(image []byte) { le.PutUint16(image[22:], 13) },
+		"bytes used":            func(image []byte) { le.PutUint64(image[40:], uint64(len(image)+1)) },
+		"inode table start":     func(image []byte) { le.PutUint64(image[64:], uint64(len(image))) },
+		"huge fragment count":   func(image []byte) { le.PutUint32(image[16:], 0xffffffff) },
+		"fragment count > size": func(image []byte) { le.PutUint32(image[16:], uint32(len(image)/squashfsFragmentEntrySize+1)) },
+	} {
+		image := buildSquashfs(t, nil)
+		corrupt(image)
+		_, err := openSquashfs(bytes.NewReader(image), int64(len(image)))
+		assert.Equal(t, errSquashfsCorrupt, errors.Cause(err), name)
+	}
+
+	// The image may be truncated.
+	image := buildSquashfs(t, nil)
+	_, err := openSquashfs(bytes.NewReader(image), int64(len(image)-1))
+	assert.Equal(t, errSquashfsCorrupt, errors.Cause(err), "truncated")
+}
+
+// squashfsInodeOffset returns the position of the inode of the file at p in
+// an image built by buildSquashfs, whose inode table is not compressed.
+func squashfsInodeOffset(t *testing.T, image []byte, p string) int {
+	img, err := openSquashfs(bytes.NewReader(image), int64(len(image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := img.sb.RootInodeRef
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		dir, err := img.inode(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := img.readDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, entry := range entries {
+			if entry.Name == name {
+				ref, found = entry.Ref, true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("%v not found", p)
+		}
+	}
+	return int(img.sb.InodeTableStart + ref>>16 + 2 + ref&0xffff)
+}
+
+func TestSquashfsCorruptInodes(t *testing.T) {
+	le := binary.LittleEndian
+	nodes := []squashfsTestNode{
+		{Path: "/bin", Dir: true, Mode: 0755},
+		{Path: "/bin/sh", Target: "busybox", Mode: 0777},
+		{Path: "/file", Mode: 0644, Content: []byte("contents")},
+	}
+	walk := func(image []byte) error {
+		img, err := openSquashfs(bytes.NewReader(image), int64(len(image)))
+		if err != nil {
+			return err
+		}
+		return img.walk(func(string, *squashfsInode) error { return nil })
+	}
+	if err := walk(buildSquashfs(t, nodes)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("symlink target size", func(t *testing.T) {
+		image := buildSquashfs(t, nodes)
+		// The target size follows the common header and the link count.
+		le.PutUint32(image[squashfsInodeOffset(t, image, "/bin/sh")+20:], 0xffffffff)
+		assert.Equal(t, errSquashfsCorrupt, errors.Cause(walk(image)))
+	})
+
+	t.Run("file size", func(t *testing.T) {
+		image := buildSquashfs(t, nodes)
+		// The size of a basic file is the fourth field after the header.
+		le.PutUint32(image[squashfsInodeOffset(t, image, "/file")+28:], 0xffffffff)
+		le.PutUint32(image[squashfsInodeOffset(t, image, "/file")+20:], squashfsNoFragment)
+		assert.Equal(t, errSquashfsCorrupt, errors.Cause(walk(image)))
+	})
+
+	t.Run("directory cycle", func(t *testing.T) {
+		// Make /bin list the contents of the root directory, which contains
+		// /bin itself.
+		image := buildSquashfs(t, nodes)
+		root := squashfsInodeOffset(t, image, "/")
+		bin := squashfsInodeOffset(t, image, "/bin")
+		copy(image[bin+16:bin+28], image[root+16:root+28])
+		assert.Equal(t, errSquashfsCorrupt, errors.Cause(walk(image)))
+	})
+}
+
+func TestSquashfsDecompressionLimit(t *testing.T) {
+	// 1 MiB of zeros compresses to about 1 KiB.
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(make([]byte, 1<<20))
+	w.Close()
+	data := buf.Bytes()
+
+	img := &squashfsImage{r: bytes.NewReader(data)}
+	img.sb.BytesUsed = uint64(len(data))
+
+	_, err := img.block(0, uint32(len(data)), true, squashfsMetadataSize)
+	assert.Equal(t, errSquashfsCorrupt, errors.Cause(err), "metadata block")
+	_, err = img.block(0, uint32(len(data)), true, 4096)
+	assert.Equal(t, errSquashfsCorrupt, errors.Cause(err), "data block")
+	block, err := img.block(0, uint32(len(data)), true, 1<<20)
+	if assert.NoError(t, err) {
+		assert.Len(t, block, 1<<20)
+	}
+
+	// Blocks must be within the image and not larger than the limit.
+	_, err = img.block(1, uint32(len(data)), true, 1<<20)
+	assert.Equal(t, errSquashfsCorrupt, errors.Cause(err), "outside of the image")
+	_, err = img.block(0, uint32(len(data)), true, len(data)-1)
+	assert.Equal(t, errSquashfsCorrupt, errors.Cause(err), "stored block too large")
+}
+
+// buildSquashfs creates a squashfs 4.0 image with gzip compression containing
+// the given nodes. Parent directories must be listed before their children.
+// The inode table is stored uncompressed so that inode references can be
+// computed before the directory table is written. Full data blocks of zeros
+// are stored as sparse blocks, files of more than two blocks use extended
+// inodes and store their tail in a data block, and other tails are packed
+// into fragments.
+func buildSquashfs(t testing.TB, nodes []squashfsTestNode) []byte {
+	const (
+		blockSize = 4096
+		blockLog  = 12
+		mtime     = 1500000000
+	)
+
+	type inode struct {
+		squashfsTestNode
+		number   uint32
+		parent   *inode
+		children []*inode
+		ref      uint64
+		data     []byte // Serialized inode without the directory fields.
+
+		// Regular files.
+		start      uint64
+		blockSizes []uint32
+		fragIndex  uint32
+		fragOffset uint32
+		extended   bool
+	}
+
+	le := binary.LittleEndian
+	var out bytes.Buffer
+	out.Write(make([]byte, squashfsSuperblockSize))
+
+	compress := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+
+	// writeMetadata stores data as consecutive metadata blocks and returns
+	// the disk positions of the blocks.
+	writeMetadata := func(data []byte, compressed bool) []uint64 {
+		var positions []uint64
+		for len(data) > 0 {
+			n := len(data)
+			if n > squashfsMetadataSize {
+				n = squashfsMetadataSize
+			}
+			chunk := data[:n]
+			data = data[n:]
+
+			header := uint16(len(chunk)) | squashfsMetadataUncompress
+			if compressed {
+				chunk = compress(chunk)
+				header = uint16(len(chunk))
+			}
+			positions = append(positions, uint64(out.Len()))
+			binary.Write(&out, le, header)
+			out.Write(chunk)
+		}
+		return positions
+	}
+
+	// writeTable stores a lookup table followed by its block pointers and
+	// returns the position of the pointers.
+	writeTable := func(data []byte) uint64 {
+		positions := writeMetadata(data, true)
+		start := uint64(out.Len())
+		binary.Write(&out, le, positions)
+		return start
+	}
+
+	// Build the tree.
+	root := &inode{squashfsTestNode: squashfsTestNode{Path: "/", Dir: true, Mode: 0755}}
+	byPath := map[string]*inode{"/": root}
+	all := []*inode{root}
+	for _, n := range nodes {
+		parent, found := byPath[path.Dir(n.Path)]
+		if !found {
+			t.Fatalf("parent of %v not found", n.Path)
+		}
+		in := &inode{squashfsTestNode: n, parent: parent}
+		parent.children = append(parent.children, in)
+		byPath[n.Path] = in
+		all = append(all, in)
+	}
+	for i, in := range all {
+		in.number = uint32(i + 1)
+		sort.Slice(in.children, func(a, b int) bool {
+			return path.Base(in.children[a].Path) < path.Base(in.children[b].Path)
+		})
+	}
+
+	// Data blocks and fragments.
+	var fragments []squashfsFragment
+	var fragment []byte
+	flushFragment := func() {
+		if len(fragment) == 0 {
+			return
+		}
+		entry := squashfsFragment{Start: uint64(out.Len())}
+		data := compress(fragment)
+		entry.Size = uint32(len(data))
+		out.Write(data)
+		fragments = append(fragments, entry)
+		fragment = nil
+	}
+	for _, in := range all {
+		if in.Dir || in.Target != "" {
+			continue
+		}
+		content := in.Content
+		in.extended = len(content) > 2*blockSize
+		in.start = uint64(out.Len())
+		in.fragIndex = squashfsNoFragment
+
+		for len(content) > 0 {
+			n := len(content)
+			if n > blockSize {
+				n = blockSize
+			} else if n < blockSize && !in.extended {
+				break
+			}
+			block := content[:n]
+			content = content[n:]
+
+			switch data := compress(block); {
+			case bytes.Equal(block, make([]byte, blockSize)):
+				in.blockSizes = append(in.blockSizes, 0)
+			case len(data) < len(block):
+				in.blockSizes = append(in.blockSizes, uint32(len(data)))
+				out.Write(data)
+			default:
+				in.blockSizes = append(in.blockSizes, uint32(len(block))|squashfsDataUncompressed)
+				out.Write(block)
+			}
+		}
+
+		if len(content) > 0 {
+			if len(fragment)+len(content) > blockSize {
+				flushFragment()
+			}
+			in.fragIndex = uint32(len(fragments))
+			in.fragOffset = uint32(len(fragment))
+			fragment = append(fragment, content...)
+		}
+	}
+	flushFragment()
+
+	// Serialize the inodes. Directory inodes have a fixed size so their
+	// contents can be filled in once the directory table is known.
+	var inodeTable []byte
+	for _, in := range all {
+		var buf bytes.Buffer
+		typ := uint16(squashfsFileType)
+		switch {
+		case in.Dir:
+			typ = squashfsDirType
+		case in.Target != "":
+			typ = squashfsSymlinkType
+		case in.extended:
+			typ = squashfsLFileType
+		}
+		uidIndex := uint16(0)
+		if in.UID != 0 {
+			uidIndex = 1
+		}
+		binary.Write(&buf, le, []uint16{typ, in.Mode, uidIndex, 0})
+		binary.Write(&buf, le, []uint32{mtime, in.number})
+
+		switch typ {
+		case squashfsDirType:
+			buf.Write(make([]byte, 16))
+		case squashfsSymlinkType:
+			binary.Write(&buf, le, []uint32{1, uint32(len(in.Target))})
+			buf.WriteString(in.Target)
+		case squashfsFileType:
+			binary.Write(&buf, le, []uint32{uint32(in.start), in.fragIndex, in.fragOffset, uint32(len(in.Content))})
+			binary.Write(&buf, le, in.blockSizes)
+		case squashfsLFileType:
+			binary.Write(&buf, le, []uint64{in.start, uint64(len(in.Content)), 0})
+			binary.Write(&buf, le, []uint32{1, in.fragIndex, in.fragOffset, 0xffffffff})
+			binary.Write(&buf, le, in.blockSizes)
+		}
+
+		offset := len(inodeTable)
+		block := uint64(offset / squashfsMetadataSize * (2 + squashfsMetadataSize))
+		in.ref = block<<16 | uint64(offset%squashfsMetadataSize)
+		in.data = buf.Bytes()
+		inodeTable = append(inodeTable, in.data...)
+	}
+
+	// Directory listings.
+	var dirTable []byte
+	type dirPos struct{ offset, size int }
+	listings := map[*inode]dirPos{}
+	for _, in := range all {
+		if !in.Dir {
+			continue
+		}
+		var buf bytes.Buffer
+		for i := 0; i < len(in.children); {
+			// A run of entries shares the metadata block of their inodes.
+			j := i + 1
+			for j < len(in.children) && j-i < 256 && in.children[j].ref>>16 == in.children[i].ref>>16 {
+				j++
+			}
+			base := in.children[i].number
+			binary.Write(&buf, le, []uint32{uint32(j - i - 1), uint32(in.children[i].ref >> 16), base})
+			for _, child := range in.children[i:j] {
+				typ := uint16(squashfsFileType)
+				if child.Dir {
+					typ = squashfsDirType
+				} else if child.Target != "" {
+					typ = squashfsSymlinkType
+				}
+				name := path.Base(child.Path)
+				binary.Write(&buf, le, uint16(child.ref))
+				binary.Write(&buf, le, int16(child.number-base))
+				binary.Write(&buf, le, []uint16{typ, uint16(len(name) - 1)})
+				buf.WriteString(name)
+			}
+			i = j
+		}
+		listings[in] = dirPos{len(dirTable), buf.Len()}
+		dirTable = append(dirTable, buf.Bytes()...)
+	}
+
+	// The directory table is compressed so its block positions are only known
+	// after compressing it.
+	var dirBlocks []uint64
+	{
+		var scratch bytes.Buffer
+		data := dirTable
+		for len(data) > 0 {
+			n := len(data)
+			if n > squashfsMetadataSize {
+				n = squashfsMetadataSize
+			}
+			dirBlocks = append(dirBlocks, uint64(scratch.Len()))
+			scratch.Write(make([]byte, 2))
+			scratch.Write(compress(data[:n]))
+			data = data[n:]
+		}
+	}
+	for in, pos := range listings {
+		parent := in.parent
+		if parent == nil {
+			parent = in
+		}
+		var block uint32
+		if pos.size > 0 {
+			block = uint32(dirBlocks[pos.offset/squashfsMetadataSize])
+		}
+		var fields bytes.Buffer
+		binary.Write(&fields, le, block)
+		binary.Write(&fields, le, uint32(2+len(in.children)))
+		binary.Write(&fields, le, []uint16{uint16(pos.size + 3), uint16(pos.offset % squashfsMetadataSize)})
+		binary.Write(&fields, le, parent.number)
+		copy(in.data[16:], fields.Bytes())
+	}
+	inodeTable = inodeTable[:0]
+	for _, in := range all {
+		inodeTable = append(inodeTable, in.data...)
+	}
+
+	var sb squashfsSuperblock
+	sb.InodeTableStart = uint64(out.Len())
+	writeMetadata(inodeTable, false)
+	sb.DirectoryTableStart = uint64(out.Len())
+	if positions := writeMetadata(dirTable, true); len(positions) != len(dirBlocks) {
+		t.Fatal("unexpected number of directory blocks")
+	}
+
+	var fragTable bytes.Buffer
+	binary.Write(&fragTable, le, fragments)
+	sb.FragmentTableStart = writeTable(fragTable.Bytes())
+
+	var idTable bytes.Buffer
+	binary.Write(&idTable, le, []uint32{0, 1000})
+	sb.IDTableStart = writeTable(idTable.Bytes())
+
+	sb.Magic = squashfsMagic
+	sb.InodeCount = uint32(len(all))
+	sb.ModificationTime = mtime
+	sb.BlockSize = blockSize
+	sb.FragmentEntryCount = uint32(len(fragments))
+	sb.CompressionID = squashfsCompressionGzip
+	sb.BlockLog = blockLog
+	sb.IDCount = 2
+	sb.VersionMajor = 4
+	sb.RootInodeRef = root.ref
+	sb.XattrIDTableStart = ^uint64(0)
+	sb.ExportTableStart = ^uint64(0)
+	sb.BytesUsed = uint64(out.Len())
+
+	image := out.Bytes()
+	var header bytes.Buffer
+	binary.Write(&header, le, sb)
+	copy(image, header.Bytes())
+
+	if !strings.HasPrefix(string(image), "hsqs") {
+		t.Fatal("bad magic")
+	}
+	return image
+}
@@ -0,0 +1,166 @@
+package file_integrity
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// scanSummary describes a completed scan. It is logged when the scan completes
+// and published as an event by the MetricSet when PublishScanSummary is
+// enabled. Optional parts are nil or zero when they are not configured.
+type scanSummary struct {
+	Start         time.Time
+	Took          time.Duration
+	FileCount     uint64
+	TotalBytes    uint64
+	MaxDepth      int
+	AvgDepth      float64
+	RootStatus    []rootStatus
+	ResourceUsage resourceUsage
+
+	ExpensiveHashPeakConcurrency int // Only with ExpensiveHashes.MaxConcurrent.
+	BackpressureWait             *time.Duration
+	Sinks                        []sinkStats
+	Provenance                   *provenance
+	LargestFiles                 []topNEntry // Only with SummaryTopN.
+	SlowestFiles                 []topNEntry // Only with SummaryTopN.
+}
+
+// summarize returns the summary of the scan that started at start.
+func (s *scanner) summarize(start time.Time) *scanSummary {
+	summary := &scanSummary{
+		Start:         start.UTC(),
+		Took:          time.Since(start),
+		FileCount:     atomic.LoadUint64(&s.fileCount),
+		TotalBytes:    atomic.LoadUint64(&s.byteCount),
+		MaxDepth:      s.depth.max,
+		AvgDepth:      s.depth.avg(),
+		RootStatus:    s.roots,
+		ResourceUsage: s.usage,
+	}
+	if s.config.ExpensiveHashes.MaxConcurrent > 0 {
+		summary.ExpensiveHashPeakConcurrency = s.expensive.Peak()
+	}
+	if s.backpressure != nil {
+		summary.BackpressureWait = &s.backpressure.waited
+	}
+	if len(s.sinks) > 0 {
+		summary.Sinks = s.sinkSummary()
+	}
+	if s.config.IncludeProvenance {
+		p := s.provenance()
+		summary.Provenance = &p
+	}
+	if s.config.SummaryTopN > 0 {
+		summary.LargestFiles = s.largest.Entries()
+		summary.SlowestFiles = s.slowest.Entries()
+	}
+	return summary
+}
+
+// complete returns true if all configured roots were scanned completely.
+func (s *scanSummary) complete() bool {
+	for _, root := range s.RootStatus {
+		if root.Status != RootComplete {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *scanSummary) bytesPerSec() float64 {
+	return float64(s.TotalBytes) / float64(s.Took) * float64(time.Second)
+}
+
+func (s *scanSummary) filesPerSec() float64 {
+	return float64(s.FileCount) / float64(s.Took) * float64(time.Second)
+}
+
+// keysAndValues returns the summary as the context of a log message.
+func (s *scanSummary) keysAndValues() []interface{} {
+	kv := []interface{}{
+		"took", s.Took,
+		"file_count", s.FileCount,
+		"total_bytes", s.TotalBytes,
+		"bytes_per_sec", s.bytesPerSec(),
+		"files_per_sec", s.filesPerSec(),
+		"max_depth", s.MaxDepth,
+		"avg_depth", s.AvgDepth,
+		"root_status", s.RootStatus,
+		"resource_usage", s.ResourceUsage,
+	}
+	if s.ExpensiveHashPeakConcurrency > 0 {
+		kv = append(kv, "expensive_hash_peak_concurrency", s.ExpensiveHashPeakConcurrency)
+	}
+	if s.BackpressureWait != nil {
+		kv = append(kv, "backpressure_wait", *s.BackpressureWait)
+	}
+	if s.Sinks != nil {
+		kv = append(kv, "sinks", s.Sinks)
+	}
+	if s.Provenance != nil {
+		kv = append(kv, "provenance", *s.Provenance)
+	}
+	if s.LargestFiles != nil || s.SlowestFiles != nil {
+		kv = append(kv,
+			"largest_files", s.LargestFiles,
+			"slowest_files", s.SlowestFiles)
+	}
+	return kv
+}
+
+// buildScanSummaryEvent builds the event that publishes the summary of a
+// completed scan. The comparison of the Merkle root is included unless it is
+// nil.
+func buildScanSummaryEvent(s *scanSummary, merkle *merkleSummary) mb.Event {
+	summary := common.MapStr{
+		"start":          s.Start,
+		"complete":       s.complete(),
+		"file_count":     s.FileCount,
+		"total_bytes":    s.TotalBytes,
+		"bytes_per_sec":  s.bytesPerSec(),
+		"files_per_sec":  s.filesPerSec(),
+		"max_depth":      s.MaxDepth,
+		"avg_depth":      s.AvgDepth,
+		"root_status":    s.RootStatus,
+		"resource_usage": s.ResourceUsage,
+	}
+	if s.ExpensiveHashPeakConcurrency > 0 {
+		summary["expensive_hash_peak_concurrency"] = s.ExpensiveHashPeakConcurrency
+	}
+	if s.BackpressureWait != nil {
+		summary["backpressure_wait_sec"] = s.BackpressureWait.Seconds()
+	}
+	if s.Sinks != nil {
+		summary["sinks"] = s.Sinks
+	}
+	if s.Provenance != nil {
+		summary["provenance"] = *s.Provenance
+	}
+	if s.LargestFiles != nil || s.SlowestFiles != nil {
+		summary["largest_files"] = s.LargestFiles
+		summary["slowest_files"] = s.SlowestFiles
+	}
+	if merkle != nil {
+		m := common.MapStr{
+			"root":       merkle.Root,
+			"leaf_count": merkle.LeafCount,
+			"changed":    merkle.changed(),
+		}
+		if merkle.PreviousRoot != nil {
+			m["previous_root"] = merkle.PreviousRoot
+		}
+		summary["merkle"] = m
+	}
+
+	return mb.Event{
+		Timestamp: time.Now().UTC(),
+		Took:      s.Took,
+		MetricSetFields: common.MapStr{
+			"scan_summary": summary,
+		},
+	}
+}
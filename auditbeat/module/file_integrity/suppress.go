@@ -0,0 +1,49 @@
+package file_integrity
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+)
+
+// SuppressHashes lists the hex encoded digests of known noise files, like
+// placeholder configs. Events of files with any of these digests are flagged
+// as suppressed by the scanner and are not published.
+type SuppressHashes []string
+
+// validate validates the digests and converts them to lower case.
+func (h SuppressHashes) validate() error {
+	var errs multierror.Errors
+	for i, digest := range h {
+		h[i] = strings.ToLower(digest)
+		if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+			errs = append(errs, errors.Errorf("invalid suppress_hashes value '%v'", digest))
+		}
+	}
+	return errs.Err()
+}
+
+// set returns the digests as a set. It returns nil if there are none.
+func (h SuppressHashes) set() map[string]struct{} {
+	if len(h) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(h))
+	for _, digest := range h {
+		set[digest] = struct{}{}
+	}
+	return set
+}
+
+// isSuppressed returns true if any of the hashes of the event is one of the
+// SuppressHashes.
+func (s *scanner) isSuppressed(event *Event) bool {
+	for _, digest := range event.Hashes {
+		if _, found := s.suppress[digest.String()]; found {
+			return true
+		}
+	}
+	return false
+}
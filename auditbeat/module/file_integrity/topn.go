@@ -0,0 +1,65 @@
+package file_integrity
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// topNEntry is a path and the value it is ranked by.
+type topNEntry struct {
+	Path  string  `json:"path"`
+	Value float64 `json:"value"`
+}
+
+// topNHeap is a min-heap of entries so that the smallest of the retained
+// entries can be evicted in O(log n).
+type topNHeap []topNEntry
+
+func (h topNHeap) Len() int            { return len(h) }
+func (h topNHeap) Less(i, j int) bool  { return h[i].Value < h[j].Value }
+func (h topNHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topNHeap) Push(x interface{}) { *h = append(*h, x.(topNEntry)) }
+func (h *topNHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// topN retains the n entries with the largest values using O(n) memory.
+type topN struct {
+	n    int
+	heap topNHeap
+}
+
+func newTopN(n int) *topN {
+	if n < 0 {
+		n = 0
+	}
+	return &topN{n: n, heap: make(topNHeap, 0, n)}
+}
+
+// Add offers the entry to the list. It is only retained if its value is
+// among the n largest seen so far.
+func (t *topN) Add(path string, value float64) {
+	if t.n <= 0 {
+		return
+	}
+	if len(t.heap) < t.n {
+		heap.Push(&t.heap, topNEntry{Path: path, Value: value})
+		return
+	}
+	if value > t.heap[0].Value {
+		t.heap[0] = topNEntry{Path: path, Value: value}
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+// Entries returns the retained entries ordered from largest to smallest.
+func (t *topN) Entries() []topNEntry {
+	entries := append([]topNEntry(nil), t.heap...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Value > entries[j].Value
+	})
+	return entries
+}
@@ -0,0 +1,54 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopN(t *testing.T) {
+	top := newTopN(3)
+	for i, v := range []float64{5, 1, 9, 3, 7, 2, 8} {
+		top.Add(strconv.Itoa(i), v)
+		assert.True(t, len(top.heap) <= 3, "heap must be bounded")
+	}
+
+	assert.Equal(t, []topNEntry{{"2", 9}, {"6", 8}, {"4", 7}}, top.Entries())
+	assert.Equal(t, 3, cap(top.heap), "heap must not grow")
+
+	disabled := newTopN(0)
+	disabled.Add("a", 1)
+	assert.Empty(t, disabled.Entries())
+}
+
+func TestScannerSummaryTopN(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, size := range []int{4096, 100, 8192, 2048} {
+		name := filepath.Join(dir, "sized"+strconv.Itoa(i))
+		if err = ioutil.WriteFile(name, make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+	config.SummaryTopN = 2
+
+	s, _ := runScan(t, config)
+	assert.Equal(t, []topNEntry{
+		{filepath.Join(dir, "sized2"), 8192},
+		{filepath.Join(dir, "sized0"), 4096},
+	}, s.largest.Entries())
+	assert.Len(t, s.slowest.Entries(), 2)
+}
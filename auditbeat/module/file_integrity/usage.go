@@ -0,0 +1,22 @@
+package file_integrity
+
+// resourceUsage is the CPU time and IO consumed by the process. Fields that
+// cannot be measured on the platform are zero.
+type resourceUsage struct {
+	UserCPUSec       float64 `json:"user_cpu_sec"`
+	SystemCPUSec     float64 `json:"system_cpu_sec"`
+	ReadBytes        uint64  `json:"read_bytes"`         // Bytes returned by read syscalls, including reads from the page cache.
+	StorageReadBytes uint64  `json:"storage_read_bytes"` // Bytes fetched from storage.
+	ReadSyscalls     uint64  `json:"read_syscalls"`
+}
+
+// sub returns the usage between start and u.
+func (u resourceUsage) sub(start resourceUsage) resourceUsage {
+	return resourceUsage{
+		UserCPUSec:       u.UserCPUSec - start.UserCPUSec,
+		SystemCPUSec:     u.SystemCPUSec - start.SystemCPUSec,
+		ReadBytes:        u.ReadBytes - start.ReadBytes,
+		StorageReadBytes: u.StorageReadBytes - start.StorageReadBytes,
+		ReadSyscalls:     u.ReadSyscalls - start.ReadSyscalls,
+	}
+}
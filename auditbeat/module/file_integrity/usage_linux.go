@@ -0,0 +1,63 @@
+// +build linux
+
+package file_integrity
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// procSelfIO holds the IO accounting of the process. It is a variable so that
+// it can be replaced in tests.
+var procSelfIO = "/proc/self/io"
+
+// processResourceUsage returns the CPU time (getrusage) and IO (/proc/self/io)
+// consumed by the process so far.
+func processResourceUsage() (resourceUsage, error) {
+	var u resourceUsage
+
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return u, errors.Wrap(err, "getrusage failed")
+	}
+	u.UserCPUSec = float64(ru.Utime.Nano()) / 1e9
+	u.SystemCPUSec = float64(ru.Stime.Nano()) / 1e9
+
+	f, err := os.Open(procSelfIO)
+	if err != nil {
+		return u, errors.Wrap(err, "failed to read IO accounting")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: name: value
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		var dst *uint64
+		switch fields[0] {
+		case "rchar:":
+			dst = &u.ReadBytes
+		case "read_bytes:":
+			dst = &u.StorageReadBytes
+		case "syscr:":
+			dst = &u.ReadSyscalls
+		default:
+			continue
+		}
+		if *dst, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return u, errors.Wrapf(err, "invalid %v value in %v", fields[0], procSelfIO)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return u, errors.Wrap(err, "failed to read IO accounting")
+	}
+	return u, nil
+}
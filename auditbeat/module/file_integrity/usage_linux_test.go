@@ -0,0 +1,65 @@
+// +build linux
+
+package file_integrity
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerResourceUsage(t *testing.T) {
+	if _, err := processResourceUsage(); err != nil {
+		t.Skipf("resource usage is not available: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "audit-file-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err = ioutil.WriteFile(filepath.Join(dir, "data"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig
+	config.Paths = []string{dir}
+
+	s, _ := runScan(t, config)
+	usage := s.usage
+	// The byte count includes the size of the directory, which is not read,
+	// and the scanner reads a few small files like the mount table.
+	assert.InDelta(t, float64(s.byteCount), float64(usage.ReadBytes), float64(s.byteCount)/10)
+	assert.NotZero(t, usage.ReadSyscalls)
+	assert.True(t, usage.UserCPUSec+usage.SystemCPUSec >= 0)
+}
+
+func TestProcessResourceUsageParse(t *testing.T) {
+	f, err := ioutil.TempFile("", "audit-file-io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("rchar: 100\nwchar: 5\nsyscr: 3\nsyscw: 1\nread_bytes: 40\nwrite_bytes: 0\n")
+	f.Close()
+
+	defer func(orig string) { procSelfIO = orig }(procSelfIO)
+	procSelfIO = f.Name()
+
+	u, err := processResourceUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 100, u.ReadBytes)
+	assert.EqualValues(t, 40, u.StorageReadBytes)
+	assert.EqualValues(t, 3, u.ReadSyscalls)
+
+	assert.Equal(t, resourceUsage{ReadBytes: 60, ReadSyscalls: 2}, u.sub(resourceUsage{ReadBytes: 40, StorageReadBytes: 40, ReadSyscalls: 1, UserCPUSec: u.UserCPUSec, SystemCPUSec: u.SystemCPUSec}))
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package file_integrity
+
+// processResourceUsage is not supported on this platform and always returns
+// zero usage and no error.
+func processResourceUsage() (resourceUsage, error) {
+	return resourceUsage{}, nil
+}
@@ -0,0 +1,78 @@
+package file_integrity
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Policies for files that disappear while being scanned.
+const (
+	VanishedSkip  = "skip"  // Do not report the file.
+	VanishedEmit  = "emit"  // Report a vanished event.
+	VanishedRetry = "retry" // Retry before reporting a vanished event.
+)
+
+// isVanished returns true if collecting the info of the event failed because
+// the file no longer exists.
+func isVanished(event *Event) bool {
+	for _, err := range event.errors {
+		if os.IsNotExist(errors.Cause(err)) {
+			return true
+		}
+	}
+	return false
+}
+
+// newVanishedEvent returns an event reporting that path disappeared between
+// being enumerated and being read by the scanner.
+func newVanishedEvent(path string) Event {
+	return Event{
+		Timestamp: time.Now().UTC(),
+		Path:      path,
+		Source:    SourceScan,
+		Vanished:  true,
+	}
+}
+
+// lstatVanished checks whether a vanished path reappeared. It is a variable so
+// that tests can replace it.
+var lstatVanished = os.Lstat
+
+// vanished applies the configured policy to a path that disappeared during
+// the scan. With the retry policy it returns the info of the path if it
+// reappears, in which case the path must be visited again. Otherwise it
+// returns the event to report and false if nothing should be reported.
+func (s *scanner) vanished(path string) (os.FileInfo, Event, bool) {
+	s.log.Debugw("File vanished during scan", "file_path", path,
+		"policy", s.config.VanishedFiles)
+
+	switch s.config.VanishedFiles {
+	case VanishedEmit:
+		return nil, newVanishedEvent(path), true
+	case VanishedRetry:
+		for i := 0; i < s.config.VanishedRetries; i++ {
+			timer := time.NewTimer(s.config.VanishedRetryDelay)
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+				return nil, Event{}, false
+			}
+
+			// The file may have been replaced (e.g. by an atomic rename).
+			info, err := lstatVanished(path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, Event{}, false
+			}
+			return info, Event{}, false
+		}
+		return nil, newVanishedEvent(path), true
+	default:
+		return nil, Event{}, false
+	}
+}
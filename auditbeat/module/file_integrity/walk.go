@@ -0,0 +1,94 @@
+package file_integrity
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Orders in which the scanner traverses directories.
+const (
+	TraversalDepthFirst   = "depthfirst"
+	TraversalBreadthFirst = "breadthfirst"
+)
+
+// walkBreadthFirst is like filepath.Walk but it visits all entries of a
+// directory before descending into any of its subdirectories, so files are
+// visited in order of increasing depth. Directories whose walkFn returns nil
+// are queued and entries are visited in lexical order. Returning
+// filepath.SkipDir for a directory skips its contents and for a file skips the
+// remaining entries of its directory.
+func walkBreadthFirst(root string, walkFn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walkFn(root, info, nil)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	if err != nil || info == nil || !info.IsDir() {
+		return err
+	}
+
+	type dirEntry struct {
+		path string
+		info os.FileInfo
+	}
+	queue := []dirEntry{{root, info}}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue[0] = dirEntry{}
+		queue = queue[1:]
+
+		names, err := readDirNames(dir.path)
+		if err != nil {
+			if err = walkFn(dir.path, dir.info, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		for _, name := range names {
+			path := filepath.Join(dir.path, name)
+			info, err := os.Lstat(path)
+			if err != nil {
+				if err = walkFn(path, info, err); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			}
+
+			err = walkFn(path, info, nil)
+			if err == filepath.SkipDir {
+				if info.IsDir() {
+					continue
+				}
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				queue = append(queue, dirEntry{path, info})
+			}
+		}
+	}
+	return nil
+}
+
+// readDirNames returns the sorted names of the entries of the directory.
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
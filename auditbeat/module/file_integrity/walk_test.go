@@ -0,0 +1,115 @@
+package file_integrity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannerBreadthFirst(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.MkdirAll(filepath.Join(dir, "subdir", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join("subdir", "x", "deep"), "z"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan := func(order string) []string {
+		config := defaultConfig
+		config.Paths = []string{dir}
+		config.Recursive = true
+		config.TraversalOrder = order
+
+		_, events := runScan(t, config)
+		var paths []string
+		for _, event := range events {
+			rel, err := filepath.Rel(dir, event.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return paths
+	}
+
+	depth := func(rel string) int {
+		if rel == "." {
+			return 0
+		}
+		return strings.Count(rel, "/") + 1
+	}
+
+	breadthFirst := scan(TraversalBreadthFirst)
+	assert.Equal(t, []string{
+		".", "a", "b", "link_to_b", "link_to_subdir", "subdir", "z",
+		"subdir/c", "subdir/x",
+		"subdir/x/deep",
+	}, breadthFirst)
+	for i := 1; i < len(breadthFirst); i++ {
+		assert.True(t, depth(breadthFirst[i-1]) <= depth(breadthFirst[i]),
+			"%v is emitted before the shallower %v", breadthFirst[i-1], breadthFirst[i])
+	}
+
+	// Both orders cover the same files.
+	depthFirst := scan(TraversalDepthFirst)
+	assert.NotEqual(t, breadthFirst, depthFirst)
+	sort.Strings(breadthFirst)
+	sort.Strings(depthFirst)
+	assert.Equal(t, depthFirst, breadthFirst)
+}
+
+func TestWalkBreadthFirstSkipDir(t *testing.T) {
+	dir := setupTestDir(t)
+	defer os.RemoveAll(dir)
+
+	var visited []string
+	err := walkBreadthFirst(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		visited = append(visited, filepath.ToSlash(rel))
+		switch rel {
+		case "subdir":
+			return filepath.SkipDir
+		case "b":
+			// Skips the remaining entries of the directory.
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{".", "a", "b"}, visited)
+
+	err = walkBreadthFirst(filepath.Join(dir, "missing"), func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestConfigTraversalOrder(t *testing.T) {
+	c := defaultConfig
+	c.Paths = []string{"/tmp"}
+	c.TraversalOrder = TraversalBreadthFirst
+	c.DirRollup = true
+	assert.Error(t, c.Validate())
+
+	c.TraversalOrder = "random"
+	c.DirRollup = false
+	assert.Error(t, c.Validate())
+}